@@ -0,0 +1,108 @@
+package stt_challenge
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// maxVocabularySize is the largest vocabulary the admin API accepts, in bytes.
+const maxVocabularySize = 1 << 20
+
+// registerAdminRoutes adds the admin API to mux. Every route needs the
+// admin token as a bearer token, and is not found while there is none.
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/vocabularies", s.requireAdmin(s.handleListVocabularies))
+	mux.HandleFunc("GET /admin/vocabularies/{name}", s.requireAdmin(s.handleGetVocabulary))
+	mux.HandleFunc("PUT /admin/vocabularies/{name}", s.requireAdmin(s.handlePutVocabulary))
+	mux.HandleFunc("DELETE /admin/vocabularies/{name}", s.requireAdmin(s.handleDeleteVocabulary))
+	mux.HandleFunc("POST /admin/reload", s.requireAdmin(s.handleReload))
+}
+
+// requireAdmin rejects requests without the admin token. The token is the
+// one of the current configuration, so that reloading can change it.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.config().AdminToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleListVocabularies(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Vocabularies []string `json:"vocabularies"`
+	}{s.config().Vocabularies.List()})
+}
+
+func (s *Server) handleGetVocabulary(w http.ResponseWriter, r *http.Request) {
+	v, ok := s.config().Vocabularies.Get(r.PathValue("name"))
+	if !ok {
+		http.Error(w, ErrVocabularyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, v)
+}
+
+func (s *Server) handlePutVocabulary(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var v Vocabulary
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVocabularySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		http.Error(w, "invalid vocabulary: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The name comes from the path, and may be left out of the body.
+	if v.Name == "" {
+		v.Name = name
+	}
+	if v.Name != name {
+		http.Error(w, "vocabulary name doesn't match the path", http.StatusBadRequest)
+		return
+	}
+	if err := v.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.config().Vocabularies.Put(v); err != nil {
+		s.log.Printf("Failed to store vocabulary %q: %v\n", name, err)
+		http.Error(w, "failed to store vocabulary", http.StatusInternalServerError)
+		return
+	}
+	s.log.Printf("Stored vocabulary %q\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteVocabulary(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	err := s.config().Vocabularies.Delete(name)
+	if errors.Is(err, ErrVocabularyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.Printf("Failed to delete vocabulary %q: %v\n", name, err)
+		http.Error(w, "failed to delete vocabulary", http.StatusInternalServerError)
+		return
+	}
+	s.log.Printf("Deleted vocabulary %q\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,158 @@
+package stt_challenge
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	server := NewWithConfig("8081", cfg)
+	server.log = log.New(io.Discard, "", 0)
+
+	testServer := httptest.NewServer(server.srv.Handler)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func adminRequest(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAdmin_Vocabularies(t *testing.T) {
+	testServer := newAdminTestServer(t)
+	url := testServer.URL + "/admin/vocabularies"
+
+	body, err := json.Marshal(medical)
+	require.NoError(t, err)
+	resp := adminRequest(t, http.MethodPut, url+"/medical", "secret", string(body))
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = adminRequest(t, http.MethodGet, url, "secret", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list struct {
+		Vocabularies []string `json:"vocabularies"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, []string{"medical"}, list.Vocabularies)
+
+	resp = adminRequest(t, http.MethodGet, url+"/medical", "secret", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var got Vocabulary
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, medical, got)
+
+	resp = adminRequest(t, http.MethodDelete, url+"/medical", "secret", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = adminRequest(t, http.MethodGet, url+"/medical", "secret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = adminRequest(t, http.MethodDelete, url+"/medical", "secret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAdmin_PutVocabularyErrors(t *testing.T) {
+	testServer := newAdminTestServer(t)
+	url := testServer.URL + "/admin/vocabularies/"
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{
+			name: "invalid json",
+			path: "medical",
+			body: `{`,
+		},
+		{
+			name: "unknown field",
+			path: "medical",
+			body: `{"phrases": [{"text": "tort"}], "weight": 3}`,
+		},
+		{
+			name: "name mismatch",
+			path: "medical",
+			body: `{"name": "legal", "phrases": [{"text": "tort"}]}`,
+		},
+		{
+			name: "invalid name",
+			path: "Medical",
+			body: `{"phrases": [{"text": "tort"}]}`,
+		},
+		{
+			name: "invalid vocabulary",
+			path: "medical",
+			body: `{"phrases": [{"text": "take ${pills}"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := adminRequest(t, http.MethodPut, url+tt.path, "secret", tt.body)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	testServer := newAdminTestServer(t)
+	url := testServer.URL + "/admin/vocabularies"
+
+	resp := adminRequest(t, http.MethodGet, url, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = adminRequest(t, http.MethodGet, url, "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Without a token, there is no admin API at all
+	server := New("8081")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/vocabularies", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	server.srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdmin_TokenReloaded(t *testing.T) {
+	server := New("8081")
+	server.log = log.New(io.Discard, "", 0)
+	testServer := httptest.NewServer(server.srv.Handler)
+	defer testServer.Close()
+	url := testServer.URL + "/admin/vocabularies"
+
+	resp := adminRequest(t, http.MethodGet, url, "secret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// A reload setting a token turns the admin API on
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	server.Reload(cfg)
+	resp = adminRequest(t, http.MethodGet, url, "secret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// and one dropping it turns it off again
+	server.Reload(DefaultConfig())
+	resp = adminRequest(t, http.MethodGet, url, "secret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,319 @@
+package stt_challenge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// Default queue sizes. 100 chunks of audio is a few seconds of it.
+const (
+	defaultAudioQueueSize  = 100
+	defaultResultQueueSize = 100
+)
+
+// overflowRecheck is how often the audioDistributor, held up by providers
+// that are all behind, checks whether some caught up, leaving others behind.
+const overflowRecheck = 10 * time.Millisecond
+
+// ErrSlowClient is returned by ProviderSelector.ReceiveTranscription once
+// its results queued up past the result queue, with the SlowClient policy
+// OverflowDisconnect.
+var ErrSlowClient = errors.New("client too slow to keep up with its results")
+
+// OverflowPolicy is what a ProviderSelector does once a queue is full,
+// because whoever takes from it is too slow.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest drops the oldest entry of the queue to make room
+	// for the new one. Whoever is slow misses some, but catches up.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+
+	// OverflowDisconnect cuts whoever is slow off. A provider gets no more
+	// audio, and is finished to return what it already transcribed. A
+	// client is disconnected, and its session ended.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// ParseOverflowPolicy parses the name of an overflow policy.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(s); policy {
+	case OverflowDropOldest, OverflowDisconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q: want drop_oldest or disconnect", s)
+}
+
+// BackpressureConfig configures the queues of a ProviderSelector, so that a
+// slow provider doesn't hold up the others, and a slow client doesn't hold
+// up its providers.
+type BackpressureConfig struct {
+	// AudioQueueSize is how many chunks of audio every provider may fall
+	// behind. Zero means 100.
+	AudioQueueSize int `yaml:"audio_queue_size" json:"audio_queue_size"`
+
+	// SlowProvider is what happens once a provider falls further behind,
+	// while another keeps up. Audio coming in faster than every provider
+	// takes it, like a file sent as fast as possible, holds the client up
+	// instead. Empty means OverflowDropOldest. Only raw audio can lose
+	// chunks, so containers and compressed streams are always disconnected.
+	SlowProvider OverflowPolicy `yaml:"slow_provider" json:"slow_provider"`
+
+	// ResultQueueSize is how many results the client may fall behind.
+	// Zero means 100.
+	ResultQueueSize int `yaml:"result_queue_size" json:"result_queue_size"`
+
+	// SlowClient is what happens once the client falls further behind.
+	// Empty means OverflowDropOldest.
+	SlowClient OverflowPolicy `yaml:"slow_client" json:"slow_client"`
+}
+
+func (c BackpressureConfig) effectiveAudioQueueSize() int {
+	if c.AudioQueueSize <= 0 {
+		return defaultAudioQueueSize
+	}
+	return c.AudioQueueSize
+}
+
+func (c BackpressureConfig) effectiveResultQueueSize() int {
+	if c.ResultQueueSize <= 0 {
+		return defaultResultQueueSize
+	}
+	return c.ResultQueueSize
+}
+
+// slowProviderPolicy returns the SlowProvider policy for audio in the given
+// encoding. Dropping a chunk of a container or compressed stream, maybe its
+// header, would corrupt the rest of it, so those are disconnected instead.
+func (c BackpressureConfig) slowProviderPolicy(encoding providers.AudioEncoding) OverflowPolicy {
+	if c.SlowProvider == OverflowDisconnect || !encoding.IsRaw() {
+		return OverflowDisconnect
+	}
+	return OverflowDropOldest
+}
+
+// validate checks the config, as read from a config file.
+func (c BackpressureConfig) validate() error {
+	if c.AudioQueueSize < 0 || c.ResultQueueSize < 0 {
+		return errors.New("negative queue size")
+	}
+	for _, policy := range []OverflowPolicy{c.SlowProvider, c.SlowClient} {
+		if policy == "" {
+			continue
+		}
+		if _, err := ParseOverflowPolicy(string(policy)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueStats are the depths of the queues of one or more ProviderSelectors,
+// and what their overflow policies did.
+type QueueStats struct {
+	// AudioQueued is how many chunks of audio every provider is behind,
+	// by provider name.
+	AudioQueued map[string]int `json:"audio_queued"`
+
+	// ResultsQueued is how many results the clients are behind.
+	ResultsQueued int `json:"results_queued"`
+
+	// AudioDropped is how many chunks of audio were dropped for every
+	// provider, by provider name.
+	AudioDropped map[string]int64 `json:"audio_dropped"`
+
+	// ResultsDropped is how many results were dropped for the clients.
+	ResultsDropped int64 `json:"results_dropped"`
+
+	// ProvidersDisconnected is how many times every provider was cut off,
+	// by provider name.
+	ProvidersDisconnected map[string]int64 `json:"providers_disconnected"`
+
+	// ClientsDisconnected is how many clients were cut off.
+	ClientsDisconnected int64 `json:"clients_disconnected"`
+}
+
+// add adds the stats of other to s.
+func (s *QueueStats) add(other QueueStats) {
+	s.AudioQueued = addCounts(s.AudioQueued, other.AudioQueued)
+	s.ResultsQueued += other.ResultsQueued
+	s.AudioDropped = addCounts(s.AudioDropped, other.AudioDropped)
+	s.ResultsDropped += other.ResultsDropped
+	s.ProvidersDisconnected = addCounts(s.ProvidersDisconnected, other.ProvidersDisconnected)
+	s.ClientsDisconnected += other.ClientsDisconnected
+}
+
+func addCounts[T int | int64](dst, src map[string]T) map[string]T {
+	if dst == nil {
+		dst = make(map[string]T, len(src))
+	}
+	for name, n := range src {
+		dst[name] += n
+	}
+	return dst
+}
+
+// QueueStats returns the depths of the queues of the selector, and what its
+// overflow policies did so far.
+func (ps *ProviderSelector) QueueStats() QueueStats {
+	stats := QueueStats{
+		AudioQueued:           make(map[string]int, len(ps.sessions)),
+		ResultsQueued:         len(ps.transcriptionOutput),
+		AudioDropped:          make(map[string]int64, len(ps.sessions)),
+		ResultsDropped:        ps.resultsDropped.Load(),
+		ProvidersDisconnected: make(map[string]int64),
+	}
+	for i, name := range ps.providerNames {
+		stats.AudioQueued[name] += len(ps.audioQueues[i])
+		stats.AudioDropped[name] += ps.audioDropped[i].Load()
+		if ps.disconnected[i].Load() {
+			stats.ProvidersDisconnected[name]++
+		}
+	}
+	select {
+	case <-ps.slowClient:
+		stats.ClientsDisconnected = 1
+	default:
+	}
+	return stats
+}
+
+// queueAudio queues a chunk of audio for the provider, applying the
+// SlowProvider policy if it is too far behind while another provider keeps
+// up. It is only called by the audioDistributor.
+func (ps *ProviderSelector) queueAudio(i int, audio []byte) {
+	if ps.disconnected[i].Load() {
+		return
+	}
+	queue := ps.audioQueues[i]
+	for {
+		select {
+		case queue <- audio:
+			ps.overflowing[i] = false
+			return
+		default:
+		}
+
+		if !ps.othersKeepUp(i) {
+			// Every provider is behind, so wait for this one.
+			select {
+			case queue <- audio:
+				ps.overflowing[i] = false
+				return
+			case <-time.After(overflowRecheck):
+				continue
+			case <-ps.ctx.Done():
+				return
+			}
+		}
+
+		if ps.slowProvider == OverflowDisconnect {
+			ps.log.Printf("Provider %s fell %d chunks of audio behind, disconnecting it",
+				ps.providerNames[i], cap(queue))
+			ps.disconnected[i].Store(true)
+			close(queue)
+			return
+		}
+		if !ps.overflowing[i] {
+			ps.log.Printf("Provider %s fell %d chunks of audio behind, dropping the oldest",
+				ps.providerNames[i], cap(queue))
+			ps.overflowing[i] = true
+		}
+		// The audioSender may have taken it meanwhile.
+		select {
+		case <-queue:
+			ps.audioDropped[i].Add(1)
+		default:
+		}
+	}
+}
+
+// othersKeepUp reports whether any provider other than i has room in its
+// queue.
+func (ps *ProviderSelector) othersKeepUp(i int) bool {
+	for j, queue := range ps.audioQueues {
+		if j != i && !ps.disconnected[j].Load() && len(queue) < cap(queue) {
+			return true
+		}
+	}
+	return false
+}
+
+// audioSender sends the audio queued for a provider to its session. Once
+// the queue is closed, the session is finished if the selector is, or if
+// the provider was disconnected, for it to return its last results.
+func (ps *ProviderSelector) audioSender(i int) {
+	defer ps.wg.Done()
+	session, name := ps.sessions[i], ps.providerNames[i]
+
+	for audio := range ps.audioQueues[i] {
+		// What was queued before disconnecting is dropped too.
+		if ps.disconnected[i].Load() {
+			continue
+		}
+		if err := session.SendAudio(audio); err != nil {
+			if errors.Is(err, io.EOF) {
+				continue
+			}
+			ps.log.Printf("Provider %s audio send failed: %v", name, err)
+		}
+	}
+
+	if !ps.disconnected[i].Load() {
+		select {
+		case <-ps.finishing:
+		default:
+			// Closed by Close, the sessions are about to be closed.
+			return
+		}
+	}
+	if err := session.Finish(); err != nil {
+		ps.log.Printf("Provider %s finish failed: %v", name, err)
+	}
+}
+
+// disconnectClient cuts the client off for being too slow. Whatever the
+// SlowClient policy, that is also how a client taking no result at all ends.
+func (ps *ProviderSelector) disconnectClient() {
+	ps.slowClientOnce.Do(func() { close(ps.slowClient) })
+}
+
+// emit numbers a result and queues it for ReceiveTranscription, applying the
+// SlowClient policy if the client is too far behind. Results are numbered
+// before they are queued, so that the ones dropped leave a gap. It returns
+// false once the selector is closed, or the client disconnected. It is only
+// called by the selector goroutine.
+func (ps *ProviderSelector) emit(result providers.TranscriptionResult) bool {
+	ps.emitted++
+	result.SeqNum = ps.emitted
+	for {
+		select {
+		case ps.transcriptionOutput <- result:
+			ps.resultsOverflowing = false
+			return true
+		case <-ps.ctx.Done():
+			return false
+		default:
+		}
+
+		if ps.selectorConfig.Backpressure.SlowClient == OverflowDisconnect {
+			ps.log.Printf("Client fell %d results behind, disconnecting it", cap(ps.transcriptionOutput))
+			ps.disconnectClient()
+			return false
+		}
+		if !ps.resultsOverflowing {
+			ps.log.Printf("Client fell %d results behind, dropping the oldest", cap(ps.transcriptionOutput))
+			ps.resultsOverflowing = true
+		}
+		// ReceiveTranscription may have taken it meanwhile.
+		select {
+		case <-ps.transcriptionOutput:
+			ps.resultsDropped.Add(1)
+		default:
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package stt_challenge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// newBackpressureTestProvider returns a provider whose sessions transcribe
+// nothing until they are closed.
+func newBackpressureTestProvider(t *testing.T, name string, session *mocks.MockSession) *mocks.MockProvider {
+	provider := mocks.NewMockProvider(t)
+	var sessionCtx context.Context
+	provider.EXPECT().Name().Return(name)
+	provider.EXPECT().NewSession(mock.Anything, mock.Anything).
+		RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+			sessionCtx = ctx
+			return session, nil
+		}).Once()
+	session.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		<-sessionCtx.Done()
+		return providers.TranscriptionResult{}, io.EOF
+	}).Once()
+	session.EXPECT().Close().Return(nil)
+	return provider
+}
+
+// recordedAudio is the audio a mock session was sent.
+type recordedAudio struct {
+	mu     sync.Mutex
+	chunks []string
+}
+
+func (r *recordedAudio) add(audio []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chunks = append(r.chunks, string(audio))
+}
+
+func (r *recordedAudio) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.chunks...)
+}
+
+func TestProviderSelector_SlowProvider(t *testing.T) {
+	const chunks = 10
+
+	tests := []struct {
+		name     string
+		policy   OverflowPolicy
+		encoding providers.AudioEncoding
+		want     OverflowPolicy
+	}{
+		{name: "drop oldest", policy: OverflowDropOldest, want: OverflowDropOldest},
+		{name: "disconnect", policy: OverflowDisconnect, want: OverflowDisconnect},
+		{name: "drop oldest of mulaw", policy: OverflowDropOldest, encoding: providers.EncodingMulaw, want: OverflowDropOldest},
+		// Dropping Opus pages, like its header, would corrupt the rest
+		{name: "drop oldest of opus", policy: OverflowDropOldest, encoding: providers.EncodingOggOpus, want: OverflowDisconnect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fast, slow recordedAudio
+			blocked := make(chan struct{})
+			release := make(chan struct{})
+			unblock := sync.OnceFunc(func() { close(release) })
+
+			fastSession := mocks.NewMockSession(t)
+			fastSession.EXPECT().SendAudio(mock.Anything).RunAndReturn(func(audio []byte) error {
+				fast.add(audio)
+				return nil
+			})
+			// The slow provider takes its time with the first chunk
+			slowSession := mocks.NewMockSession(t)
+			var blockOnce sync.Once
+			slowSession.EXPECT().SendAudio(mock.Anything).RunAndReturn(func(audio []byte) error {
+				blockOnce.Do(func() { close(blocked) })
+				<-release
+				slow.add(audio)
+				return nil
+			})
+			slowFinished := make(chan struct{})
+			if tt.want == OverflowDisconnect {
+				slowSession.EXPECT().Finish().RunAndReturn(func() error {
+					close(slowFinished)
+					return nil
+				}).Once()
+			}
+
+			list := []providers.Provider{
+				newBackpressureTestProvider(t, "fast", fastSession),
+				newBackpressureTestProvider(t, "slow", slowSession),
+			}
+			selectorConfig := SelectorConfig{Backpressure: BackpressureConfig{AudioQueueSize: 2, SlowProvider: tt.policy}}
+			sessionConfig := providers.SessionConfig{Encoding: tt.encoding}
+			ps, err := NewProviderSelector(list, sessionConfig, selectorConfig, log.New(io.Discard, "", 0))
+			require.NoError(t, err)
+			defer ps.Close()
+			defer unblock()
+
+			// The fast provider gets every chunk, without waiting for
+			// the slow one stuck on the first
+			for i := range chunks {
+				require.NoError(t, ps.SendAudio([]byte(fmt.Sprint(i))))
+				require.Eventually(t, func() bool {
+					return len(fast.get()) == i+1
+				}, 2*time.Second, time.Millisecond)
+				if i == 0 {
+					<-blocked
+				}
+			}
+
+			stats := ps.QueueStats()
+			switch tt.want {
+			case OverflowDropOldest:
+				// The slow provider holds one chunk, has two queued,
+				// and the others are dropped
+				assert.Equal(t, 2, stats.AudioQueued["slow"])
+				assert.Equal(t, int64(chunks-3), stats.AudioDropped["slow"])
+				assert.Zero(t, stats.AudioDropped["fast"])
+				unblock()
+				require.Eventually(t, func() bool {
+					return len(slow.get()) == 3
+				}, 2*time.Second, 5*time.Millisecond)
+				// It catches up with the latest audio
+				assert.Equal(t, []string{"8", "9"}, slow.get()[1:])
+
+			case OverflowDisconnect:
+				assert.Equal(t, int64(1), stats.ProvidersDisconnected["slow"])
+				assert.Zero(t, stats.ProvidersDisconnected["fast"])
+				assert.Zero(t, stats.AudioDropped["slow"])
+				unblock()
+				// It gets no more audio, and is finished
+				select {
+				case <-slowFinished:
+				case <-time.After(2 * time.Second):
+					t.Fatal("the slow provider should be finished")
+				}
+				assert.Len(t, slow.get(), 1)
+			}
+		})
+	}
+}
+
+func TestProviderSelector_AllProvidersBehind(t *testing.T) {
+	const chunks = 10
+
+	var audio recordedAudio
+	release := make(chan struct{})
+	session := mocks.NewMockSession(t)
+	session.EXPECT().SendAudio(mock.Anything).RunAndReturn(func(chunk []byte) error {
+		<-release
+		audio.add(chunk)
+		return nil
+	})
+
+	list := []providers.Provider{newBackpressureTestProvider(t, "google", session)}
+	selectorConfig := SelectorConfig{Backpressure: BackpressureConfig{AudioQueueSize: 2, SlowProvider: OverflowDisconnect}}
+	ps, err := NewProviderSelector(list, providers.SessionConfig{}, selectorConfig, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	for i := range chunks {
+		require.NoError(t, ps.SendAudio([]byte(fmt.Sprint(i))))
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	// Audio coming in faster than any provider takes it is waited for,
+	// rather than dropped
+	require.Eventually(t, func() bool {
+		return len(audio.get()) == chunks
+	}, 2*time.Second, 5*time.Millisecond)
+	stats := ps.QueueStats()
+	assert.Zero(t, stats.AudioDropped["google"])
+	assert.Zero(t, stats.ProvidersDisconnected["google"])
+}
+
+func TestProviderSelector_SlowClient(t *testing.T) {
+	const results = 5
+
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+	}{
+		{name: "drop oldest", policy: OverflowDropOldest},
+		{name: "disconnect", policy: OverflowDisconnect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := mocks.NewMockProvider(t)
+			session := mocks.NewMockSession(t)
+			var sessionCtx context.Context
+			provider.EXPECT().Name().Return("google")
+			provider.EXPECT().NewSession(mock.Anything, mock.Anything).
+				RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+					sessionCtx = ctx
+					return session, nil
+				}).Once()
+			for i := range results {
+				session.EXPECT().ReceiveTranscription().Return(hypothesis("google", 0.9, fmt.Sprint(i)), nil).Once()
+			}
+			session.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+				<-sessionCtx.Done()
+				return providers.TranscriptionResult{}, io.EOF
+			}).Maybe()
+			session.EXPECT().Close().Return(nil)
+
+			selectorConfig := SelectorConfig{Backpressure: BackpressureConfig{ResultQueueSize: 2, SlowClient: tt.policy}}
+			ps, err := NewProviderSelector([]providers.Provider{provider}, providers.SessionConfig{}, selectorConfig, log.New(io.Discard, "", 0))
+			require.NoError(t, err)
+			defer ps.Close()
+
+			// Nothing is read until the queue overflows
+			switch tt.policy {
+			case OverflowDropOldest:
+				require.Eventually(t, func() bool {
+					return ps.QueueStats().ResultsDropped == results-2
+				}, 2*time.Second, 5*time.Millisecond)
+				assert.Equal(t, 2, ps.QueueStats().ResultsQueued)
+
+				// The client catches up with the latest results,
+				// numbered to show the ones dropped
+				for i, want := range []string{"3", "4"} {
+					result, err := ps.ReceiveTranscription()
+					require.NoError(t, err)
+					assert.Equal(t, want, result.Text)
+					assert.Equal(t, uint64(results-1+i), result.SeqNum)
+				}
+
+			case OverflowDisconnect:
+				require.Eventually(t, func() bool {
+					return ps.QueueStats().ClientsDisconnected == 1
+				}, 2*time.Second, 5*time.Millisecond)
+				_, err := ps.ReceiveTranscription()
+				assert.ErrorIs(t, err, ErrSlowClient)
+			}
+		})
+	}
+}
+
+func TestWebSocketDroppedResults(t *testing.T) {
+	// The session dropped results 2 and 3
+	mockSession := mocks.NewMockSession(t)
+	for _, seqNum := range []uint64{1, 4} {
+		mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{
+			Text:    fmt.Sprint("result ", seqNum),
+			IsFinal: true,
+			SeqNum:  seqNum,
+		}, nil).Once()
+	}
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().Return(nil).Maybe()
+
+	upgrader := websocket.Upgrader{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		wc := &WebConn{
+			conn:       conn,
+			log:        log.New(io.Discard, "", 0),
+			session:    mockSession,
+			writerDone: make(chan struct{}),
+			closing:    make(chan struct{}),
+		}
+		wc.Start()
+	}))
+	defer testServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http"), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The IDs are the session's, so the client sees the gap
+	for _, want := range []uint64{1, 4} {
+		var response WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&response))
+		assert.Equal(t, want, response.ID)
+		assert.Equal(t, fmt.Sprint("result ", want), response.Sentence)
+	}
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	mockSession := mocks.NewMockSession(t)
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, ErrSlowClient).Once()
+	closed := make(chan struct{})
+	mockSession.EXPECT().Close().RunAndReturn(func() error {
+		close(closed)
+		return nil
+	}).Once()
+
+	upgrader := websocket.Upgrader{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		wc := &WebConn{
+			conn:         conn,
+			log:          log.New(io.Discard, "", 0),
+			session:      mockSession,
+			writerDone:   make(chan struct{}),
+			closing:      make(chan struct{}),
+			flushTimeout: 100 * time.Millisecond,
+		}
+		wc.Start()
+	}))
+	defer testServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http"), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The client is disconnected, and its session closed
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "want a policy violation close, got %v", err)
+	waitClosed(t, closed)
+}
+
+// stalledDialer dials connections with little to buffer what the server
+// writes, for clients that never read to stall its writes early.
+var stalledDialer = websocket.Dialer{
+	NetDial: func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			err = conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	},
+}
+
+func TestWebSocketStalledClient(t *testing.T) {
+	mockProvider, closed := newFloodTestProvider(t)
+	cfg := DefaultConfig()
+	cfg.ResumeTimeout = 5 * time.Second
+	cfg.PingInterval = 3 * time.Second
+	cfg.Formatting = FormattingConfig{}
+	server, wsURL := newConfigTestServer(t, cfg, mockProvider)
+
+	// The client never reads, so the writes of the server time out
+	// before the connection is taken as dropped
+	conn, _, err := stalledDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// It is disconnected as too slow, and its session ends at once
+	// instead of waiting to be resumed
+	select {
+	case <-closed:
+	case <-time.After(9 * time.Second):
+		t.Fatal("the session should be closed")
+	}
+	require.Eventually(t, func() bool {
+		return server.Metrics().Connections == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), server.Metrics().Queues.ClientsDisconnected)
+}
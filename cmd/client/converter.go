@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// SampleFormat identifies how a single PCM sample is laid out in the input stream.
+type SampleFormat int
+
+const (
+	// FormatS16LE is signed 16-bit little-endian PCM.
+	FormatS16LE SampleFormat = iota
+	// FormatS24LE is signed 24-bit little-endian PCM, packed into 3 bytes.
+	FormatS24LE
+	// FormatF32LE is 32-bit little-endian IEEE float PCM in the range [-1, 1].
+	FormatF32LE
+)
+
+// parseSampleFormat converts a command line value into a SampleFormat.
+func parseSampleFormat(s string) (SampleFormat, error) {
+	switch s {
+	case "s16le":
+		return FormatS16LE, nil
+	case "s24le":
+		return FormatS24LE, nil
+	case "f32le":
+		return FormatF32LE, nil
+	default:
+		return 0, fmt.Errorf("unsupported sample format %q (want s16le, s24le or f32le)", s)
+	}
+}
+
+// bytesPerSample returns the size of a single sample of one channel.
+func (f SampleFormat) bytesPerSample() int {
+	switch f {
+	case FormatS24LE:
+		return 3
+	case FormatF32LE:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// AudioFormat describes an interleaved PCM stream.
+type AudioFormat struct {
+	SampleRate int
+	Channels   int
+	Format     SampleFormat
+}
+
+// frameSize returns the number of bytes holding one sample for every channel.
+func (f AudioFormat) frameSize() int {
+	return f.Channels * f.Format.bytesPerSample()
+}
+
+// AudioConverter implements io.Reader. It reads PCM audio in an arbitrary
+// AudioFormat from the underlying reader and returns 16-bit little-endian
+// samples at the target sample rate, which is what the server expects.
+// The output is either mono, or keeps all the input channels interleaved.
+type AudioConverter struct {
+	src       io.Reader
+	in        AudioFormat
+	downmix   bool
+	buf       []byte // raw bytes read from src
+	carry     int    // bytes of an incomplete frame left at the start of buf
+	samples   []float32
+	mixed     []float32
+	channel   []float32 // one deinterleaved channel, ready for resampling
+	resampled []float32
+	out       []byte
+	pending   []byte // converted bytes not yet returned to the caller
+	err       error
+
+	// One resampler per output channel, nil when the rates match.
+	resamplers []*linearResampler
+}
+
+// NewAudioConverter creates a converter reading from src, which must contain
+// audio in the given format. The output is resampled to outRate, and has
+// outChannels channels, which must be either 1 or the input channel count.
+func NewAudioConverter(src io.Reader, in AudioFormat, outRate, outChannels int) (*AudioConverter, error) {
+	if in.SampleRate <= 0 || outRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate: input %d, output %d", in.SampleRate, outRate)
+	}
+	if in.Channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count: %d", in.Channels)
+	}
+	if outChannels != 1 && outChannels != in.Channels {
+		return nil, fmt.Errorf("cannot convert %d channels to %d", in.Channels, outChannels)
+	}
+
+	c := &AudioConverter{
+		src:     src,
+		in:      in,
+		downmix: outChannels == 1,
+		// Read roughly one microphone buffer worth of frames at a time.
+		buf: make([]byte, framesPerBuffer*in.frameSize()),
+	}
+	if in.SampleRate != outRate {
+		for range outChannels {
+			c.resamplers = append(c.resamplers, newLinearResampler(in.SampleRate, outRate))
+		}
+	}
+	return c, nil
+}
+
+// Read implements io.Reader.
+func (c *AudioConverter) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		n, err := c.src.Read(c.buf[c.carry:])
+		n += c.carry
+
+		// Only convert whole frames, and keep the remainder for the next read.
+		frameSize := c.in.frameSize()
+		whole := n - n%frameSize
+		if whole > 0 {
+			c.pending = c.convert(c.buf[:whole])
+		}
+		c.carry = copy(c.buf, c.buf[whole:n])
+
+		if err != nil {
+			c.err = err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (c *AudioConverter) Close() error {
+	if closer, ok := c.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// convert decodes whole frames into floats, downmixes them to mono if
+// needed, resamples them and encodes the result as s16le.
+func (c *AudioConverter) convert(raw []byte) []byte {
+	c.samples = decodeSamples(c.samples[:0], raw, c.in.Format)
+
+	samples := c.samples
+	if c.downmix {
+		c.mixed = downmix(c.mixed[:0], c.samples, c.in.Channels)
+		samples = c.mixed
+	}
+	if c.resamplers != nil {
+		samples = c.resample(samples)
+	}
+
+	c.out = encodeS16LE(c.out[:0], samples)
+	return c.out
+}
+
+// resample runs every channel of the interleaved samples through its own
+// resampler and interleaves the results again. All resamplers see the same
+// number of samples, so they always return the same number back.
+func (c *AudioConverter) resample(samples []float32) []float32 {
+	channels := len(c.resamplers)
+	if channels == 1 {
+		return c.resamplers[0].process(samples)
+	}
+
+	for ch, r := range c.resamplers {
+		c.channel = c.channel[:0]
+		for i := ch; i < len(samples); i += channels {
+			c.channel = append(c.channel, samples[i])
+		}
+
+		out := r.process(c.channel)
+		if ch == 0 {
+			c.resampled = slices.Grow(c.resampled[:0], len(out)*channels)[:len(out)*channels]
+		}
+		for i, v := range out {
+			c.resampled[i*channels+ch] = v
+		}
+	}
+	return c.resampled
+}
+
+// decodeSamples appends the samples in raw to dst as floats in the range [-1, 1].
+func decodeSamples(dst []float32, raw []byte, format SampleFormat) []float32 {
+	size := format.bytesPerSample()
+	for i := 0; i+size <= len(raw); i += size {
+		var v float32
+		switch format {
+		case FormatS16LE:
+			v = float32(int16(binary.LittleEndian.Uint16(raw[i:]))) / 32768
+		case FormatS24LE:
+			// Shift into the top of an int32 so the sign bit is extended.
+			s := int32(uint32(raw[i])<<8|uint32(raw[i+1])<<16|uint32(raw[i+2])<<24) >> 8
+			v = float32(s) / 8388608
+		case FormatF32LE:
+			v = math.Float32frombits(binary.LittleEndian.Uint32(raw[i:]))
+		}
+		dst = append(dst, v)
+	}
+	return dst
+}
+
+// downmix appends the average of every frame of interleaved samples to dst.
+func downmix(dst []float32, samples []float32, channels int) []float32 {
+	if channels == 1 {
+		return append(dst, samples...)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var sum float32
+		for _, s := range samples[i : i+channels] {
+			sum += s
+		}
+		dst = append(dst, sum/float32(channels))
+	}
+	return dst
+}
+
+// encodeS16LE appends the samples to dst as clipped 16-bit little-endian integers.
+func encodeS16LE(dst []byte, samples []float32) []byte {
+	for _, s := range samples {
+		v := math.Round(float64(s) * 32768)
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(int16(v)))
+	}
+	return dst
+}
+
+// linearResampler converts a mono stream between sample rates using linear
+// interpolation. It keeps the last input sample and the fractional read
+// position between calls so that chunk boundaries do not cause clicks.
+//
+// There is no anti-aliasing filter, so downsampling music will alias.
+// That is good enough for speech, where most of the energy sits well below
+// the output Nyquist frequency. Keeping it simple for now.
+type linearResampler struct {
+	step float64 // input samples advanced per output sample
+	pos  float64 // read position, where 0 is prev and 1 is the first new sample
+	prev float32
+	out  []float32
+}
+
+func newLinearResampler(inRate, outRate int) *linearResampler {
+	return &linearResampler{
+		step: float64(inRate) / float64(outRate),
+		// Start on the first real sample, there is no previous one yet.
+		pos: 1,
+	}
+}
+
+// process resamples the next chunk of input. The returned slice is only
+// valid until the next call.
+func (r *linearResampler) process(in []float32) []float32 {
+	r.out = r.out[:0]
+	if len(in) == 0 {
+		return r.out
+	}
+
+	// at returns the sample at index i of prev followed by in.
+	at := func(i int) float32 {
+		if i == 0 {
+			return r.prev
+		}
+		return in[i-1]
+	}
+
+	last := len(in) // index of the last sample in prev+in
+	for r.pos <= float64(last) {
+		i := int(r.pos)
+		frac := float32(r.pos - float64(i))
+		v := at(i)
+		if frac > 0 && i < last {
+			v += (at(i+1) - v) * frac
+		}
+		r.out = append(r.out, v)
+		r.pos += r.step
+	}
+
+	// Rebase so that the last sample of this chunk becomes prev.
+	r.pos -= float64(last)
+	r.prev = in[len(in)-1]
+	return r.out
+}
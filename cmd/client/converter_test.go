@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"testing/iotest"
+)
+
+// s16le encodes int16 samples as little-endian bytes.
+func s16le(samples ...int16) []byte {
+	return int16SliceToByteSlice(samples)
+}
+
+// readAllConverted runs raw through a converter and returns the decoded output samples.
+func readAllConverted(t *testing.T, src io.Reader, in AudioFormat, outRate, outChannels int) []int16 {
+	t.Helper()
+
+	conv, err := NewAudioConverter(src, in, outRate, outChannels)
+	if err != nil {
+		t.Fatalf("NewAudioConverter() error = %v", err)
+	}
+
+	out, err := io.ReadAll(conv)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	samples := make([]int16, len(out)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(out[2*i:]))
+	}
+	return samples
+}
+
+func TestParseSampleFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    SampleFormat
+		wantErr bool
+	}{
+		{"s16le", FormatS16LE, false},
+		{"s24le", FormatS24LE, false},
+		{"f32le", FormatF32LE, false},
+		{"u8", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSampleFormat(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSampleFormat(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSampleFormat(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAudioConverter_InvalidFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          AudioFormat
+		outRate     int
+		outChannels int
+	}{
+		{"zero input rate", AudioFormat{SampleRate: 0, Channels: 1}, 16000, 1},
+		{"zero output rate", AudioFormat{SampleRate: 16000, Channels: 1}, 0, 1},
+		{"zero channels", AudioFormat{SampleRate: 16000, Channels: 0}, 16000, 1},
+		{"upmix", AudioFormat{SampleRate: 16000, Channels: 1}, 16000, 2},
+		{"partial downmix", AudioFormat{SampleRate: 16000, Channels: 4}, 16000, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAudioConverter(bytes.NewReader(nil), tt.in, tt.outRate, tt.outChannels); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestAudioConverter(t *testing.T) {
+	t.Run("s16le mono passthrough", func(t *testing.T) {
+		input := []int16{0, 1, -1, 32767, -32768, 1234}
+		got := readAllConverted(t, bytes.NewReader(s16le(input...)),
+			AudioFormat{SampleRate: 16000, Channels: 1, Format: FormatS16LE}, 16000, 1)
+
+		if len(got) != len(input) {
+			t.Fatalf("Expected %d samples, got %d", len(input), len(got))
+		}
+		for i := range input {
+			if got[i] != input[i] {
+				t.Errorf("Sample %d: expected %d, got %d", i, input[i], got[i])
+			}
+		}
+	})
+
+	t.Run("stereo downmix", func(t *testing.T) {
+		// Interleaved left/right pairs
+		input := s16le(1000, 3000, -2000, 2000, 32767, 32767)
+		got := readAllConverted(t, bytes.NewReader(input),
+			AudioFormat{SampleRate: 16000, Channels: 2, Format: FormatS16LE}, 16000, 1)
+
+		want := []int16{2000, 0, 32767}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d samples, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Sample %d: expected %d, got %d", i, want[i], got[i])
+			}
+		}
+	})
+
+	t.Run("stereo kept separate", func(t *testing.T) {
+		input := []int16{1000, 3000, -2000, 2000, 32767, -32768}
+		got := readAllConverted(t, bytes.NewReader(s16le(input...)),
+			AudioFormat{SampleRate: 16000, Channels: 2, Format: FormatS16LE}, 16000, 2)
+
+		if len(got) != len(input) {
+			t.Fatalf("Expected %d samples, got %d", len(input), len(got))
+		}
+		for i := range input {
+			if got[i] != input[i] {
+				t.Errorf("Sample %d: expected %d, got %d", i, input[i], got[i])
+			}
+		}
+	})
+
+	t.Run("f32le to s16le with clipping", func(t *testing.T) {
+		var input []byte
+		for _, v := range []float32{0, 0.5, -0.5, 1.5, -1.5} {
+			input = binary.LittleEndian.AppendUint32(input, math.Float32bits(v))
+		}
+		got := readAllConverted(t, bytes.NewReader(input),
+			AudioFormat{SampleRate: 16000, Channels: 1, Format: FormatF32LE}, 16000, 1)
+
+		want := []int16{0, 16384, -16384, 32767, -32768}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d samples, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Sample %d: expected %d, got %d", i, want[i], got[i])
+			}
+		}
+	})
+
+	t.Run("s24le to s16le", func(t *testing.T) {
+		input := []byte{
+			0x00, 0x00, 0x40, // 0x400000 = half of full scale
+			0x00, 0x00, 0xC0, // -0x400000
+			0xFF, 0xFF, 0x7F, // max positive
+			0x00, 0x00, 0x80, // min negative
+		}
+		got := readAllConverted(t, bytes.NewReader(input),
+			AudioFormat{SampleRate: 16000, Channels: 1, Format: FormatS24LE}, 16000, 1)
+
+		want := []int16{16384, -16384, 32767, -32768}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d samples, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Sample %d: expected %d, got %d", i, want[i], got[i])
+			}
+		}
+	})
+
+	t.Run("partial frames across reads", func(t *testing.T) {
+		input := s16le(100, -100, 200, -200, 300, -300)
+		got := readAllConverted(t, iotest.OneByteReader(bytes.NewReader(input)),
+			AudioFormat{SampleRate: 16000, Channels: 2, Format: FormatS16LE}, 16000, 1)
+
+		want := []int16{0, 0, 0}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d samples, got %d", len(want), len(got))
+		}
+	})
+
+	t.Run("trailing incomplete frame is dropped", func(t *testing.T) {
+		input := append(s16le(10, 20), 0x01)
+		got := readAllConverted(t, bytes.NewReader(input),
+			AudioFormat{SampleRate: 16000, Channels: 1, Format: FormatS16LE}, 16000, 1)
+
+		if len(got) != 2 {
+			t.Errorf("Expected 2 samples, got %d", len(got))
+		}
+	})
+
+	t.Run("read error is returned", func(t *testing.T) {
+		conv, err := NewAudioConverter(&errorReader{err: io.ErrUnexpectedEOF},
+			AudioFormat{SampleRate: 16000, Channels: 1, Format: FormatS16LE}, 16000, 1)
+		if err != nil {
+			t.Fatalf("NewAudioConverter() error = %v", err)
+		}
+
+		buf := make([]byte, 64)
+		if _, err := conv.Read(buf); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+}
+
+func TestAudioConverter_Resample(t *testing.T) {
+	tests := []struct {
+		name   string
+		inRate int
+	}{
+		{"downsample 48kHz", 48000},
+		{"downsample 44.1kHz", 44100},
+		{"upsample 8kHz", 8000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One second of a 440 Hz tone at half amplitude.
+			const freq = 440.0
+			input := make([]int16, tt.inRate)
+			for i := range input {
+				input[i] = int16(16384 * math.Sin(2*math.Pi*freq*float64(i)/float64(tt.inRate)))
+			}
+
+			// Feed in small reads to exercise the state kept between chunks.
+			src := iotest.HalfReader(bytes.NewReader(s16le(input...)))
+			got := readAllConverted(t, src,
+				AudioFormat{SampleRate: tt.inRate, Channels: 1, Format: FormatS16LE}, sampleRate, 1)
+
+			// The output should be one second long, give or take a sample.
+			if math.Abs(float64(len(got)-sampleRate)) > 1 {
+				t.Fatalf("Expected about %d samples, got %d", sampleRate, len(got))
+			}
+
+			// And it should still be the same tone.
+			var maxErr float64
+			for i, v := range got {
+				want := 16384 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+				maxErr = math.Max(maxErr, math.Abs(float64(v)-want))
+			}
+			if maxErr > 300 {
+				t.Errorf("Resampled signal deviates too much from the original: max error %.0f", maxErr)
+			}
+		})
+	}
+}
+
+func TestAudioConverter_ResampleChannels(t *testing.T) {
+	// One second of stereo at 48kHz, with a different tone on each channel.
+	const inRate = 48000
+	freqs := []float64{440, 1000}
+	input := make([]int16, inRate*2)
+	for i := 0; i < inRate; i++ {
+		for ch, freq := range freqs {
+			input[2*i+ch] = int16(16384 * math.Sin(2*math.Pi*freq*float64(i)/inRate))
+		}
+	}
+
+	src := iotest.HalfReader(bytes.NewReader(s16le(input...)))
+	got := readAllConverted(t, src,
+		AudioFormat{SampleRate: inRate, Channels: 2, Format: FormatS16LE}, sampleRate, 2)
+
+	frames := len(got) / 2
+	if len(got)%2 != 0 || math.Abs(float64(frames-sampleRate)) > 1 {
+		t.Fatalf("Expected about %d stereo frames, got %d samples", sampleRate, len(got))
+	}
+
+	// Every channel should still carry its own tone.
+	for ch, freq := range freqs {
+		var maxErr float64
+		for i := 0; i < frames; i++ {
+			want := 16384 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+			maxErr = math.Max(maxErr, math.Abs(float64(got[2*i+ch])-want))
+		}
+		if maxErr > 1200 {
+			t.Errorf("Channel %d deviates too much from the original: max error %.0f", ch+1, maxErr)
+		}
+	}
+}
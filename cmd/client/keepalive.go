@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// keepAlive gives the connection a read deadline of two ping intervals,
+// pushed back by every pong and every message. Once a connection answers
+// nothing for that long, like one the network dropped without a word, the
+// reader reconnects.
+func (c *Client) keepAlive(conn *websocket.Conn) {
+	if c.pingInterval <= 0 {
+		return
+	}
+	c.extendDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		c.extendDeadline(conn)
+		return nil
+	})
+}
+
+func (c *Client) extendDeadline(conn *websocket.Conn) {
+	if c.pingInterval > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+	}
+}
+
+// pinger pings the server every ping interval, until the client is closed
+// or the session is over. Nothing is pinged while reconnecting.
+func (c *Client) pinger() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.ping()
+		case <-c.quit:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) ping() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.broken || c.closed {
+		return
+	}
+	// A failed ping is left to the read deadline.
+	c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClient_KeepAlive(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var mu sync.Mutex
+	connections := 0
+	pinged := make(chan struct{})
+	stop := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		first := connections == 1
+		mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		if first {
+			// A half-open connection: nothing is read, so pings go
+			// unanswered
+			<-stop
+			return
+		}
+		var once sync.Once
+		conn.SetPingHandler(func(data string) error {
+			once.Do(func() { close(pinged) })
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(stop)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+
+	client := &Client{
+		conn:          conn,
+		audioReader:   slowReader{},
+		log:           log.New(io.Discard, "", 0),
+		bufferSize:    10,
+		url:           wsURL,
+		maxReconnects: 3,
+		backlogLimit:  1 << 20,
+		pingInterval:  20 * time.Millisecond,
+	}
+	client.Start()
+
+	// The unanswered pings make the client reconnect, and ping the new
+	// connection
+	select {
+	case <-pinged:
+	case <-client.Done():
+		t.Fatal("The client should reconnect")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a ping after reconnecting")
+	}
+	client.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if connections != 2 {
+		t.Errorf("Expected 2 connections, got %d", connections)
+	}
+}
@@ -9,14 +9,19 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	stt "github.com/agnivade/stt_challenge"
+	"github.com/agnivade/stt_challenge/providers"
 	"github.com/gorilla/websocket"
 )
 
@@ -29,8 +34,53 @@ type Client struct {
 	wg                  sync.WaitGroup
 	log                 *log.Logger
 	bufWriter           *bufio.Writer
-	msgBuffer           *MessageBuffer
+	bufferSize          int
 	similarityThreshold float64
+
+	// showLanguage prefixes every line with the language it was detected in.
+	showLanguage bool
+
+	// Deduplication buffers by channel, so that the same short answer
+	// on two channels is not mistaken for a duplicate.
+	msgBuffers map[int]*MessageBuffer
+
+	// The speaker of the last printed line, to group consecutive turns.
+	lastSpeaker int
+	lastChannel int
+
+	// Reconnecting, when url is set. The audio read while reconnecting
+	// is kept, up to backlogLimit bytes, and sent once reconnected.
+	// streamHeaders, like the Ogg headers of an Opus stream, are sent
+	// again first when a new session replaces one that couldn't be
+	// resumed, since the audio isn't sent from its start.
+	url           string
+	maxReconnects int
+	backlogLimit  int
+	streamHeaders []byte
+
+	// The server is pinged every pingInterval, if set, and a connection
+	// answering nothing for two intervals is taken as dropped.
+	pingInterval time.Duration
+
+	// mu guards conn, every write to it, and the fields below. lastID is
+	// the ID of the last transcript received, to resume the session after.
+	// newSession is set once a new session replaced the previous one,
+	// until the stream headers are sent to it.
+	mu           sync.Mutex
+	token        string
+	lastID       uint64
+	newSession   bool
+	backlog      [][]byte
+	backlogBytes int
+	broken       bool
+	closed       bool
+	// finishing is set once the audio has run out, and the server was
+	// asked to end the transcript.
+	finishing bool
+	// quit is closed by Close, and done once the session is over. Both
+	// are made by Start.
+	quit chan struct{}
+	done chan struct{}
 }
 
 func main() {
@@ -39,33 +89,192 @@ func main() {
 	var inputFile = flag.String("input", "", "Input audio file path (useful for testing)")
 	var bufferSize = flag.Int("buffer-size", 10, "Number of recent messages to keep for deduplication")
 	var similarityThreshold = flag.Float64("similarity-threshold", 0.8, "Similarity threshold for deduplication (0.0-1.0)")
+	var inputRate = flag.Int("input-rate", sampleRate, "Sample rate of the input audio in Hz")
+	var inputChannels = flag.Int("input-channels", 1, "Number of interleaved channels in the input audio")
+	var inputFormat = flag.String("input-format", "s16le", "Sample format of the input file (s16le, s24le or f32le)")
+	var realtime = flag.Bool("realtime", false, "Send file input at its real playback rate instead of as fast as possible")
+	var speed = flag.Float64("speed", 1.0, "Playback speed multiplier used with -realtime")
+	var mode = flag.String("mode", modeContinuous, "Capture mode: continuous, vad (send only while speech is detected) or push-to-talk (toggle with Enter)")
+	var vadThreshold = flag.Float64("vad-threshold", stt.DefaultVADConfig().ThresholdDBFS, "RMS level in dBFS above which audio counts as speech in vad mode")
+	var encoding = flag.String("encoding", "linear16", "Audio encoding sent to the server: linear16 or opus")
+	var multichannel = flag.Bool("multichannel", false, "Keep the input channels separate and transcribe each one on its own")
+	var diarize = flag.Bool("diarize", false, "Label who is speaking, for recordings with several speakers on one channel")
+	var minSpeakers = flag.Int("min-speakers", 0, "Minimum number of speakers expected with -diarize (0 lets the provider decide)")
+	var maxSpeakers = flag.Int("max-speakers", 0, "Maximum number of speakers expected with -diarize (0 lets the provider decide)")
+	var phrases phraseFlags
+	flag.Var(&phrases, "phrase", "Word or phrase to recognize more readily, as text or text:boost (can be repeated)")
+	var language = flag.String("language", "", "Language of the audio, like en-US (empty uses the server default)")
+	var alternativeLanguages stringFlags
+	flag.Var(&alternativeLanguages, "alternative-language", "Other language the audio may be in, like hi-IN, for speakers who switch languages (can be repeated)")
+	var showLanguage = flag.Bool("show-language", false, "Show the detected language of every transcription")
+	var strategy = flag.String("strategy", "", "How the server picks results: latency or fusion (empty uses the server default)")
+	var alternatives = flag.Int("alternatives", 0, "Number of transcripts to ask for per sentence, the runner-ups shown under the best one (0 shows the best only)")
+	var profanityFilter = flag.Bool("profanity-filter", false, "Mask profanity in the transcriptions")
+	var redact = flag.String("redact", "", "Comma-separated personal data to redact: credit_card, ssn, phone, email")
+	var providerRedaction = flag.Bool("provider-redaction", false, "Also ask the providers to redact what they can")
+	var vocabularies stringFlags
+	flag.Var(&vocabularies, "vocabulary", "Name of a server-side vocabulary to use (can be repeated)")
+	models := modelFlags{}
+	flag.Var(models, "model", "Model to transcribe with, as provider:model like google:phone_call, out of the ones the server allows (can be repeated)")
+	var reconnectAttempts = flag.Int("reconnect-attempts", 10, "Times to try reconnecting, with backoff, when the connection drops (0 turns reconnecting off)")
+	var reconnectBuffer = flag.Duration("reconnect-buffer", 30*time.Second, "How much audio to keep while reconnecting, sent once reconnected")
+	var pingInterval = flag.Duration("ping-interval", 20*time.Second, "How often the server is pinged; a connection answering nothing for two intervals is reconnected (0 turns pinging off)")
 	flag.Parse()
 
 	logger := log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile)
 
+	format, err := parseSampleFormat(*inputFormat)
+	if err != nil {
+		logger.Printf("Invalid input format: %v\n", err)
+		return
+	}
+
+	if *speed <= 0 {
+		logger.Printf("Invalid speed %v: must be greater than 0\n", *speed)
+		return
+	}
+
+	// Everything is downmixed to mono, unless the channels are kept apart.
+	channels := 1
+	if *multichannel {
+		channels = *inputChannels
+	}
+
+	var params stt.SessionParams
+	switch *encoding {
+	case "linear16":
+		// The server default, nothing to negotiate
+	case "opus":
+		params.Encoding = providers.EncodingOggOpus
+		params.SampleRate = sampleRate
+	default:
+		logger.Printf("Invalid encoding %q: want linear16 or opus\n", *encoding)
+		return
+	}
+	if channels > 1 {
+		if params.Encoding == providers.EncodingOggOpus {
+			logger.Println("Invalid encoding: -multichannel only works with linear16")
+			return
+		}
+		params.Channels = channels
+		params.SeparateChannels = true
+	}
+	if *diarize {
+		params.Diarize = true
+		params.MinSpeakers = *minSpeakers
+		params.MaxSpeakers = *maxSpeakers
+	}
+	params.LanguageCode = *language
+	params.AlternativeLanguageCodes = alternativeLanguages
+	params.Phrases = phrases
+	params.Vocabularies = vocabularies
+	params.MaxAlternatives = *alternatives
+	if *strategy != "" {
+		s, err := stt.ParseSelectionStrategy(*strategy)
+		if err != nil {
+			logger.Printf("Invalid strategy: %v\n", err)
+			return
+		}
+		params.Strategy = s
+	}
+	params.Redaction = stt.RedactionConfig{
+		Profanity:      *profanityFilter,
+		ProviderNative: *providerRedaction,
+	}
+	if *redact != "" {
+		types, err := stt.ParsePIITypes(*redact)
+		if err != nil {
+			logger.Printf("Invalid redact: %v\n", err)
+			return
+		}
+		params.Redaction.PII = types
+	}
+	if len(models) > 0 {
+		params.Models = models
+	}
+
+	// Catch bad combinations here rather than as a failed handshake.
+	if _, err := stt.ParseSessionParams(params.Query()); err != nil {
+		logger.Printf("Invalid session params: %v\n", err)
+		return
+	}
+	bytesPerSecond := sampleRate * 2 * channels
+
+	vadConfig := stt.DefaultVADConfig()
+	vadConfig.ThresholdDBFS = *vadThreshold
+	gate, err := newAudioGate(*mode, vadConfig, bytesPerSecond, os.Stdin, os.Stdout)
+	if err != nil {
+		logger.Printf("Invalid mode: %v\n", err)
+		return
+	}
+
 	// Initialize audio reader (either file or microphone)
-	var audioReader io.ReadCloser
+	var source io.ReadCloser
 	if *inputFile != "" {
 		file, err := os.Open(*inputFile)
 		if err != nil {
 			logger.Printf("Failed to open input file: %v\n", err)
 			return
 		}
-		audioReader = file
+		source = file
 		logger.Printf("Using input file: %s\n", *inputFile)
 	} else {
-		micReader, err := NewMicrophoneReader()
+		micReader, err := NewMicrophoneReader(*inputRate, *inputChannels)
 		if err != nil {
 			logger.Printf("Failed to initialize microphone: %v\n", err)
 			return
 		}
-		audioReader = micReader
+		// The microphone always captures 16-bit samples.
+		format = FormatS16LE
+		source = micReader
 		logger.Println("Using microphone input")
 	}
+
+	// Convert whatever the source produces into what the server expects.
+	converter, err := NewAudioConverter(source, AudioFormat{
+		SampleRate: *inputRate,
+		Channels:   *inputChannels,
+		Format:     format,
+	}, sampleRate, channels)
+	if err != nil {
+		source.Close()
+		logger.Printf("Failed to create audio converter: %v\n", err)
+		return
+	}
+
+	var audioReader io.ReadCloser = converter
+	// The microphone is already paced by the hardware.
+	if *realtime && *inputFile != "" {
+		audioReader = NewPacedReader(audioReader, bytesPerSecond, *speed)
+		logger.Printf("Pacing input at %.2fx real time\n", *speed)
+	}
+	if gate != nil {
+		audioReader = &gatedReader{src: audioReader, gate: gate}
+	}
+	// The audio kept while reconnecting is limited by how long it lasts.
+	backlogBytesPerSecond := bytesPerSecond
+	var streamHeaders []byte
+	if params.Encoding == providers.EncodingOggOpus {
+		opusReader, err := NewOpusReader(audioReader, sampleRate)
+		if err != nil {
+			audioReader.Close()
+			logger.Printf("Failed to create Opus encoder: %v\n", err)
+			return
+		}
+		audioReader = opusReader
+		backlogBytesPerSecond = opusBytesPerSecond
+		streamHeaders = opusReader.Headers()
+	}
 	defer audioReader.Close()
 
+	wsURL, err := sessionURL(*serverURL, params)
+	if err != nil {
+		logger.Printf("Invalid server URL: %v\n", err)
+		return
+	}
+
 	// Connect to WebSocket server
-	conn, _, err := websocket.DefaultDialer.Dial(*serverURL, nil)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	if err != nil {
 		logger.Printf("WebSocket dial failed: %v\n", err)
 		return
@@ -76,8 +285,14 @@ func main() {
 		conn:                conn,
 		audioReader:         audioReader,
 		log:                 logger,
-		msgBuffer:           NewMessageBuffer(*bufferSize),
+		bufferSize:          *bufferSize,
 		similarityThreshold: *similarityThreshold,
+		showLanguage:        *showLanguage,
+		url:                 wsURL,
+		maxReconnects:       *reconnectAttempts,
+		backlogLimit:        int(reconnectBuffer.Seconds() * float64(backlogBytesPerSecond)),
+		streamHeaders:       streamHeaders,
+		pingInterval:        *pingInterval,
 	}
 
 	// Setup output file if specified
@@ -97,36 +312,127 @@ func main() {
 	// Start client
 	client.Start()
 
-	// Wait for interrupt signal
-	// This means that closing the connection from server, doesn't quit
-	// the client. We could pass the signal chan to the client
-	// if we want that behavior.
+	// Wait for interrupt signal, or for the session to be over: the
+	// transcript ended after the input did, or the connection was lost
+	// for good, once reconnecting didn't work out.
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
+	select {
+	case <-sig:
+	case <-client.Done():
+	}
 
 	client.Close()
 	fmt.Println("\nDone.")
 }
 
+// sessionURL adds the session params to the query string of the server URL.
+func sessionURL(serverURL string, params stt.SessionParams) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	for key, values := range params.Query() {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// phraseFlags collects the phrase hints of repeated -phrase flags.
+type phraseFlags []providers.PhraseHint
+
+// String implements flag.Value.
+func (f *phraseFlags) String() string {
+	texts := make([]string, 0, len(*f))
+	for _, p := range *f {
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, ", ")
+}
+
+// Set implements flag.Value.
+func (f *phraseFlags) Set(value string) error {
+	phrase, err := stt.ParsePhraseHint(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, phrase)
+	return nil
+}
+
+// modelFlags collects the models of repeated -model flags, by provider.
+type modelFlags map[string]string
+
+// String implements flag.Value.
+func (f modelFlags) String() string {
+	choices := make([]string, 0, len(f))
+	for _, provider := range slices.Sorted(maps.Keys(f)) {
+		choices = append(choices, provider+":"+f[provider])
+	}
+	return strings.Join(choices, ", ")
+}
+
+// Set implements flag.Value.
+func (f modelFlags) Set(value string) error {
+	provider, model, err := stt.ParseModel(value)
+	if err != nil {
+		return err
+	}
+	f[provider] = model
+	return nil
+}
+
+// stringFlags collects the values of a repeated flag.
+type stringFlags []string
+
+// String implements flag.Value.
+func (f *stringFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+// Set implements flag.Value.
+func (f *stringFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func (c *Client) Start() {
+	c.quit = make(chan struct{})
+	c.done = make(chan struct{})
+	c.keepAlive(c.currentConn())
 	c.wg.Add(2)
 	go c.reader()
 	go c.writer()
+	if c.pingInterval > 0 {
+		c.wg.Add(1)
+		go c.pinger()
+	}
 }
 
 func (c *Client) reader() {
 	defer c.wg.Done()
 	var buf bytes.Buffer
+	conn := c.currentConn()
 
 	for {
 		buf.Reset()
 
-		_, r, err := c.conn.NextReader()
+		_, r, err := conn.NextReader()
 		if err != nil {
+			if c.isClosed() {
+				return
+			}
 			c.log.Printf("WebSocket read error: %v\n", err)
-			return
+			if conn = c.reconnect(conn); conn == nil {
+				c.end()
+				return
+			}
+			continue
 		}
+		c.extendDeadline(conn)
 
 		if _, err := buf.ReadFrom(r); err != nil {
 			c.log.Printf("Failed to read from WebSocket reader: %v\n", err)
@@ -138,18 +444,45 @@ func (c *Client) reader() {
 			c.log.Printf("Failed to unmarshal response: %v\n", err)
 			continue
 		}
+		// Only transcripts are printed.
+		switch response.Type {
+		case "":
+		case stt.ResponseDraining:
+			c.log.Printf("Server is draining: %s\n", response.Message)
+			continue
+		case stt.ResponseIdle:
+			c.log.Printf("Server is ending the session: %s\n", response.Message)
+			continue
+		case stt.ResponseSession:
+			c.setSession(response.Session)
+			continue
+		case stt.ResponseEndOfTranscript:
+			c.log.Println("Transcript complete")
+			c.end()
+			return
+		default:
+			continue
+		}
+
+		// Transcripts sent again after resuming the session were already
+		// handled.
+		if response.ID > 0 && !c.receive(response.ID) {
+			continue
+		}
 
 		// Check for duplicate messages using the buffer
-		if c.msgBuffer.IsSimilar(response.Sentence, c.similarityThreshold) {
+		msgBuffer := c.messageBuffer(response.Channel)
+		if msgBuffer.IsSimilar(response.Sentence, c.similarityThreshold) {
 			c.log.Printf("Skipping duplicate message: %s\n", response.Sentence)
+			c.ack(response.ID)
 			continue
 		}
 
 		// Add message to buffer for future deduplication
-		c.msgBuffer.Add(response.Sentence)
+		msgBuffer.Add(response.Sentence)
 
 		timestamp := time.Now().Format("15:04:05")
-		line := fmt.Sprintf("[%s] %s (confidence: %.2f)\n", timestamp, response.Sentence, response.Confidence)
+		line := c.formatResponse(response, timestamp)
 
 		fmt.Print(line)
 
@@ -161,9 +494,24 @@ func (c *Client) reader() {
 				c.bufWriter.Flush()
 			}
 		}
+		c.ack(response.ID)
 	}
 }
 
+// messageBuffer returns the deduplication buffer for the given channel,
+// creating it on first use. It is only called from the reader.
+func (c *Client) messageBuffer(channel int) *MessageBuffer {
+	if c.msgBuffers == nil {
+		c.msgBuffers = make(map[int]*MessageBuffer)
+	}
+	mb, ok := c.msgBuffers[channel]
+	if !ok {
+		mb = NewMessageBuffer(c.bufferSize)
+		c.msgBuffers[channel] = mb
+	}
+	return mb
+}
+
 func (c *Client) writer() {
 	defer c.wg.Done()
 	buf := make([]byte, framesPerBuffer*2) // int16 * 2 bytes each
@@ -174,17 +522,18 @@ func (c *Client) writer() {
 		n, err := c.audioReader.Read(buf)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
+				// The server sends the results it still has, and
+				// then ends the transcript.
+				if err := c.finish(); err != nil && !errors.Is(err, net.ErrClosed) {
+					c.log.Printf("WebSocket write error: %v\n", err)
+				}
 				return
 			}
 			c.log.Printf("Audio read error: %v\n", err)
 			break
 		}
 
-		request := stt.WebSocketRequest{
-			Buf: buf[:n],
-		}
-
-		if err := c.conn.WriteJSON(request); err != nil {
+		if err := c.send(buf[:n]); err != nil {
 			if !errors.Is(err, net.ErrClosed) {
 				c.log.Printf("WebSocket write error: %v\n", err)
 			}
@@ -193,10 +542,45 @@ func (c *Client) writer() {
 	}
 }
 
+// finish tells the server that the audio has run out, for it to send the
+// results it still has and end the transcript. While reconnecting, it is
+// sent once reconnected instead.
+func (c *Client) finish() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.finishing = true
+	if c.broken {
+		return nil
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	err := c.conn.WriteJSON(stt.WebSocketRequest{Type: stt.RequestFinish})
+	if err == nil || !c.canReconnect() {
+		return err
+	}
+	// The reader finds out too, and reconnects.
+	c.broken = true
+	return nil
+}
+
 func (c *Client) Close() {
 	c.log.Println("Closing client...")
+	c.mu.Lock()
+	if !c.closed && c.quit != nil {
+		close(c.quit)
+	}
+	c.closed = true
 	if c.conn != nil {
+		// A normal closure ends the session, rather than leaving the
+		// server waiting for it to be resumed.
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
 		c.conn.Close()
 	}
+	c.mu.Unlock()
 	c.wg.Wait()
 }
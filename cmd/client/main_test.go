@@ -45,7 +45,7 @@ func createTestClient(t *testing.T, conn *websocket.Conn, audioReader io.Reader,
 		conn:                conn,
 		audioReader:         audioReader,
 		log:                 logger,
-		msgBuffer:           NewMessageBuffer(10),
+		bufferSize:          10,
 		similarityThreshold: 0.8,
 	}
 
@@ -319,6 +319,66 @@ func TestClient(t *testing.T) {
 		}
 	})
 
+	t.Run("reader_LabelsChannels", func(t *testing.T) {
+		// The same answer on both channels is not a duplicate
+		responses := []stt.WebSocketResponse{
+			{Sentence: "Yes", Channel: 1},
+			{Sentence: "Yes", Channel: 2},
+			{Sentence: "Yes", Channel: 2},
+		}
+
+		done := make(chan bool)
+
+		server := mockWebSocketServer(t, func(conn *websocket.Conn) {
+			for _, resp := range responses {
+				if err := conn.WriteJSON(resp); err != nil {
+					t.Logf("Failed to send response: %v", err)
+					return
+				}
+			}
+			time.Sleep(200 * time.Millisecond)
+		})
+		defer server.Close()
+
+		conn := connectToTestServer(t, server)
+		defer conn.Close()
+
+		tmpFile, err := os.CreateTemp("", "test_output_*.txt")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+
+		client := createTestClient(t, conn, strings.NewReader(""), tmpFile)
+
+		client.wg.Add(1)
+		go func() {
+			defer close(done)
+			client.reader()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for responses")
+		}
+		client.Close()
+
+		tmpFile.Seek(0, 0)
+		content, err := io.ReadAll(tmpFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+
+		fileContent := string(content)
+		for _, label := range []string{"[channel 1] Yes", "[channel 2] Yes"} {
+			if strings.Count(fileContent, label) != 1 {
+				t.Errorf("Expected file to contain '%s' once, got: %s", label, fileContent)
+			}
+		}
+	})
+
 	t.Run("EndToEnd_Integration", func(t *testing.T) {
 		responses := []stt.WebSocketResponse{
 			{Sentence: "Integration test working"},
@@ -476,3 +536,82 @@ type errorReader struct {
 func (er *errorReader) Read(p []byte) (int, error) {
 	return 0, er.err
 }
+
+func TestSessionURL(t *testing.T) {
+	var phrases phraseFlags
+	for _, v := range []string{"Acme Cloud:10", "Jane Doe"} {
+		if err := phrases.Set(v); err != nil {
+			t.Fatalf("Set(%q) error = %v", v, err)
+		}
+	}
+	if err := phrases.Set(":5"); err == nil {
+		t.Error("Expected an error for a phrase without text")
+	}
+
+	var vocabularies stringFlags
+	for _, v := range []string{"medical", "product-catalog"} {
+		if err := vocabularies.Set(v); err != nil {
+			t.Fatalf("Set(%q) error = %v", v, err)
+		}
+	}
+
+	got, err := sessionURL("ws://localhost:8081/ws?token=abc", stt.SessionParams{
+		Diarize:      true,
+		Phrases:      phrases,
+		Vocabularies: vocabularies,
+		Models:       modelFlags{"google": "phone_call"},
+	})
+	if err != nil {
+		t.Fatalf("sessionURL() error = %v", err)
+	}
+
+	// Existing query params are kept
+	want := "ws://localhost:8081/ws?diarize=true&model=google%3Aphone_call&phrase=Acme+Cloud%3A10&phrase=Jane+Doe" +
+		"&token=abc&vocabulary=medical&vocabulary=product-catalog"
+	if got != want {
+		t.Errorf("sessionURL() = %q, want %q", got, want)
+	}
+}
+
+func TestClient_Finish(t *testing.T) {
+	server := mockWebSocketServer(t, func(conn *websocket.Conn) {
+		// Wait for the audio to run out
+		for {
+			var req stt.WebSocketRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Type == stt.RequestFinish {
+				break
+			}
+		}
+		conn.WriteJSON(stt.WebSocketResponse{ID: 1, Sentence: "last words"})
+		conn.WriteJSON(stt.WebSocketResponse{Type: stt.ResponseEndOfTranscript})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer server.Close()
+
+	conn := connectToTestServer(t, server)
+	defer conn.Close()
+
+	var output bytes.Buffer
+	client := createTestClient(t, conn, bytes.NewReader(make([]byte, 3200)), nil)
+	client.bufWriter = bufio.NewWriter(&output)
+	client.Start()
+
+	// The client is done on its own once the transcript ends
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the end of the transcript")
+	}
+	client.Close()
+
+	if !strings.Contains(output.String(), "last words") {
+		t.Errorf("Expected the output to contain the last words, got %q", output.String())
+	}
+}
@@ -10,7 +10,8 @@ const (
 )
 
 // MicrophoneReader implements io.ReadCloser for capturing audio from the microphone.
-// It uses PortAudio to capture 16-bit PCM audio at 16kHz sample rate.
+// It uses PortAudio to capture interleaved 16-bit PCM audio at the requested
+// sample rate and channel count.
 type MicrophoneReader struct {
 	stream *portaudio.Stream
 	buffer []int16
@@ -19,17 +20,17 @@ type MicrophoneReader struct {
 // NewMicrophoneReader creates a new MicrophoneReader that captures audio from the default input device.
 // It initializes PortAudio, opens an audio stream, and starts recording.
 // The caller must call Close() to properly clean up resources.
-func NewMicrophoneReader() (*MicrophoneReader, error) {
+func NewMicrophoneReader(rate, channels int) (*MicrophoneReader, error) {
 	// Initialize PortAudio
 	if err := portaudio.Initialize(); err != nil {
 		return nil, err
 	}
 
-	// Create audio buffer
-	buffer := make([]int16, framesPerBuffer)
+	// Create audio buffer, samples of all channels are interleaved
+	buffer := make([]int16, framesPerBuffer*channels)
 
 	// Open default audio stream
-	stream, err := portaudio.OpenDefaultStream(1, 0, float64(sampleRate), len(buffer), buffer)
+	stream, err := portaudio.OpenDefaultStream(channels, 0, float64(rate), framesPerBuffer, buffer)
 	if err != nil {
 		portaudio.Terminate()
 		return nil, err
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	stt "github.com/agnivade/stt_challenge"
+)
+
+// Capture modes selectable with the -mode flag.
+const (
+	modeContinuous = "continuous"
+	modeVAD        = "vad"
+	modePushToTalk = "push-to-talk"
+)
+
+// audioGate decides whether a chunk of captured audio is sent to the server.
+type audioGate interface {
+	Allow(chunk []byte) bool
+}
+
+// newAudioGate returns the gate for the given capture mode. It returns
+// nil for continuous mode, where every chunk is sent.
+// bytesPerSecond is the byte rate of the audio, across all channels.
+// Push-to-talk reads key presses from input and reports state changes to output.
+func newAudioGate(mode string, vadConfig stt.VADConfig, bytesPerSecond int, input io.Reader, output io.Writer) (audioGate, error) {
+	switch mode {
+	case modeContinuous:
+		return nil, nil
+	case modeVAD:
+		return &vadAudioGate{vad: stt.NewVAD(vadConfig, bytesPerSecond)}, nil
+	case modePushToTalk:
+		return newPushToTalk(input, output), nil
+	default:
+		return nil, fmt.Errorf("unsupported mode %q (want %s, %s or %s)", mode, modeContinuous, modeVAD, modePushToTalk)
+	}
+}
+
+// gatedReader implements io.Reader and drops the audio its gate does not allow.
+// Gating happens on PCM, before any encoding, so that encoded streams stay intact.
+type gatedReader struct {
+	src  io.Reader
+	gate audioGate
+}
+
+// Read implements io.Reader. It blocks until allowed audio or an error comes along.
+func (g *gatedReader) Read(p []byte) (int, error) {
+	for {
+		n, err := g.src.Read(p)
+		if n > 0 && !g.gate.Allow(p[:n]) {
+			n = 0
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (g *gatedReader) Close() error {
+	if closer, ok := g.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// vadAudioGate only allows audio while speech is detected, plus the hangover after it.
+type vadAudioGate struct {
+	vad *stt.VAD
+}
+
+// Allow implements audioGate.
+func (g *vadAudioGate) Allow(chunk []byte) bool {
+	return g.vad.IsSpeech(chunk)
+}
+
+// pushToTalk only allows audio while talking is toggled on. Every
+// press of Enter toggles it. Terminals are line buffered by default,
+// so Enter is the only key we can see without putting it in raw mode.
+type pushToTalk struct {
+	talking atomic.Bool
+}
+
+// newPushToTalk starts watching input for key presses. The goroutine
+// reading input is not stopped, it exits along with the process.
+func newPushToTalk(input io.Reader, output io.Writer) *pushToTalk {
+	p := &pushToTalk{}
+	fmt.Fprintln(output, "Push-to-talk: press Enter to start and stop talking.")
+
+	go func() {
+		scanner := bufio.NewScanner(input)
+		for scanner.Scan() {
+			if p.toggle() {
+				fmt.Fprintln(output, "Talking...")
+			} else {
+				fmt.Fprintln(output, "Muted.")
+			}
+		}
+	}()
+	return p
+}
+
+// toggle flips the talking state and returns the new state.
+func (p *pushToTalk) toggle() bool {
+	for {
+		old := p.talking.Load()
+		if p.talking.CompareAndSwap(old, !old) {
+			return !old
+		}
+	}
+}
+
+// Allow implements audioGate.
+func (p *pushToTalk) Allow([]byte) bool {
+	return p.talking.Load()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	stt "github.com/agnivade/stt_challenge"
+	"github.com/gorilla/websocket"
+)
+
+// toneChunk returns one microphone buffer of a square wave with the given amplitude.
+func toneChunk(amplitude int16) []byte {
+	chunk := make([]byte, framesPerBuffer*2)
+	for i := 0; i < framesPerBuffer; i++ {
+		v := amplitude
+		if i%2 == 1 {
+			v = -amplitude
+		}
+		binary.LittleEndian.PutUint16(chunk[2*i:], uint16(v))
+	}
+	return chunk
+}
+
+func TestNewAudioGate(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantNil bool
+		wantErr bool
+	}{
+		{modeContinuous, true, false},
+		{modeVAD, false, false},
+		{modePushToTalk, false, false},
+		{"always-on", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			gate, err := newAudioGate(tt.mode, stt.DefaultVADConfig(), sampleRate*2, strings.NewReader(""), io.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newAudioGate(%q) error = %v, wantErr %v", tt.mode, err, tt.wantErr)
+			}
+			if (gate == nil) != tt.wantNil {
+				t.Fatalf("newAudioGate(%q) = %v, wantNil %v", tt.mode, gate, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestVADAudioGate(t *testing.T) {
+	cfg := stt.DefaultVADConfig()
+	cfg.Hangover = 100 * time.Millisecond
+	gate, err := newAudioGate(modeVAD, cfg, sampleRate*2, nil, io.Discard)
+	if err != nil {
+		t.Fatalf("newAudioGate() error = %v", err)
+	}
+
+	// Each chunk is 64ms, so the hangover covers two chunks of silence
+	steps := []struct {
+		chunk []byte
+		want  bool
+	}{
+		{toneChunk(0), false},
+		{toneChunk(8000), true},
+		{toneChunk(0), true},
+		{toneChunk(0), true},
+		{toneChunk(0), false},
+	}
+
+	for i, step := range steps {
+		if got := gate.Allow(step.chunk); got != step.want {
+			t.Errorf("Step %d: Allow() = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestPushToTalk(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	var output syncBuffer
+	ptt := newPushToTalk(r, &output)
+
+	if ptt.Allow(nil) {
+		t.Fatal("Expected push-to-talk to start muted")
+	}
+
+	// Pressing Enter starts talking
+	w.Write([]byte("\n"))
+	waitFor(t, func() bool { return ptt.Allow(nil) })
+
+	// Pressing it again mutes
+	w.Write([]byte("\n"))
+	waitFor(t, func() bool { return !ptt.Allow(nil) })
+
+	waitFor(t, func() bool { return strings.Contains(output.String(), "Muted.") })
+	if !strings.Contains(output.String(), "Talking...") {
+		t.Errorf("Expected output to report talking, got: %s", output.String())
+	}
+}
+
+func TestGatedReader(t *testing.T) {
+	received := make(chan stt.WebSocketRequest, 10)
+
+	server := mockWebSocketServer(t, func(conn *websocket.Conn) {
+		for {
+			var req stt.WebSocketRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			// The finish request at the end of the audio isn't a chunk
+			if req.Type == "" {
+				received <- req
+			}
+		}
+	})
+	defer server.Close()
+
+	conn := connectToTestServer(t, server)
+	defer conn.Close()
+
+	// Silence, speech, then silence again after the hangover has run out
+	var audio bytes.Buffer
+	audio.Write(toneChunk(0))
+	audio.Write(toneChunk(8000))
+	for i := 0; i < 3; i++ {
+		audio.Write(toneChunk(0))
+	}
+
+	cfg := stt.DefaultVADConfig()
+	cfg.Hangover = 100 * time.Millisecond
+
+	gated := &gatedReader{src: &audio, gate: &vadAudioGate{vad: stt.NewVAD(cfg, sampleRate*2)}}
+	client := createTestClient(t, conn, gated, nil)
+
+	client.wg.Add(1)
+	go client.writer()
+
+	// Expect the speech chunk and two chunks of hangover only
+	var got int
+	timeout := time.After(500 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case <-received:
+			got++
+		case <-timeout:
+			done = true
+		}
+	}
+	client.Close()
+
+	if got != 3 {
+		t.Errorf("Expected 3 chunks to be sent, got %d", got)
+	}
+}
+
+// waitFor polls cond until it returns true or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// Ogg page header flags
+const (
+	oggBOS = 0x02
+	oggEOS = 0x04
+)
+
+// oggCRCTable is the lookup table for the CRC used by Ogg. It is the same
+// polynomial as IEEE, but without reflection, so hash/crc32 can't be used.
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggCRC computes the checksum of an Ogg page.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggPageWriter frames packets of a single logical stream into Ogg pages.
+// Every packet gets its own page, which keeps latency low for streaming.
+type oggPageWriter struct {
+	serial uint32
+	seq    uint32
+}
+
+// appendPage appends a page holding packet to dst. granule is the
+// granule position after the packet, and flags are the header type flags.
+// The packet must fit in a single page, which is 65025 bytes.
+func (w *oggPageWriter) appendPage(dst []byte, packet []byte, granule int64, flags byte) []byte {
+	// Lacing values: runs of 255, terminated by a value below 255.
+	segments := len(packet)/255 + 1
+
+	start := len(dst)
+	dst = append(dst, "OggS"...)
+	dst = append(dst, 0, flags)
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(granule))
+	dst = binary.LittleEndian.AppendUint32(dst, w.serial)
+	dst = binary.LittleEndian.AppendUint32(dst, w.seq)
+	crcOffset := len(dst)
+	dst = binary.LittleEndian.AppendUint32(dst, 0)
+	dst = append(dst, byte(segments))
+	for i := 0; i < segments-1; i++ {
+		dst = append(dst, 255)
+	}
+	dst = append(dst, byte(len(packet)%255))
+	dst = append(dst, packet...)
+
+	binary.LittleEndian.PutUint32(dst[crcOffset:], oggCRC(dst[start:]))
+	w.seq++
+	return dst
+}
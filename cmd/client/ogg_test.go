@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// oggPage is a decoded Ogg page, used to verify what oggPageWriter produces.
+type oggPage struct {
+	flags   byte
+	granule int64
+	serial  uint32
+	seq     uint32
+	packet  []byte
+}
+
+// parseOggPages decodes a stream of pages, each holding a single packet.
+func parseOggPages(t *testing.T, data []byte) []oggPage {
+	t.Helper()
+
+	var pages []oggPage
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("Invalid page header: %x", data[:min(len(data), 27)])
+		}
+
+		segments := int(data[26])
+		size := 0
+		for _, lacing := range data[27 : 27+segments] {
+			size += int(lacing)
+		}
+		headerSize := 27 + segments
+		page := data[:headerSize+size]
+
+		// The checksum is computed with the checksum field zeroed.
+		crc := binary.LittleEndian.Uint32(page[22:])
+		check := append([]byte{}, page...)
+		binary.LittleEndian.PutUint32(check[22:], 0)
+		if got := oggCRC(check); got != crc {
+			t.Fatalf("Page checksum = %08x, computed %08x", crc, got)
+		}
+
+		pages = append(pages, oggPage{
+			flags:   page[5],
+			granule: int64(binary.LittleEndian.Uint64(page[6:])),
+			serial:  binary.LittleEndian.Uint32(page[14:]),
+			seq:     binary.LittleEndian.Uint32(page[18:]),
+			packet:  page[headerSize:],
+		})
+		data = data[len(page):]
+	}
+	return pages
+}
+
+func TestOggCRC(t *testing.T) {
+	// Standard check value for CRC-32 with polynomial 0x04c11db7, no reflection, zero init.
+	if got := oggCRC([]byte("123456789")); got != 0x89a1897f {
+		t.Errorf("oggCRC() = %08x, want 89a1897f", got)
+	}
+}
+
+func TestOggPageWriter(t *testing.T) {
+	w := &oggPageWriter{serial: 42}
+
+	packets := [][]byte{
+		[]byte("first"),
+		bytes.Repeat([]byte{0xAB}, 255), // needs a terminating zero lacing value
+		bytes.Repeat([]byte{0xCD}, 600),
+		{},
+	}
+
+	var stream []byte
+	stream = w.appendPage(stream, packets[0], 0, oggBOS)
+	stream = w.appendPage(stream, packets[1], 960, 0)
+	stream = w.appendPage(stream, packets[2], 1920, 0)
+	stream = w.appendPage(stream, packets[3], 2880, oggEOS)
+
+	pages := parseOggPages(t, stream)
+	if len(pages) != len(packets) {
+		t.Fatalf("Expected %d pages, got %d", len(packets), len(pages))
+	}
+
+	wantFlags := []byte{oggBOS, 0, 0, oggEOS}
+	for i, page := range pages {
+		if page.serial != 42 {
+			t.Errorf("Page %d: serial = %d, want 42", i, page.serial)
+		}
+		if page.seq != uint32(i) {
+			t.Errorf("Page %d: sequence = %d, want %d", i, page.seq, i)
+		}
+		if page.flags != wantFlags[i] {
+			t.Errorf("Page %d: flags = %x, want %x", i, page.flags, wantFlags[i])
+		}
+		if page.granule != int64(960*i) {
+			t.Errorf("Page %d: granule = %d, want %d", i, page.granule, 960*i)
+		}
+		if !bytes.Equal(page.packet, packets[i]) {
+			t.Errorf("Page %d: packet mismatch, got %d bytes, want %d", i, len(page.packet), len(packets[i]))
+		}
+	}
+}
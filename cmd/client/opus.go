@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+const (
+	// opusFrameDuration is the length of audio in every Opus packet, in milliseconds.
+	opusFrameDuration = 20
+	// opusBitrate is the target bitrate in bits per second. It is plenty
+	// for speech, and a tenth of what raw 16kHz audio costs.
+	opusBitrate = 24000
+	// opusPreSkip is the number of 48kHz samples a decoder should drop from
+	// the start of the stream. It matches libopus's default lookahead.
+	opusPreSkip = 312
+	// maxOpusPacket is the largest possible Opus packet.
+	maxOpusPacket = 1275
+	// opusBytesPerSecond is about how many bytes of the stream a second of
+	// audio makes: packets at the target bitrate, each on a page with a
+	// 28 byte header.
+	opusBytesPerSecond = opusBitrate/8 + 28*1000/opusFrameDuration
+)
+
+// OpusReader implements io.Reader. It reads 16-bit mono PCM from the
+// underlying reader and returns it as an Ogg Opus stream, ready to be
+// passed straight through to the providers.
+type OpusReader struct {
+	src        io.Reader
+	sampleRate int
+	enc        *opus.Encoder
+	pages      oggPageWriter
+	pcm        []int16
+	raw        []byte
+	packet     []byte
+	granule    int64
+	out        []byte
+	pending    []byte
+	started    bool
+	err        error
+}
+
+// NewOpusReader creates an OpusReader for PCM audio at the given sample rate.
+// Opus supports 8, 12, 16, 24 and 48 kHz.
+func NewOpusReader(src io.Reader, sampleRate int) (*OpusReader, error) {
+	enc, err := opus.NewEncoder(sampleRate, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.SetBitrate(opusBitrate); err != nil {
+		return nil, err
+	}
+
+	samplesPerFrame := sampleRate * opusFrameDuration / 1000
+	return &OpusReader{
+		src:        src,
+		sampleRate: sampleRate,
+		enc:        enc,
+		pages:      oggPageWriter{serial: 1},
+		pcm:        make([]int16, samplesPerFrame),
+		raw:        make([]byte, samplesPerFrame*2),
+		packet:     make([]byte, maxOpusPacket),
+	}, nil
+}
+
+// Read implements io.Reader. Every page holds one 20ms Opus packet.
+func (r *OpusReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.pending = r.headers(&r.pages)
+	}
+
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.pending = r.nextPage()
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (r *OpusReader) Close() error {
+	if closer, ok := r.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Headers returns the pages the stream starts with, for a new session to
+// take the rest of the stream from wherever it is. The stream then goes on
+// with a gap in its page sequence numbers, like one that lost pages, and
+// with audio pages starting past granule position 0, like a live stream
+// picked up midway. It is safe to call while the stream is being read.
+func (r *OpusReader) Headers() []byte {
+	return r.headers(&oggPageWriter{serial: r.pages.serial})
+}
+
+// headers returns the two pages every Ogg Opus stream starts with, framed by
+// pages. See RFC 7845, section 5.
+func (r *OpusReader) headers(pages *oggPageWriter) []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, 1) // version, channel count
+	head = binary.LittleEndian.AppendUint16(head, opusPreSkip)
+	head = binary.LittleEndian.AppendUint32(head, uint32(r.sampleRate))
+	head = binary.LittleEndian.AppendUint16(head, 0) // output gain
+	head = append(head, 0)                           // mapping family
+
+	const vendor = "stt_challenge"
+	tags := []byte("OpusTags")
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(vendor)))
+	tags = append(tags, vendor...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0) // no user comments
+
+	out := pages.appendPage(nil, head, 0, oggBOS)
+	return pages.appendPage(out, tags, 0, 0)
+}
+
+// nextPage reads one frame of audio and returns it encoded in an Ogg page.
+// At the end of the input, the last partial frame is padded with silence
+// and marked as the end of the stream.
+func (r *OpusReader) nextPage() []byte {
+	n, err := io.ReadFull(r.src, r.raw)
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			r.err = err
+			return nil
+		}
+		r.err = io.EOF
+		clear(r.raw[n:])
+	}
+
+	for i := range r.pcm {
+		r.pcm[i] = int16(binary.LittleEndian.Uint16(r.raw[2*i:]))
+	}
+
+	size, encErr := r.enc.Encode(r.pcm, r.packet)
+	if encErr != nil {
+		r.err = encErr
+		return nil
+	}
+
+	// Granule positions always count 48kHz samples in Ogg Opus.
+	r.granule += 48 * opusFrameDuration
+
+	var flags byte
+	if r.err != nil {
+		flags = oggEOS
+	}
+	r.out = r.pages.appendPage(r.out[:0], r.packet[:size], r.granule, flags)
+	return r.out
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestOpusReader(t *testing.T) {
+	// 110ms of audio, which is five full 20ms frames and one partial frame.
+	pcm := make([]byte, sampleRate*2*110/1000)
+	for i := 0; i < len(pcm)/2; i++ {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(i*50)))
+	}
+
+	reader, err := NewOpusReader(bytes.NewReader(pcm), sampleRate)
+	if err != nil {
+		t.Fatalf("NewOpusReader() error = %v", err)
+	}
+
+	stream, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	pages := parseOggPages(t, stream)
+	if len(pages) != 8 {
+		t.Fatalf("Expected 2 header pages and 6 audio pages, got %d pages", len(pages))
+	}
+
+	// Identification header
+	head := pages[0].packet
+	if pages[0].flags != oggBOS {
+		t.Errorf("First page flags = %x, want BOS", pages[0].flags)
+	}
+	if len(head) != 19 || string(head[:8]) != "OpusHead" {
+		t.Fatalf("Invalid OpusHead packet: %q", head)
+	}
+	if head[9] != 1 {
+		t.Errorf("Channel count = %d, want 1", head[9])
+	}
+	if rate := binary.LittleEndian.Uint32(head[12:]); rate != sampleRate {
+		t.Errorf("Input sample rate = %d, want %d", rate, sampleRate)
+	}
+
+	// Comment header
+	if string(pages[1].packet[:8]) != "OpusTags" {
+		t.Errorf("Invalid OpusTags packet: %q", pages[1].packet)
+	}
+
+	// Audio pages, with granule positions counted at 48kHz
+	for i, page := range pages[2:] {
+		if len(page.packet) == 0 {
+			t.Errorf("Audio page %d is empty", i)
+		}
+		if want := int64(960 * (i + 1)); page.granule != want {
+			t.Errorf("Audio page %d: granule = %d, want %d", i, page.granule, want)
+		}
+	}
+	if last := pages[len(pages)-1]; last.flags != oggEOS {
+		t.Errorf("Last page flags = %x, want EOS", last.flags)
+	}
+}
+
+func TestOpusReader_Headers(t *testing.T) {
+	pcm := make([]byte, sampleRate*2*100/1000)
+	reader, err := NewOpusReader(bytes.NewReader(pcm), sampleRate)
+	if err != nil {
+		t.Fatalf("NewOpusReader() error = %v", err)
+	}
+	stream, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	// Even after the stream was read, they are the pages it started with
+	headers := reader.Headers()
+	if !bytes.HasPrefix(stream, headers) {
+		t.Fatalf("Headers() is not the start of the stream")
+	}
+	pages := parseOggPages(t, headers)
+	if len(pages) != 2 || string(pages[0].packet[:8]) != "OpusHead" || string(pages[1].packet[:8]) != "OpusTags" {
+		t.Errorf("Expected the OpusHead and OpusTags pages, got %d pages", len(pages))
+	}
+}
+
+func TestOpusReader_ReadError(t *testing.T) {
+	reader, err := NewOpusReader(&errorReader{err: io.ErrClosedPipe}, sampleRate)
+	if err != nil {
+		t.Fatalf("NewOpusReader() error = %v", err)
+	}
+
+	// The headers come first, then the error.
+	if _, err := io.ReadAll(reader); err != io.ErrClosedPipe {
+		t.Errorf("Expected io.ErrClosedPipe, got %v", err)
+	}
+}
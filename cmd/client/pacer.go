@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// PacedReader implements io.Reader and throttles reads from the underlying
+// reader to the real-time playback rate of the audio it carries. This makes
+// file input arrive at the server the same way microphone input does.
+type PacedReader struct {
+	src            io.Reader
+	bytesPerSecond float64
+	start          time.Time
+	read           int64
+}
+
+// NewPacedReader creates a PacedReader for audio with the given byte rate.
+// A speed of 2 plays the audio twice as fast as real time, 0.5 at half speed.
+func NewPacedReader(src io.Reader, bytesPerSecond int, speed float64) *PacedReader {
+	return &PacedReader{
+		src:            src,
+		bytesPerSecond: float64(bytesPerSecond) * speed,
+	}
+}
+
+// Read implements io.Reader. It returns a chunk only once its audio would have
+// finished playing, just like a microphone returns a buffer once it is captured.
+func (p *PacedReader) Read(buf []byte) (int, error) {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+
+	n, err := p.src.Read(buf)
+	p.read += int64(n)
+
+	due := p.start.Add(time.Duration(float64(p.read) / p.bytesPerSecond * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (p *PacedReader) Close() error {
+	if closer, ok := p.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPacedReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		speed float64
+		want  time.Duration
+	}{
+		{"real time", 1, 200 * time.Millisecond},
+		{"double speed", 2, 100 * time.Millisecond},
+		{"half speed", 0.5, 400 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 200ms of 16kHz s16le audio, read in 20ms chunks.
+			const bytesPerSecond = sampleRate * 2
+			audio := make([]byte, bytesPerSecond/5)
+			paced := NewPacedReader(bytes.NewReader(audio), bytesPerSecond, tt.speed)
+
+			start := time.Now()
+			buf := make([]byte, bytesPerSecond/50)
+			total := 0
+			for {
+				n, err := paced.Read(buf)
+				total += n
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+			}
+			elapsed := time.Since(start)
+
+			if total != len(audio) {
+				t.Errorf("Expected %d bytes, got %d", len(audio), total)
+			}
+			if elapsed < tt.want {
+				t.Errorf("Expected reads to take at least %v, took %v", tt.want, elapsed)
+			}
+			if elapsed > tt.want+150*time.Millisecond {
+				t.Errorf("Expected reads to take about %v, took %v", tt.want, elapsed)
+			}
+		})
+	}
+}
+
+func TestPacedReader_Close(t *testing.T) {
+	closer := &closeRecorder{}
+	paced := NewPacedReader(closer, sampleRate*2, 1)
+
+	if err := paced.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !closer.closed {
+		t.Error("Expected underlying reader to be closed")
+	}
+}
+
+// closeRecorder is an empty io.ReadCloser that remembers whether it was closed.
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
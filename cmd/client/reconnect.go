@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	stt "github.com/agnivade/stt_challenge"
+	"github.com/gorilla/websocket"
+)
+
+// Delays between reconnect attempts, doubling from the first to the last.
+const (
+	initialReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay     = 10 * time.Second
+)
+
+// writeTimeout is how long writing a chunk of audio may take, for a
+// connection that no longer takes any to be noticed.
+const writeTimeout = 10 * time.Second
+
+// Done returns a channel closed once the session is over, because the
+// transcript ended or the connection is lost for good.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) currentConn() *websocket.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Client) canReconnect() bool {
+	return c.url != "" && c.maxReconnects > 0
+}
+
+// end tells whoever waits on Done that the session is over. It is only
+// called by the reader, right before it exits.
+func (c *Client) end() {
+	if c.done != nil {
+		close(c.done)
+	}
+}
+
+// setSession takes the token of the session the server sent, to resume it
+// with after reconnecting.
+func (c *Client) setSession(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.token {
+	case "":
+	case token:
+		c.log.Println("Resumed the session")
+	default:
+		c.log.Println("Started a new session, the previous one couldn't be resumed")
+	}
+	c.token = token
+}
+
+// receive takes the ID of a transcript, and reports whether it is new
+// rather than one sent again after resuming the session.
+func (c *Client) receive(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id <= c.lastID {
+		return false
+	}
+	if id > c.lastID+1 {
+		c.log.Printf("Missed %d transcript(s) the server could no longer send\n", id-c.lastID-1)
+	}
+	c.lastID = id
+	return true
+}
+
+// ack tells the server the transcript was handled, for it not to send it
+// again. Transcripts left unacknowledged while reconnecting are
+// acknowledged by resuming the session after them.
+func (c *Client) ack(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id == 0 || c.broken || c.closed {
+		return
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := c.conn.WriteJSON(stt.WebSocketRequest{Ack: id}); err != nil && c.canReconnect() {
+		// The reader finds out too, and reconnects.
+		c.broken = true
+	}
+}
+
+// send sends a chunk of audio to the server. While reconnecting, it is kept
+// to be sent once reconnected instead.
+func (c *Client) send(audio []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	if !c.broken {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := c.conn.WriteJSON(stt.WebSocketRequest{Buf: audio})
+		if err == nil || !c.canReconnect() {
+			return err
+		}
+		// The reader finds out too, and reconnects.
+		c.broken = true
+	}
+	c.keep(audio)
+	return nil
+}
+
+// keep keeps a chunk of audio to send once reconnected, dropping the oldest
+// ones past the backlog limit.
+func (c *Client) keep(audio []byte) {
+	c.backlog = append(c.backlog, slices.Clone(audio))
+	c.backlogBytes += len(audio)
+	for c.backlogBytes > c.backlogLimit && len(c.backlog) > 0 {
+		c.backlogBytes -= len(c.backlog[0])
+		c.backlog = c.backlog[1:]
+	}
+}
+
+// sendBacklog sends the audio kept while reconnecting, after the stream
+// headers if it is a new session, and the finish request if the audio ran
+// out meanwhile. It must be called with mu held.
+func (c *Client) sendBacklog() error {
+	if c.newSession && len(c.streamHeaders) > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.conn.WriteJSON(stt.WebSocketRequest{Buf: c.streamHeaders}); err != nil {
+			return err
+		}
+	}
+	c.newSession = false
+
+	for len(c.backlog) > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.conn.WriteJSON(stt.WebSocketRequest{Buf: c.backlog[0]}); err != nil {
+			return err
+		}
+		c.backlogBytes -= len(c.backlog[0])
+		c.backlog = c.backlog[1:]
+	}
+	if !c.finishing {
+		return nil
+	}
+	// Finishing a session that already was is fine.
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteJSON(stt.WebSocketRequest{Type: stt.RequestFinish})
+}
+
+// reconnect connects to the server again once the connection dropped,
+// resuming the session if the server gave it a token, and sends the audio
+// kept meanwhile. It retries with exponential backoff, and returns nil once
+// the client is closed or out of attempts.
+func (c *Client) reconnect(dropped *websocket.Conn) *websocket.Conn {
+	dropped.Close()
+	if !c.canReconnect() {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.broken = true
+	c.mu.Unlock()
+
+	delay := initialReconnectDelay
+	for attempt := 1; attempt <= c.maxReconnects; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-c.quit:
+			return nil
+		}
+		delay = min(2*delay, maxReconnectDelay)
+
+		conn, err := c.dial()
+		if err != nil {
+			c.log.Printf("Reconnect attempt %d of %d failed: %v\n", attempt, c.maxReconnects, err)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		c.conn = conn
+		c.keepAlive(conn)
+		// If the backlog can't be sent, the reader finds out this
+		// connection dropped too.
+		c.broken = c.sendBacklog() != nil
+		c.mu.Unlock()
+
+		c.log.Println("Reconnected")
+		return conn
+	}
+
+	c.log.Printf("Giving up after %d reconnect attempts\n", c.maxReconnects)
+	return nil
+}
+
+// dial connects to the server, resuming the session if there is one. A
+// session the server doesn't know, having expired or lived on another
+// server, is replaced by a new one.
+func (c *Client) dial() (*websocket.Conn, error) {
+	c.mu.Lock()
+	token, lastID := c.token, c.lastID
+	c.mu.Unlock()
+
+	if token != "" {
+		conn, resp, err := websocket.DefaultDialer.Dial(resumeURL(c.url, token, lastID), nil)
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return conn, err
+		}
+		c.log.Println("The session can't be resumed, starting a new one")
+		// A new session numbers its transcripts from the start, and
+		// needs the stream headers.
+		c.mu.Lock()
+		c.lastID = 0
+		c.newSession = true
+		c.mu.Unlock()
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(c.url, nil)
+	return conn, err
+}
+
+// resumeURL returns the URL resuming the session with the token, after the
+// transcript with the last ID. The session params are left in, the server
+// ignores them.
+func resumeURL(sessionURL, token string, lastID uint64) string {
+	u, err := url.Parse(sessionURL)
+	if err != nil {
+		// It was built by parsing it in the first place.
+		return sessionURL
+	}
+	q := u.Query()
+	q.Set("resume", token)
+	q.Set("last", strconv.FormatUint(lastID, 10))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
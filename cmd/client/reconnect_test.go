@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	stt "github.com/agnivade/stt_challenge"
+	"github.com/gorilla/websocket"
+)
+
+// slowReader returns a chunk of audio every few milliseconds, like a
+// microphone.
+type slowReader struct{}
+
+func (slowReader) Read(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	n := min(len(p), 320)
+	clear(p[:n])
+	return n, nil
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var mu sync.Mutex
+	var queries []string
+	var acks []uint64
+	resumedAudio := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		first := len(queries) == 1
+		mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(stt.WebSocketResponse{Type: stt.ResponseSession, Session: "abc"})
+		if first {
+			conn.WriteJSON(stt.WebSocketResponse{ID: 1, Sentence: "before the drop"})
+			// Drop the connection once the transcript is acknowledged,
+			// without a close frame
+			for {
+				var req stt.WebSocketRequest
+				if err := conn.ReadJSON(&req); err != nil || req.Ack == 1 {
+					break
+				}
+			}
+			conn.UnderlyingConn().Close()
+			return
+		}
+
+		// The first transcript is sent again, as if the ack was lost
+		conn.WriteJSON(stt.WebSocketResponse{ID: 1, Sentence: "before the drop"})
+		conn.WriteJSON(stt.WebSocketResponse{ID: 2, Sentence: "after the reconnect"})
+		audio := false
+		for {
+			var req stt.WebSocketRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Ack > 0 {
+				mu.Lock()
+				acks = append(acks, req.Ack)
+				mu.Unlock()
+			}
+			if len(req.Buf) > 0 && !audio {
+				audio = true
+				close(resumedAudio)
+			}
+		}
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?encoding=linear16"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+
+	var output bytes.Buffer
+	client := &Client{
+		conn:                conn,
+		audioReader:         slowReader{},
+		log:                 log.New(io.Discard, "", 0),
+		bufWriter:           bufio.NewWriter(&output),
+		bufferSize:          10,
+		similarityThreshold: 0.8,
+		url:                 wsURL,
+		maxReconnects:       3,
+		backlogLimit:        1 << 20,
+	}
+	client.Start()
+
+	select {
+	case <-resumedAudio:
+	case <-client.Done():
+		t.Fatal("The client should reconnect")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for audio after reconnecting")
+	}
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 2 {
+		t.Fatalf("Expected 2 connections, got %d", len(queries))
+	}
+	if !strings.Contains(queries[1], "resume=abc") || !strings.Contains(queries[1], "last=1") ||
+		!strings.Contains(queries[1], "encoding=linear16") {
+		t.Errorf("Expected the second connection to resume the session after the first transcript, got query %q", queries[1])
+	}
+	if !strings.Contains(output.String(), "after the reconnect") {
+		t.Errorf("Expected the output to contain the transcript after the reconnect, got %q", output.String())
+	}
+	if n := strings.Count(output.String(), "before the drop"); n != 1 {
+		t.Errorf("Expected the transcript sent again to be written once, got %d times in %q", n, output.String())
+	}
+	if !slices.Contains(acks, 2) {
+		t.Errorf("Expected the transcript after the reconnect to be acknowledged, got acks %v", acks)
+	}
+}
+
+func TestClient_DialExpiredSession(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("resume") {
+			http.Error(w, "unknown or expired session", http.StatusNotFound)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	client := &Client{
+		log:   log.New(io.Discard, "", 0),
+		url:   "ws" + strings.TrimPrefix(server.URL, "http"),
+		token: "expired",
+	}
+	conn, err := client.dial()
+	if err != nil {
+		t.Fatalf("Expected a new session, got error %v", err)
+	}
+	conn.Close()
+}
+
+func TestClient_NewSessionHeaders(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var mu sync.Mutex
+	connections := 0
+	firstAudio := make(chan []byte, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("resume") {
+			http.Error(w, "unknown or expired session", http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		connections++
+		first := connections == 1
+		mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(stt.WebSocketResponse{Type: stt.ResponseSession, Session: "abc"})
+		if first {
+			// Drop the connection once some audio came in
+			var req stt.WebSocketRequest
+			for len(req.Buf) == 0 {
+				if err := conn.ReadJSON(&req); err != nil {
+					return
+				}
+			}
+			conn.UnderlyingConn().Close()
+			return
+		}
+
+		for {
+			var req stt.WebSocketRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if len(req.Buf) > 0 {
+				firstAudio <- req.Buf
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?encoding=ogg_opus"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+
+	client := &Client{
+		conn:          conn,
+		audioReader:   slowReader{},
+		log:           log.New(io.Discard, "", 0),
+		bufferSize:    10,
+		url:           wsURL,
+		maxReconnects: 3,
+		backlogLimit:  1 << 20,
+		streamHeaders: []byte("headers"),
+	}
+	client.Start()
+	defer client.Close()
+
+	// The new session gets the stream from its headers
+	select {
+	case audio := <-firstAudio:
+		if string(audio) != "headers" {
+			t.Errorf("Expected the new session to get the stream headers first, got %q", audio)
+		}
+	case <-client.Done():
+		t.Fatal("The client should start a new session")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for audio of the new session")
+	}
+}
+
+func TestClient_GivesUp(t *testing.T) {
+	server := mockWebSocketServer(t, func(conn *websocket.Conn) {})
+	defer server.Close()
+
+	conn := connectToTestServer(t, server)
+	client := createTestClient(t, conn, strings.NewReader(""), nil)
+	client.Start()
+
+	// Without reconnecting, the client is done once the server goes away
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("The client should be done once the connection is lost")
+	}
+	client.Close()
+}
+
+func TestClient_Keep(t *testing.T) {
+	client := &Client{backlogLimit: 5}
+	for _, chunk := range []string{"ab", "cd", "ef"} {
+		client.keep([]byte(chunk))
+	}
+	// The oldest audio goes first
+	if len(client.backlog) != 2 || string(client.backlog[0]) != "cd" || client.backlogBytes != 4 {
+		t.Errorf("Expected the backlog [cd ef], got %q", client.backlog)
+	}
+}
+
+func TestResumeURL(t *testing.T) {
+	got := resumeURL("ws://localhost:8081/ws?encoding=ogg_opus", "abc", 42)
+	want := "ws://localhost:8081/ws?encoding=ogg_opus&last=42&resume=abc"
+	if got != want {
+		t.Errorf("resumeURL() = %q, want %q", got, want)
+	}
+}
+
+func TestClient_Receive(t *testing.T) {
+	client := &Client{log: log.New(io.Discard, "", 0)}
+
+	for _, tt := range []struct {
+		id   uint64
+		want bool
+	}{
+		{id: 1, want: true},
+		{id: 2, want: true},
+		// Sent again after resuming
+		{id: 1, want: false},
+		{id: 2, want: false},
+		// A gap is logged, and taken
+		{id: 5, want: true},
+		{id: 4, want: false},
+	} {
+		if got := client.receive(tt.id); got != tt.want {
+			t.Errorf("receive(%d) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
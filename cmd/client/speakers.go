@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	stt "github.com/agnivade/stt_challenge"
+)
+
+// speakerTurn is a run of consecutive words said by the same speaker.
+type speakerTurn struct {
+	speaker int
+	text    string
+}
+
+// speakerTurns splits a response into the runs of words each speaker said.
+// Responses without words are a single turn of the response's speaker.
+func speakerTurns(response stt.WebSocketResponse) []speakerTurn {
+	if len(response.Words) == 0 {
+		return []speakerTurn{{speaker: response.Speaker, text: response.Sentence}}
+	}
+
+	var turns []speakerTurn
+	var words []string
+	speaker := response.Words[0].Speaker
+	for _, w := range response.Words {
+		if w.Speaker != speaker {
+			turns = append(turns, speakerTurn{speaker: speaker, text: strings.Join(words, " ")})
+			words = words[:0]
+			speaker = w.Speaker
+		}
+		words = append(words, w.Word)
+	}
+	return append(turns, speakerTurn{speaker: speaker, text: strings.Join(words, " ")})
+}
+
+// formatResponse returns the lines to print for a response. Every speaker
+// turn gets its own line, and a "Speaker N:" prefix when the speaker changes.
+// Consecutive lines by the same speaker are indented under the first one.
+func (c *Client) formatResponse(response stt.WebSocketResponse, timestamp string) string {
+	prefix := fmt.Sprintf("[%s] ", timestamp)
+	if response.Channel > 0 {
+		prefix += fmt.Sprintf("[channel %d] ", response.Channel)
+	}
+	if c.showLanguage && response.Language != "" {
+		prefix += fmt.Sprintf("[%s] ", response.Language)
+	}
+
+	var sb strings.Builder
+	for _, turn := range speakerTurns(response) {
+		sb.WriteString(prefix)
+		if turn.speaker > 0 {
+			label := fmt.Sprintf("Speaker %d: ", turn.speaker)
+			if turn.speaker == c.lastSpeaker && response.Channel == c.lastChannel {
+				label = strings.Repeat(" ", len(label))
+			}
+			sb.WriteString(label)
+			c.lastSpeaker = turn.speaker
+			c.lastChannel = response.Channel
+		}
+		fmt.Fprintf(&sb, "%s (confidence: %.2f)\n", turn.text, response.Confidence)
+	}
+	// Runner-up transcripts go under the sentence, numbered from 2.
+	indent := strings.Repeat(" ", len(prefix))
+	for i, alt := range response.Alternatives {
+		fmt.Fprintf(&sb, "%s#%d: %s (confidence: %.2f)\n", indent, i+2, alt.Sentence, alt.Confidence)
+	}
+	return sb.String()
+}
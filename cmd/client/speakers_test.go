@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	stt "github.com/agnivade/stt_challenge"
+)
+
+func TestSpeakerTurns(t *testing.T) {
+	tests := []struct {
+		name     string
+		response stt.WebSocketResponse
+		want     []speakerTurn
+	}{
+		{
+			name:     "no words",
+			response: stt.WebSocketResponse{Sentence: "Hello there", Speaker: 2},
+			want:     []speakerTurn{{speaker: 2, text: "Hello there"}},
+		},
+		{
+			name: "single speaker",
+			response: stt.WebSocketResponse{
+				Sentence: "Hello there",
+				Words: []stt.WebSocketWord{
+					{Word: "Hello", Speaker: 1},
+					{Word: "there", Speaker: 1},
+				},
+			},
+			want: []speakerTurn{{speaker: 1, text: "Hello there"}},
+		},
+		{
+			name: "speaker changes mid sentence",
+			response: stt.WebSocketResponse{
+				Sentence: "Hello there hi",
+				Words: []stt.WebSocketWord{
+					{Word: "Hello", Speaker: 1},
+					{Word: "there", Speaker: 1},
+					{Word: "hi", Speaker: 2},
+					{Word: "again", Speaker: 1},
+				},
+			},
+			want: []speakerTurn{
+				{speaker: 1, text: "Hello there"},
+				{speaker: 2, text: "hi"},
+				{speaker: 1, text: "again"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := speakerTurns(tt.response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("speakerTurns() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_FormatResponse(t *testing.T) {
+	c := &Client{}
+
+	responses := []stt.WebSocketResponse{
+		{Sentence: "Good morning", Confidence: 0.9, Speaker: 1},
+		{Sentence: "Let's begin", Confidence: 0.8, Speaker: 1},
+		{
+			Sentence:   "Sure okay",
+			Confidence: 0.7,
+			Words: []stt.WebSocketWord{
+				{Word: "Sure", Speaker: 2},
+				{Word: "okay", Speaker: 1},
+			},
+		},
+		{Sentence: "No diarization", Confidence: 0.6},
+	}
+
+	var got string
+	for _, r := range responses {
+		got += c.formatResponse(r, "10:00:00")
+	}
+
+	want := "[10:00:00] Speaker 1: Good morning (confidence: 0.90)\n" +
+		"[10:00:00]            Let's begin (confidence: 0.80)\n" +
+		"[10:00:00] Speaker 2: Sure (confidence: 0.70)\n" +
+		"[10:00:00] Speaker 1: okay (confidence: 0.70)\n" +
+		"[10:00:00] No diarization (confidence: 0.60)\n"
+	if got != want {
+		t.Errorf("formatResponse() output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestClient_FormatResponse_Language(t *testing.T) {
+	response := stt.WebSocketResponse{Sentence: "namaste", Confidence: 0.9, Channel: 2, Language: "hi-in"}
+
+	c := &Client{}
+	if got, want := c.formatResponse(response, "10:00:00"), "[10:00:00] [channel 2] namaste (confidence: 0.90)\n"; got != want {
+		t.Errorf("formatResponse() = %q, want %q", got, want)
+	}
+
+	c = &Client{showLanguage: true}
+	if got, want := c.formatResponse(response, "10:00:00"), "[10:00:00] [channel 2] [hi-in] namaste (confidence: 0.90)\n"; got != want {
+		t.Errorf("formatResponse() = %q, want %q", got, want)
+	}
+}
+
+func TestClient_FormatResponse_Alternatives(t *testing.T) {
+	response := stt.WebSocketResponse{
+		Sentence:   "recognize speech",
+		Confidence: 0.9,
+		Alternatives: []stt.WebSocketAlternative{
+			{Sentence: "wreck a nice beach", Confidence: 0.6},
+			{Sentence: "recognise peach", Confidence: 0.3},
+		},
+	}
+
+	c := &Client{}
+	want := "[10:00:00] recognize speech (confidence: 0.90)\n" +
+		"           #2: wreck a nice beach (confidence: 0.60)\n" +
+		"           #3: recognise peach (confidence: 0.30)\n"
+	if got := c.formatResponse(response, "10:00:00"); got != want {
+		t.Errorf("formatResponse() output:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -7,67 +7,162 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
-	speech "cloud.google.com/go/speech/apiv1"
 	stt "github.com/agnivade/stt_challenge"
 	"github.com/agnivade/stt_challenge/providers"
-	"github.com/agnivade/stt_challenge/providers/deepgram"
-	"github.com/agnivade/stt_challenge/providers/google"
+	// Provider types register themselves with the providers package.
+	_ "github.com/agnivade/stt_challenge/providers/deepgram"
+	_ "github.com/agnivade/stt_challenge/providers/google"
 )
 
+// providerFlags are the flags a config file replaces.
+var providerFlags = []string{
+	"google", "deepgram",
+	"google-model", "google-allowed-models",
+	"deepgram-model", "deepgram-allowed-models",
+}
+
 func main() {
 	// Parse command line flags
+	configPath := flag.String("config", "", "Provider config file in YAML or JSON, replacing the -google, -deepgram and model flags, reloaded on SIGHUP")
 	enableGoogle := flag.Bool("google", true, "Enable Google Speech provider")
 	enableDeepgram := flag.Bool("deepgram", true, "Enable Deepgram provider")
 	port := flag.String("port", "8081", "Server port")
+	resumeTimeout := flag.Duration("resume-timeout", 10*time.Second, "How long the session of a dropped connection is kept for the client to resume it (0 turns resuming off)")
+	pingInterval := flag.Duration("ping-interval", 20*time.Second, "How often connections are pinged; those answering no ping for two intervals are dropped")
+	idleTimeout := flag.Duration("idle-timeout", 0, "How long a client may send no audio before its session is ended (0 turns it off)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long live sessions get to finish on shutdown before they are closed")
+	enableVAD := flag.Bool("vad", false, "Gate long silences before they reach the providers")
+	vadThreshold := flag.Float64("vad-threshold", stt.DefaultVADConfig().ThresholdDBFS, "RMS level in dBFS above which audio counts as speech")
+	strategy := flag.String("strategy", string(stt.StrategyLatency), "How to pick results: latency (fastest provider) or fusion (vote across all providers)")
+	fusionWindow := flag.Duration("fusion-window", 0, "How long fusion waits for all providers to transcribe an utterance (0 uses 1.5s)")
+	audioQueueSize := flag.Int("audio-queue-size", 0, "Chunks of audio every provider may fall behind (0 uses 100)")
+	slowProvider := flag.String("slow-provider", string(stt.OverflowDropOldest), "What happens to a provider falling further behind: drop_oldest (drop its oldest audio, raw audio only) or disconnect (stop sending it audio)")
+	resultQueueSize := flag.Int("result-queue-size", 0, "Results a client may fall behind (0 uses 100)")
+	slowClient := flag.String("slow-client", string(stt.OverflowDropOldest), "What happens to a client falling further behind: drop_oldest (drop its oldest results) or disconnect")
+	providerWeights := flag.String("provider-weights", "", "Comma-separated provider=weight pairs for fusion votes, like google=1.5,deepgram=1")
+	googleModel := flag.String("google-model", "", "Default Google model, like phone_call or latest_long (empty uses Google's default)")
+	googleModels := flag.String("google-allowed-models", "", "Comma-separated Google models clients may ask for")
+	deepgramModel := flag.String("deepgram-model", "", "Default Deepgram model, like nova-3-medical (empty uses nova-3)")
+	deepgramModels := flag.String("deepgram-allowed-models", "", "Comma-separated Deepgram models clients may ask for")
+	profanityFilter := flag.Bool("profanity-filter", false, "Mask profanity in every session")
+	redact := flag.String("redact", "", "Comma-separated personal data to redact in every session: credit_card, ssn, phone, email")
+	providerRedaction := flag.Bool("provider-redaction", false, "Also ask the providers to redact what they can")
+	normalize := flag.Bool("normalize", true, "Give every transcript sentence casing and punctuation, whichever provider made it")
+	itn := flag.Bool("itn", false, "Write spoken numbers, amounts and percentages as digits, like $25")
+	var replacements replaceFlags
+	flag.Var(&replacements, "replace", `Find and replace rule "pattern=>replacement" applied to every transcript (repeatable)`)
+	vocabularyDir := flag.String("vocabulary-dir", "", "Directory of named vocabularies, one JSON file each (empty keeps them in memory)")
 	flag.Parse()
 
-	// Create providers based on flags
-	var providerList []providers.Provider
-	var cleanupFuncs []func() error
+	cfg := stt.DefaultConfig()
+	cfg.VAD.Enabled = *enableVAD
+	cfg.VAD.ThresholdDBFS = *vadThreshold
+	cfg.ResumeTimeout = *resumeTimeout
+	cfg.PingInterval = *pingInterval
+	cfg.IdleTimeout = *idleTimeout
+	selectionStrategy, err := stt.ParseSelectionStrategy(*strategy)
+	if err != nil {
+		log.Fatalf("Invalid -strategy: %v", err)
+	}
+	weights, err := parseWeights(*providerWeights)
+	if err != nil {
+		log.Fatalf("Invalid -provider-weights: %v", err)
+	}
+	slowProviderPolicy, err := stt.ParseOverflowPolicy(*slowProvider)
+	if err != nil {
+		log.Fatalf("Invalid -slow-provider: %v", err)
+	}
+	slowClientPolicy, err := stt.ParseOverflowPolicy(*slowClient)
+	if err != nil {
+		log.Fatalf("Invalid -slow-client: %v", err)
+	}
+	cfg.Selector = stt.SelectorConfig{
+		Strategy:        selectionStrategy,
+		FusionWindow:    *fusionWindow,
+		ProviderWeights: weights,
+		Backpressure: stt.BackpressureConfig{
+			AudioQueueSize:  *audioQueueSize,
+			SlowProvider:    slowProviderPolicy,
+			ResultQueueSize: *resultQueueSize,
+			SlowClient:      slowClientPolicy,
+		},
+	}
 
-	if *enableGoogle {
-		provider, cleanup, err := createGoogleProvider()
-		if err != nil {
-			log.Printf("Failed to create Google provider: %v", err)
-		} else {
-			providerList = append(providerList, provider)
-			if cleanup != nil {
-				cleanupFuncs = append(cleanupFuncs, cleanup)
+	// Without a config file, the provider flags make up the same config.
+	fileConfig := stt.FileConfig{Providers: []providers.Settings{
+		{Type: "google", Enabled: enableGoogle, Model: *googleModel, AllowedModels: splitList(*googleModels)},
+		{Type: "deepgram", Enabled: enableDeepgram, Model: *deepgramModel, AllowedModels: splitList(*deepgramModels)},
+	}}
+	if *configPath != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if slices.Contains(providerFlags, f.Name) {
+				log.Fatalf("-%s can't be used with -config, set it in the config file instead", f.Name)
 			}
+		})
+		fileConfig, err = stt.LoadFileConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
 		}
 	}
 
-	if *enableDeepgram {
-		provider, cleanup, err := createDeepgramProvider()
+	cfg.Formatting = stt.FormattingConfig{
+		Normalize:                *normalize,
+		InverseTextNormalization: *itn,
+		Replacements:             replacements,
+	}
+
+	cfg.Redaction = stt.RedactionConfig{
+		Profanity:      *profanityFilter,
+		ProviderNative: *providerRedaction,
+	}
+	if *redact != "" {
+		types, err := stt.ParsePIITypes(*redact)
 		if err != nil {
-			log.Printf("Failed to create Deepgram provider: %v", err)
-		} else {
-			providerList = append(providerList, provider)
-			if cleanup != nil {
-				cleanupFuncs = append(cleanupFuncs, cleanup)
-			}
+			log.Fatalf("Invalid -redact: %v", err)
 		}
+		cfg.Redaction.PII = types
+	}
+
+	vocabularies, err := stt.NewVocabularyStore(*vocabularyDir)
+	if err != nil {
+		log.Fatalf("Failed to load vocabularies: %v", err)
 	}
+	cfg.Vocabularies = vocabularies
 
-	if len(providerList) == 0 {
+	// The admin API is not found without a token to guard it. The config
+	// file may set one instead.
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if cfg.AdminToken == "" && fileConfig.AdminToken == "" {
+		log.Printf("ADMIN_TOKEN is not set, the admin API is off")
+	}
+
+	// The config file can be reloaded, on SIGHUP or through the admin API
+	r := &reloader{path: *configPath}
+	if r.path != "" {
+		cfg.Reloader = r.reload
+	}
+	r.cfg = cfg
+
+	// Create the enabled providers, highest priority first
+	r.current = newProviderSet(fileConfig)
+	if len(r.current.providers) == 0 {
 		log.Fatalf("No providers available. Enable at least one provider.")
 	}
 
 	// Cleanup all providers on exit
-	defer func() {
-		for _, cleanup := range cleanupFuncs {
-			if err := cleanup(); err != nil {
-				log.Printf("Error during provider cleanup: %v", err)
-			}
-		}
-	}()
+	defer r.close()
 
-	log.Printf("Starting server with %d provider(s)", len(providerList))
+	log.Printf("Starting server with %d provider(s)", len(r.current.providers))
 
 	// Create server with all providers
-	s := stt.New(*port, providerList...)
+	s := stt.NewWithConfig(*port, withFileConfig(cfg, fileConfig), r.current.providers...)
+	r.server = s
 
 	go func() {
 		if err := s.Start(); err != nil {
@@ -76,36 +171,69 @@ func main() {
 	}()
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
+	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for received := <-sig; received == syscall.SIGHUP; received = <-sig {
+		if r.path == "" {
+			log.Printf("No config file to reload, start with -config to reload on SIGHUP")
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("Failed to reload %s: %v", r.path, err)
+		}
+	}
+
+	// Give live sessions a chance to finish, and clients to move to
+	// another server, before stopping.
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	s.Drain(ctx)
+	cancel()
 
 	if err := s.Stop(); err != nil {
 		log.Printf("Error during server shutdown: %v\n", err)
 	}
 }
 
-func createGoogleProvider() (providers.Provider, func() error, error) {
-	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
-		return nil, nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS environment variable is required for Google provider")
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	ctx := context.Background()
-	speechClient, err := speech.NewClient(ctx)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create Google Speech client: %v", err)
+// parseWeights parses comma-separated provider=weight pairs.
+func parseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range splitList(s) {
+		provider, v, ok := strings.Cut(pair, "=")
+		weight, err := strconv.ParseFloat(v, 64)
+		if !ok || provider == "" || err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight %q: want provider=weight, with a weight above 0", pair)
+		}
+		weights[provider] = weight
 	}
+	return weights, nil
+}
 
-	provider := google.NewProvider(speechClient)
+// replaceFlags collects the rules of a repeated -replace flag.
+type replaceFlags []stt.ReplaceRule
 
-	return provider, speechClient.Close, nil
+func (f *replaceFlags) String() string {
+	rules := make([]string, 0, len(*f))
+	for _, r := range *f {
+		rules = append(rules, r.Find.String()+"=>"+r.Replace)
+	}
+	return strings.Join(rules, ", ")
 }
 
-func createDeepgramProvider() (providers.Provider, func() error, error) {
-	apiKey := os.Getenv("DEEPGRAM_API_KEY")
-	if apiKey == "" {
-		return nil, nil, fmt.Errorf("DEEPGRAM_API_KEY environment variable is required for Deepgram provider")
+func (f *replaceFlags) Set(v string) error {
+	rule, err := stt.ParseReplaceRule(v)
+	if err != nil {
+		return err
 	}
-
-	provider := deepgram.NewProvider(apiKey)
-	return provider, nil, nil // No cleanup needed for Deepgram
+	*f = append(*f, rule)
+	return nil
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	stt "github.com/agnivade/stt_challenge"
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// providerSet is the providers of a config file, and what releases them.
+type providerSet struct {
+	providers []providers.Provider
+	closers   []io.Closer
+}
+
+// newProviderSet creates the enabled providers of a config file, highest
+// priority first. Providers failing to be created are left out.
+func newProviderSet(fileConfig stt.FileConfig) providerSet {
+	var set providerSet
+	for _, settings := range fileConfig.EnabledProviders() {
+		provider, closer, err := providers.New(context.Background(), settings)
+		if err != nil {
+			log.Printf("Failed to create provider: %v", err)
+			continue
+		}
+		set.providers = append(set.providers, provider)
+		if closer != nil {
+			set.closers = append(set.closers, closer)
+		}
+	}
+	return set
+}
+
+func (set providerSet) close() {
+	for _, closer := range set.closers {
+		if err := closer.Close(); err != nil {
+			log.Printf("Error during provider cleanup: %v", err)
+		}
+	}
+}
+
+// withFileConfig returns the configuration with what the config file sets.
+func withFileConfig(cfg stt.Config, fileConfig stt.FileConfig) stt.Config {
+	cfg.Models = fileConfig.ModelConfig()
+	cfg.Pricing = fileConfig.Pricing()
+	if fileConfig.Selector != nil {
+		cfg.Selector = *fileConfig.Selector
+	}
+	if fileConfig.AdminToken != "" {
+		cfg.AdminToken = fileConfig.AdminToken
+	}
+	return cfg
+}
+
+// reloader reloads the config file into the server, on SIGHUP or through
+// the admin API. The providers of the previous config file are closed once
+// the connections using them have finished.
+type reloader struct {
+	path   string
+	cfg    stt.Config // the configuration the flags make up
+	server *stt.Server
+
+	mu      sync.Mutex
+	current providerSet
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fileConfig, err := stt.LoadFileConfig(r.path)
+	if err != nil {
+		return err
+	}
+	set := newProviderSet(fileConfig)
+	if len(set.providers) == 0 {
+		set.close()
+		return errors.New("no providers available, keeping the current ones")
+	}
+
+	done := r.server.Reload(withFileConfig(r.cfg, fileConfig), set.providers...)
+	previous := r.current
+	r.current = set
+	go func() {
+		<-done
+		previous.close()
+	}()
+
+	log.Printf("Reloaded %s with %d provider(s)", r.path, len(set.providers))
+	return nil
+}
+
+// close closes the current providers, when the server has stopped.
+func (r *reloader) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.close()
+}
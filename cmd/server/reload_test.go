@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	stt "github.com/agnivade/stt_challenge"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	t.Setenv("DEEPGRAM_API_KEY", "test-key")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "providers:\n  - type: deepgram\n  - type: deepgram\n    name: deepgram-eu\n    endpoint: api.eu.deepgram.com\n"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reloader{path: path, cfg: stt.DefaultConfig(), server: stt.New("0")}
+	defer r.close()
+
+	// Every reload creates the Deepgram instances again
+	for i := range 2 {
+		if err := r.reload(); err != nil {
+			t.Fatalf("Reload %d failed: %v", i+1, err)
+		}
+		if n := len(r.current.providers); n != 2 {
+			t.Errorf("Expected 2 providers after reload %d, got %d", i+1, n)
+		}
+	}
+}
+
+func TestWithFileConfig_AdminToken(t *testing.T) {
+	cfg := stt.DefaultConfig()
+	cfg.AdminToken = "from-env"
+
+	// The config file replaces the token, or leaves it alone
+	if got := withFileConfig(cfg, stt.FileConfig{AdminToken: "from-file"}).AdminToken; got != "from-file" {
+		t.Errorf("Expected the token of the config file, got %q", got)
+	}
+	if got := withFileConfig(cfg, stt.FileConfig{}).AdminToken; got != "from-env" {
+		t.Errorf("Expected the token to be kept, got %q", got)
+	}
+
+	// A reload turns the admin API on
+	cfg.AdminToken = ""
+	if got := withFileConfig(cfg, stt.FileConfig{AdminToken: "from-file"}).AdminToken; got != "from-file" {
+		t.Errorf("Expected the token of the config file, got %q", got)
+	}
+}
@@ -0,0 +1,181 @@
+package stt_challenge
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// defaultFusionWindow is how long fusion waits for all providers by default.
+const defaultFusionWindow = 1500 * time.Millisecond
+
+// defaultDrainFlushTimeout is how long draining sessions get to send their
+// last results by default.
+const defaultDrainFlushTimeout = 2 * time.Second
+
+// Config holds the settings applied to every new connection of a Server.
+type Config struct {
+	// VAD configures silence gating in front of the providers.
+	VAD VADConfig
+
+	// Selector configures how the results of the providers are picked.
+	Selector SelectorConfig
+
+	// Models configures the models the providers transcribe with.
+	Models ModelConfig
+
+	// Pricing is what the providers cost, keyed by provider name, to log
+	// what every session cost. Providers without one are left out.
+	Pricing map[string]providers.Pricing
+
+	// Formatting configures how the results of every session are
+	// formatted, whichever provider transcribed them.
+	Formatting FormattingConfig
+
+	// Stages are custom result stages, run on every result after the
+	// formatting, and before redaction.
+	Stages []ResultStage
+
+	// Redaction configures masking of profanity and personal data in the
+	// results of every session.
+	Redaction RedactionConfig
+
+	// Vocabularies are the named vocabularies clients can refer to when
+	// connecting. Nil starts with none, kept in memory only.
+	Vocabularies *VocabularyStore
+
+	// AdminToken turns on the admin API under /admin/, for requests that
+	// carry it as a bearer token. Empty leaves the admin API off, until a
+	// reload sets it, like from the admin_token of a config file.
+	AdminToken string
+
+	// ResumeTimeout is how long the session of a connection that dropped
+	// is kept, for the client to resume it without missing any result.
+	// Zero turns resuming off.
+	ResumeTimeout time.Duration
+
+	// DrainFlushTimeout is how long the sessions Server.Drain closes get
+	// to send the results of the audio they already have. Zero means 2
+	// seconds.
+	DrainFlushTimeout time.Duration
+
+	// PingInterval is how often every connection is pinged. Connections
+	// answering no ping for two intervals are taken as dropped, rather
+	// than keeping their provider sessions alive forever. Zero means 20
+	// seconds.
+	PingInterval time.Duration
+
+	// IdleTimeout is how long a client may send no audio before its
+	// session is ended, to stop paying the providers for it. Clients that
+	// only send audio while someone talks, like in push-to-talk, need a
+	// long one. Zero turns it off.
+	IdleTimeout time.Duration
+
+	// Reloader reloads the configuration, usually by calling
+	// Server.Reload, when asked to through the admin API. Nil leaves
+	// reloading through the admin API off.
+	Reloader func() error
+}
+
+// ModelConfig holds the models of every provider, keyed by provider name.
+type ModelConfig struct {
+	// Defaults are the models used when the client asks for none.
+	// Providers without one use their own default.
+	Defaults map[string]string
+
+	// Allowed are the models clients may ask for, besides the default.
+	// Clients can't pick the model of providers without any.
+	Allowed map[string][]string
+}
+
+// SelectionStrategy is how a ProviderSelector turns the results of all its
+// providers into the results it returns.
+type SelectionStrategy string
+
+const (
+	// StrategyLatency returns the results of the provider that answered
+	// most recently, switching providers as their latency changes.
+	StrategyLatency SelectionStrategy = "latency"
+
+	// StrategyFusion waits for the results of all providers and fuses them
+	// word by word. It is slower, by up to the fusion window, but more
+	// accurate than any single provider.
+	StrategyFusion SelectionStrategy = "fusion"
+)
+
+// ParseSelectionStrategy parses the name of a strategy.
+func ParseSelectionStrategy(s string) (SelectionStrategy, error) {
+	switch strategy := SelectionStrategy(s); strategy {
+	case StrategyLatency, StrategyFusion:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown strategy %q: want latency or fusion", s)
+}
+
+// SelectorConfig configures a ProviderSelector.
+type SelectorConfig struct {
+	// Strategy is how results are picked. Empty means StrategyLatency.
+	Strategy SelectionStrategy `yaml:"strategy" json:"strategy"`
+
+	// FusionWindow is how long fusion waits for the other providers after
+	// the first result of an utterance. Zero means 1.5 seconds.
+	FusionWindow time.Duration `yaml:"fusion_window" json:"fusion_window"`
+
+	// ProviderWeights are how much the votes of every provider count in
+	// fusion, keyed by provider name, for providers known to be more
+	// reliable than others. Providers without one weigh 1.
+	ProviderWeights map[string]float64 `yaml:"provider_weights" json:"provider_weights"`
+
+	// Backpressure configures what happens when a provider or the client
+	// can't keep up.
+	Backpressure BackpressureConfig `yaml:"backpressure" json:"backpressure"`
+}
+
+func (c SelectorConfig) effectiveFusionWindow() time.Duration {
+	if c.FusionWindow <= 0 {
+		return defaultFusionWindow
+	}
+	return c.FusionWindow
+}
+
+func (c Config) effectiveDrainFlushTimeout() time.Duration {
+	if c.DrainFlushTimeout <= 0 {
+		return defaultDrainFlushTimeout
+	}
+	return c.DrainFlushTimeout
+}
+
+func (c Config) effectivePingInterval() time.Duration {
+	if c.PingInterval <= 0 {
+		return defaultPingInterval
+	}
+	return c.PingInterval
+}
+
+// DefaultConfig returns the configuration used by New.
+func DefaultConfig() Config {
+	return Config{
+		VAD: DefaultVADConfig(),
+	}
+}
+
+// resolve returns the models of a session, with the models the client
+// asked for in place of the defaults. It fails for models not allowed.
+func (c ModelConfig) resolve(requested map[string]string) (map[string]string, error) {
+	models := maps.Clone(c.Defaults)
+	// Check in a fixed order, so that the error is the same every time.
+	for _, provider := range slices.Sorted(maps.Keys(requested)) {
+		model := requested[provider]
+		if model != c.Defaults[provider] && !slices.Contains(c.Allowed[provider], model) {
+			return nil, fmt.Errorf("model %q is not allowed for %s", model, provider)
+		}
+		if models == nil {
+			models = make(map[string]string)
+		}
+		models[provider] = model
+	}
+	return models, nil
+}
@@ -0,0 +1,55 @@
+package stt_challenge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelConfig_Resolve(t *testing.T) {
+	cfg := ModelConfig{
+		Defaults: map[string]string{"google": "latest_long"},
+		Allowed: map[string][]string{
+			"google":   {"phone_call"},
+			"deepgram": {"nova-3-medical"},
+		},
+	}
+
+	// The defaults without a request
+	models, err := cfg.resolve(nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"google": "latest_long"}, models)
+
+	models, err = cfg.resolve(map[string]string{"google": "phone_call", "deepgram": "nova-3-medical"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"google": "phone_call", "deepgram": "nova-3-medical"}, models)
+	// The defaults are left alone
+	assert.Equal(t, "latest_long", cfg.Defaults["google"])
+
+	// Asking for the default is always allowed
+	_, err = cfg.resolve(map[string]string{"google": "latest_long"})
+	assert.NoError(t, err)
+
+	_, err = cfg.resolve(map[string]string{"google": "video"})
+	assert.EqualError(t, err, `model "video" is not allowed for google`)
+
+	// Nothing is allowed without an allowlist
+	_, err = ModelConfig{}.resolve(map[string]string{"deepgram": "nova-2"})
+	assert.EqualError(t, err, `model "nova-2" is not allowed for deepgram`)
+}
+
+func TestParseSelectionStrategy(t *testing.T) {
+	strategy, err := ParseSelectionStrategy("fusion")
+	require.NoError(t, err)
+	assert.Equal(t, StrategyFusion, strategy)
+
+	_, err = ParseSelectionStrategy("")
+	assert.EqualError(t, err, `unknown strategy "": want latency or fusion`)
+}
+
+func TestSelectorConfig_FusionWindow(t *testing.T) {
+	assert.Equal(t, defaultFusionWindow, SelectorConfig{}.effectiveFusionWindow())
+	assert.Equal(t, time.Second, SelectorConfig{FusionWindow: time.Second}.effectiveFusionWindow())
+}
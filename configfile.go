@@ -0,0 +1,159 @@
+package stt_challenge
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// instanceName is what the names of provider instances must look like.
+// Clients pick models as "name:model", so names can't have a colon.
+var instanceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// FileConfig is the part of the server configuration kept in a file, in
+// YAML or JSON:
+//
+//	providers:
+//	  - name: google-eu
+//	    type: google
+//	    region: eu
+//	    credentials: {file: /etc/stt/google-eu.json}
+//	    model: latest_long
+//	    priority: 10
+//	    pricing: {per_minute: 0.016}
+//	  - type: deepgram
+//	    credentials: {env: DEEPGRAM_API_KEY}
+//	    allowed_models: [nova-3-medical]
+//	selector:
+//	  strategy: fusion
+//	  fusion_window: 2s
+//	  provider_weights: {google-eu: 1.5}
+//	  backpressure: {slow_provider: disconnect}
+type FileConfig struct {
+	// Providers are the provider instances to create, enabled or not.
+	Providers []providers.Settings `yaml:"providers" json:"providers"`
+
+	// Selector is how the results of the providers are picked. Nil
+	// leaves it to the server.
+	Selector *SelectorConfig `yaml:"selector" json:"selector"`
+
+	// AdminToken replaces the token guarding the admin API, so that a
+	// reload can turn it on, or rotate the token. Empty leaves it to the
+	// server.
+	AdminToken string `yaml:"admin_token" json:"admin_token"`
+}
+
+// LoadFileConfig reads and validates a config file. JSON being YAML, both
+// are read the same way. Unknown fields are errors, so that typos don't go
+// unnoticed.
+func LoadFileConfig(path string) (FileConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileConfig{}, err
+	}
+	defer f.Close()
+
+	var c FileConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return FileConfig{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return FileConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks that the config is well-formed. Whether the types of
+// provider exist is left to the registry.
+func (c FileConfig) Validate() error {
+	names := make(map[string]bool, len(c.Providers))
+	for _, p := range c.Providers {
+		if p.Type == "" {
+			return errors.New("provider with no type")
+		}
+		name := p.InstanceName()
+		if !instanceName.MatchString(name) {
+			return fmt.Errorf("invalid provider name %q", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate provider %q, instances of the same type need a name each", name)
+		}
+		names[name] = true
+		if p.Pricing.PerMinute < 0 {
+			return fmt.Errorf("provider %q has a negative price", name)
+		}
+	}
+
+	if s := c.Selector; s != nil {
+		if s.Strategy != "" {
+			if _, err := ParseSelectionStrategy(string(s.Strategy)); err != nil {
+				return err
+			}
+		}
+		if s.FusionWindow < 0 {
+			return errors.New("negative fusion window")
+		}
+		for name, weight := range s.ProviderWeights {
+			if weight <= 0 {
+				return fmt.Errorf("provider %q has a weight of %v, want above 0", name, weight)
+			}
+		}
+		if err := s.Backpressure.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnabledProviders returns the settings of the enabled provider instances,
+// highest priority first. Instances of the same priority keep their order.
+func (c FileConfig) EnabledProviders() []providers.Settings {
+	var enabled []providers.Settings
+	for _, p := range c.Providers {
+		if p.IsEnabled() {
+			enabled = append(enabled, p)
+		}
+	}
+	slices.SortStableFunc(enabled, func(a, b providers.Settings) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	return enabled
+}
+
+// ModelConfig returns the models of the enabled provider instances.
+func (c FileConfig) ModelConfig() ModelConfig {
+	models := ModelConfig{
+		Defaults: make(map[string]string),
+		Allowed:  make(map[string][]string),
+	}
+	for _, p := range c.EnabledProviders() {
+		if p.Model != "" {
+			models.Defaults[p.InstanceName()] = p.Model
+		}
+		if len(p.AllowedModels) > 0 {
+			models.Allowed[p.InstanceName()] = p.AllowedModels
+		}
+	}
+	return models
+}
+
+// Pricing returns the pricing of the enabled provider instances that have
+// one, keyed by name.
+func (c FileConfig) Pricing() map[string]providers.Pricing {
+	pricing := make(map[string]providers.Pricing)
+	for _, p := range c.EnabledProviders() {
+		if p.Pricing.PerMinute > 0 {
+			pricing[p.InstanceName()] = p.Pricing
+		}
+	}
+	return pricing
+}
@@ -0,0 +1,144 @@
+package stt_challenge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// writeConfig writes a config file into a temporary directory.
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadFileConfig_YAML(t *testing.T) {
+	path := writeConfig(t, "stt.yaml", `
+providers:
+  - name: google-eu
+    type: google
+    region: eu
+    credentials: {file: /etc/stt/google-eu.json}
+    model: latest_long
+    allowed_models: [phone_call]
+    priority: 10
+    pricing: {per_minute: 0.016}
+  - type: deepgram
+    credentials: {env: DG_KEY}
+    pricing: {per_minute: 0.0043}
+    priority: 20
+  - name: google-us
+    type: google
+    enabled: false
+selector:
+  strategy: fusion
+  fusion_window: 2s
+  provider_weights: {google-eu: 1.5}
+admin_token: secret
+`)
+
+	cfg, err := LoadFileConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers, 3)
+	assert.Equal(t, providers.Credentials{File: "/etc/stt/google-eu.json"}, cfg.Providers[0].Credentials)
+
+	// Highest priority first, disabled ones left out
+	enabled := cfg.EnabledProviders()
+	require.Len(t, enabled, 2)
+	assert.Equal(t, "deepgram", enabled[0].InstanceName())
+	assert.Equal(t, "google-eu", enabled[1].InstanceName())
+
+	assert.Equal(t, ModelConfig{
+		Defaults: map[string]string{"google-eu": "latest_long"},
+		Allowed:  map[string][]string{"google-eu": {"phone_call"}},
+	}, cfg.ModelConfig())
+	assert.Equal(t, map[string]providers.Pricing{
+		"google-eu": {PerMinute: 0.016},
+		"deepgram":  {PerMinute: 0.0043},
+	}, cfg.Pricing())
+	assert.Equal(t, &SelectorConfig{
+		Strategy:        StrategyFusion,
+		FusionWindow:    2 * time.Second,
+		ProviderWeights: map[string]float64{"google-eu": 1.5},
+	}, cfg.Selector)
+	assert.Equal(t, "secret", cfg.AdminToken)
+}
+
+func TestLoadFileConfig_JSON(t *testing.T) {
+	path := writeConfig(t, "stt.json", `{"providers": [{"type": "deepgram", "model": "nova-3-medical"}]}`)
+
+	cfg, err := LoadFileConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []providers.Settings{{Type: "deepgram", Model: "nova-3-medical"}}, cfg.Providers)
+}
+
+func TestLoadFileConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "unknown field",
+			content: "providers:\n  - type: google\n    regoin: eu\n",
+			err:     "field regoin not found",
+		},
+		{
+			name:    "no type",
+			content: "providers:\n  - name: google\n",
+			err:     "provider with no type",
+		},
+		{
+			name:    "two instances of a type without names",
+			content: "providers:\n  - type: google\n  - type: google\n    region: eu\n",
+			err:     `duplicate provider "google"`,
+		},
+		{
+			name:    "name with a colon",
+			content: "providers:\n  - name: google:eu\n    type: google\n",
+			err:     `invalid provider name "google:eu"`,
+		},
+		{
+			name:    "negative price",
+			content: "providers:\n  - type: google\n    pricing: {per_minute: -1}\n",
+			err:     `provider "google" has a negative price`,
+		},
+		{
+			name:    "unknown strategy",
+			content: "selector:\n  strategy: fastest\n",
+			err:     `unknown strategy "fastest"`,
+		},
+		{
+			name:    "zero weight",
+			content: "selector:\n  provider_weights: {google: 0}\n",
+			err:     `provider "google" has a weight of 0`,
+		},
+		{
+			name:    "unknown overflow policy",
+			content: "selector:\n  backpressure: {slow_client: block}\n",
+			err:     `unknown overflow policy "block"`,
+		},
+		{
+			name:    "negative queue size",
+			content: "selector:\n  backpressure: {audio_queue_size: -1}\n",
+			err:     "negative queue size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFileConfig(writeConfig(t, "stt.yaml", tt.content))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	_, err := LoadFileConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,130 @@
+package stt_challenge
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Drain winds the server down ahead of Stop, without cutting anyone off
+// mid-sentence. It stops accepting connections, reports the server as not
+// ready on /readyz, and tells every client to reconnect with a
+// ResponseDraining response. Sessions then have until ctx is done to end on
+// their own. Past that, the server stops reading their audio, finishes
+// their sessions, gives them the configured flush timeout to send the
+// results they still have, and closes them cleanly. Drain returns once
+// every connection is closed.
+func (s *Server) Drain(ctx context.Context) {
+	s.mu.Lock()
+	s.draining = true
+	conns := slices.Collect(maps.Keys(s.conns))
+	done := s.drained
+	if done == nil {
+		done = make(chan struct{})
+		if len(s.conns) == 0 {
+			close(done)
+		} else {
+			s.drained = done
+		}
+	}
+	s.mu.Unlock()
+
+	s.log.Printf("Draining %d connection(s)...\n", len(conns))
+	for _, wc := range conns {
+		// A client that doesn't read holds its notification up to the
+		// write timeout, which the others don't wait for.
+		go wc.notifyDraining()
+	}
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	conns = slices.Collect(maps.Keys(s.conns))
+	s.mu.Unlock()
+	s.log.Printf("Drain deadline reached, closing %d connection(s)...\n", len(conns))
+	for _, wc := range conns {
+		wc.stopReading()
+	}
+	<-done
+}
+
+func (s *Server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// handleReady reports whether the server takes new connections, for load
+// balancers to stop sending any once it is draining.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.isDraining() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// writeJSON writes a response. It is safe to call from any goroutine.
+func (wc *WebConn) writeJSON(v any) error {
+	conn := wc.currentConn()
+	if conn == nil {
+		return errConnDown
+	}
+	wc.writeMu.Lock()
+	defer wc.writeMu.Unlock()
+	return wc.write(conn, v)
+}
+
+// notifyDraining tells the client that the server is draining.
+func (wc *WebConn) notifyDraining() {
+	err := wc.writeJSON(WebSocketResponse{
+		Type:    ResponseDraining,
+		Message: "server draining, reconnect",
+	})
+	if errors.Is(err, errConnDown) {
+		// The client can't resume a session on a draining server.
+		wc.quit()
+		return
+	}
+	if err != nil {
+		wc.log.Printf("Failed to notify the client of draining: %v\n", err)
+	}
+}
+
+// stopReading makes the reader exit, so that the connection closes once
+// its session has sent what it still has.
+func (wc *WebConn) stopReading() {
+	wc.draining.Store(true)
+	wc.interrupt()
+}
+
+// interrupt makes the reader exit, without waiting for the client to resume
+// the session. It never waits for a write.
+func (wc *WebConn) interrupt() {
+	wc.quit()
+	if conn := wc.currentConn(); conn != nil {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// flush waits for the finished session to send the results of the audio it
+// already has, until the writer exits or the flush timeout. Past that, a
+// write stalled on a client that doesn't read is given up on.
+func (wc *WebConn) flush() {
+	select {
+	case <-wc.writerDone:
+	case <-time.After(wc.flushTimeout):
+		if conn := wc.currentConn(); conn != nil {
+			// Unlike the write deadline of the Conn, this one holds for
+			// the write going on.
+			conn.UnderlyingConn().SetWriteDeadline(time.Now())
+		}
+	}
+}
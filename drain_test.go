@@ -0,0 +1,182 @@
+package stt_challenge
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// newDrainTestProvider returns a provider whose sessions transcribe a
+// single result, and then nothing until they are closed.
+func newDrainTestProvider(t *testing.T) *mocks.MockProvider {
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	var sessionCtx context.Context
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).
+		RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+			sessionCtx = ctx
+			return mockSession, nil
+		}).Once()
+
+	mockSession.EXPECT().ReceiveTranscription().Return(
+		providers.TranscriptionResult{
+			Text:         "hello",
+			IsFinal:      true,
+			ProviderName: "mock-provider",
+			ReceivedAt:   time.Now(),
+		}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		<-sessionCtx.Done()
+		return providers.TranscriptionResult{}, io.EOF
+	}).Once()
+	// Sessions outliving the drain deadline are finished, to flush them.
+	mockSession.EXPECT().Finish().Return(nil).Maybe()
+	mockSession.EXPECT().Close().Return(nil)
+	return mockProvider
+}
+
+func newDrainTestServer(t *testing.T, provider providers.Provider) (*Server, string) {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.DrainFlushTimeout = 100 * time.Millisecond
+	server := NewWithConfig("8081", cfg, provider)
+	server.log = log.New(io.Discard, "", 0)
+
+	testServer := httptest.NewServer(server.srv.Handler)
+	t.Cleanup(testServer.Close)
+	return server, testServer.URL
+}
+
+func TestServer_Drain(t *testing.T) {
+	server, url := newDrainTestServer(t, newDrainTestProvider(t))
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/ws"
+
+	resp, err := http.Get(url + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "hello", response.Sentence)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		server.Drain(ctx)
+		close(drained)
+	}()
+
+	// The client is told to reconnect
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, ResponseDraining, response.Type)
+	assert.NotEmpty(t, response.Message)
+
+	// No new connections are taken
+	resp, err = http.Get(url + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// The session outliving the deadline is closed cleanly
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "want a going away close, got %v", err)
+
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain should return once every connection is closed")
+	}
+}
+
+func TestServer_DrainClientLeaves(t *testing.T) {
+	server, url := newDrainTestServer(t, newDrainTestProvider(t))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+
+	drained := make(chan struct{})
+	go func() {
+		server.Drain(context.Background())
+		close(drained)
+	}()
+
+	// The client reconnecting elsewhere ends the drain, with no deadline
+	require.NoError(t, conn.ReadJSON(&response))
+	require.Equal(t, ResponseDraining, response.Type)
+	conn.Close()
+
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain should return once the client has left")
+	}
+}
+
+func TestServer_DrainStalledClient(t *testing.T) {
+	mockProvider, closed := newFloodTestProvider(t)
+	server, url := newDrainTestServer(t, mockProvider)
+
+	// The client never reads, so the writes of the server stall, long
+	// before they time out
+	conn, _, err := stalledDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		server.Drain(ctx)
+		close(drained)
+	}()
+
+	// The server isn't held up meanwhile
+	assert.Equal(t, 1, server.Metrics().Connections)
+
+	// Past the deadline and the flush timeout, the stalled write is given up
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain should return once the flush timeout is over")
+	}
+	waitClosed(t, closed)
+	assert.Zero(t, server.Metrics().Queues.ClientsDisconnected)
+}
+
+func TestServer_DrainWithoutConnections(t *testing.T) {
+	server := New("8081")
+	server.log = log.New(io.Discard, "", 0)
+
+	server.Drain(context.Background())
+	// Draining again is fine
+	server.Drain(context.Background())
+	assert.True(t, server.isDraining())
+}
@@ -0,0 +1,54 @@
+package stt_challenge
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// finish tells the session that no more audio is coming, for it to send the
+// results of the audio it has, and end. It is only called by the reader,
+// or once it has exited.
+func (wc *WebConn) finish() {
+	if wc.finished {
+		return
+	}
+	wc.finished = true
+	if err := wc.session.Finish(); err != nil {
+		wc.log.Printf("session.Finish error: %v\n", err)
+	}
+}
+
+// endTranscript tells the client that every result of the session has been
+// sent, and closes the connection. A client away at the time is told once
+// it resumes the session.
+func (wc *WebConn) endTranscript() {
+	wc.mu.Lock()
+	wc.ended = true
+	conn := wc.conn
+	wc.mu.Unlock()
+
+	if conn != nil {
+		wc.writeMu.Lock()
+		defer wc.writeMu.Unlock()
+		wc.sendEndOfTranscript(conn)
+	}
+}
+
+// sendEndOfTranscript sends the ResponseEndOfTranscript response, and a
+// normal closure. It must be called with writeMu held.
+func (wc *WebConn) sendEndOfTranscript(conn *websocket.Conn) {
+	if err := wc.write(conn, WebSocketResponse{Type: ResponseEndOfTranscript}); err != nil {
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end of transcript"),
+		time.Now().Add(time.Second))
+}
+
+// hasEnded reports whether the writer has sent every result of the session.
+func (wc *WebConn) hasEnded() bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.ended
+}
@@ -0,0 +1,125 @@
+package stt_challenge
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// newFinishTestServer returns a server whose session only transcribes the
+// last words once it is finished.
+func newFinishTestServer(t *testing.T, resumeTimeout time.Duration) (*Server, string, chan struct{}) {
+	t.Helper()
+
+	mockProvider, closed := newFinishTestProvider(t)
+	cfg := DefaultConfig()
+	cfg.ResumeTimeout = resumeTimeout
+	server, wsURL := newConfigTestServer(t, cfg, mockProvider)
+	return server, wsURL, closed
+}
+
+// newFinishTestProvider returns a provider whose session only transcribes
+// the last words once it is finished, and the channel closed with it.
+func newFinishTestProvider(t *testing.T) (*mocks.MockProvider, chan struct{}) {
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+	finished := make(chan struct{})
+	closed := make(chan struct{})
+
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).Return(mockSession, nil).Once()
+	mockSession.EXPECT().SendAudio(mock.Anything).Return(nil).Maybe()
+	mockSession.EXPECT().Finish().RunAndReturn(func() error {
+		close(finished)
+		return nil
+	}).Once()
+	mockSession.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		<-finished
+		return providers.TranscriptionResult{
+			Text:         "last words",
+			IsFinal:      true,
+			ProviderName: "mock-provider",
+			ReceivedAt:   time.Now(),
+		}, nil
+	}).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().RunAndReturn(func() error {
+		close(closed)
+		return nil
+	}).Once()
+	return mockProvider, closed
+}
+
+func newConfigTestServer(t *testing.T, cfg Config, provider providers.Provider) (*Server, string) {
+	t.Helper()
+
+	server := NewWithConfig("8081", cfg, provider)
+	server.log = log.New(io.Discard, "", 0)
+
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	t.Cleanup(testServer.Close)
+	return server, "ws" + strings.TrimPrefix(testServer.URL, "http")
+}
+
+// readEndOfTranscript reads the last words, the end of the transcript, and
+// the normal closure after it.
+func readEndOfTranscript(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "last words", response.Sentence)
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, ResponseEndOfTranscript, response.Type)
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "want a normal closure, got %v", err)
+}
+
+func TestWebSocketFinish(t *testing.T) {
+	_, wsURL, closed := newFinishTestServer(t, 0)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteJSON(WebSocketRequest{Buf: []byte("audio")}))
+	require.NoError(t, conn.WriteJSON(WebSocketRequest{Type: RequestFinish}))
+	// Audio after finishing is ignored
+	require.NoError(t, conn.WriteJSON(WebSocketRequest{Buf: []byte("audio")}))
+	readEndOfTranscript(t, conn)
+	waitClosed(t, closed)
+}
+
+func TestWebSocketFinishResumed(t *testing.T) {
+	_, wsURL, closed := newFinishTestServer(t, 5*time.Second)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	token := readSession(t, conn)
+
+	// The connection drops right after finishing
+	require.NoError(t, conn.WriteJSON(WebSocketRequest{Type: RequestFinish}))
+	conn.UnderlyingConn().Close()
+
+	// The end of the transcript waits for the client to resume the session
+	resumed, _, err := websocket.DefaultDialer.Dial(wsURL+"?resume="+token+"&last=0", nil)
+	require.NoError(t, err)
+	defer resumed.Close()
+	assert.Equal(t, token, readSession(t, resumed))
+	readEndOfTranscript(t, resumed)
+	waitClosed(t, closed)
+}
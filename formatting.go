@@ -0,0 +1,138 @@
+package stt_challenge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// FormattingConfig configures how transcripts are formatted, so that they
+// look the same whichever provider transcribed them.
+type FormattingConfig struct {
+	// Normalize gives every transcript sentence casing and a final
+	// punctuation mark, and tidies up spaces around punctuation.
+	Normalize bool
+
+	// InverseTextNormalization writes spoken numbers, amounts and
+	// percentages as digits and symbols, like "$25" for "twenty five dollars".
+	InverseTextNormalization bool
+
+	// Replacements are find and replace rules applied to every transcript,
+	// one after the other.
+	Replacements []ReplaceRule
+}
+
+// stages returns the pipeline stages doing the formatting. Numbers are
+// written as digits first, so that rules and casing see the final text.
+func (c FormattingConfig) stages() []ResultStage {
+	var stages []ResultStage
+	if c.InverseTextNormalization {
+		stages = append(stages, spanStage{find: findSpokenNumbers})
+	}
+	for _, rule := range c.Replacements {
+		stages = append(stages, spanStage{find: rule.find})
+	}
+	if c.Normalize {
+		stages = append(stages, ResultStageFunc(normalize))
+	}
+	return stages
+}
+
+// ReplaceRule replaces every match of a regular expression.
+type ReplaceRule struct {
+	Find *regexp.Regexp
+	// Replace is the replacement, in which $1 or ${name} stand for
+	// the groups of the match, as in regexp.Regexp.Expand.
+	Replace string
+}
+
+// ParseReplaceRule parses a rule written as "pattern=>replacement",
+// like `(?i)\bacme cloud\b=>Acme Cloud`.
+func ParseReplaceRule(s string) (ReplaceRule, error) {
+	pattern, replace, ok := strings.Cut(s, "=>")
+	if !ok || pattern == "" {
+		return ReplaceRule{}, fmt.Errorf("invalid rule %q: want pattern=>replacement", s)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ReplaceRule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+	}
+	return ReplaceRule{Find: re, Replace: replace}, nil
+}
+
+func (r ReplaceRule) find(text string) []span {
+	var spans []span
+	for _, m := range r.Find.FindAllStringSubmatchIndex(text, -1) {
+		// Skip empty matches, they would insert the replacement
+		// between every two characters.
+		if m[0] == m[1] {
+			continue
+		}
+		spans = append(spans, span{
+			start:       m[0],
+			end:         m[1],
+			replacement: string(r.Find.ExpandString(nil, r.Replace, text, m)),
+		})
+	}
+	return spans
+}
+
+// spaceBeforePunctuation matches the spaces some providers leave before
+// punctuation marks.
+var spaceBeforePunctuation = regexp.MustCompile(`\s+([,.?!;:])`)
+
+// sentenceEnd matches the end of a sentence within a transcript, followed
+// by the letter starting the next one.
+var sentenceEnd = regexp.MustCompile(`[.?!]\s+\p{Ll}`)
+
+// normalize gives a result sentence casing and a final punctuation mark,
+// in its text, its words and its alternatives.
+func normalize(result *providers.TranscriptionResult) {
+	result.Text = normalizeText(result.Text)
+	for i, alt := range result.Alternatives {
+		result.Alternatives[i].Text = normalizeText(alt.Text)
+	}
+
+	for i := range result.Words {
+		if i == 0 || strings.ContainsAny(lastRune(result.Words[i-1].Text), ".?!") {
+			result.Words[i].Text = capitalize(result.Words[i].Text)
+		}
+	}
+	if n := len(result.Words); n > 0 {
+		result.Words[n-1].Text = finishSentence(result.Words[n-1].Text)
+	}
+}
+
+func normalizeText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	text = spaceBeforePunctuation.ReplaceAllString(text, "$1")
+	text = sentenceEnd.ReplaceAllStringFunc(text, strings.ToUpper)
+	return finishSentence(capitalize(text))
+}
+
+// capitalize upper-cases the first letter of s, if s starts with one.
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if !unicode.IsLower(r) {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// finishSentence ends s with a full stop, unless it already ends with a
+// question or exclamation mark. Trailing commas and the like are replaced.
+func finishSentence(s string) string {
+	if s == "" || strings.ContainsAny(lastRune(s), ".?!") {
+		return s
+	}
+	return strings.TrimRight(s, ",;:") + "."
+}
+
+func lastRune(s string) string {
+	_, size := utf8.DecodeLastRuneInString(s)
+	return s[len(s)-size:]
+}
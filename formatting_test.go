@@ -0,0 +1,149 @@
+package stt_challenge
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// format runs text through the stages of a formatting config.
+func format(cfg FormattingConfig, text string) string {
+	result := providers.TranscriptionResult{Text: text}
+	for _, stage := range cfg.stages() {
+		stage.Process(&result)
+	}
+	return result.Text
+}
+
+func TestFormatting_Normalize(t *testing.T) {
+	cfg := FormattingConfig{Normalize: true}
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"hello world", "Hello world."},
+		{"Hello world.", "Hello world."},
+		{"is it working?", "Is it working?"},
+		{"yes  it is , thanks.  see you", "Yes it is, thanks. See you."},
+		{"well,", "Well."},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, format(cfg, tt.text), tt.text)
+	}
+}
+
+func TestFormatting_NormalizeWords(t *testing.T) {
+	result := providers.TranscriptionResult{
+		Text:  "hello there. how are you",
+		Words: timedWords("hello", "there.", "how", "are", "you"),
+	}
+	normalize(&result)
+
+	assert.Equal(t, "Hello there. How are you.", result.Text)
+	texts := make([]string, 0, len(result.Words))
+	for _, w := range result.Words {
+		texts = append(texts, w.Text)
+	}
+	assert.Equal(t, []string{"Hello", "there.", "How", "are", "you."}, texts)
+}
+
+func TestFormatting_NormalizeAlternatives(t *testing.T) {
+	result := providers.TranscriptionResult{
+		Text:         "recognize speech",
+		Alternatives: []providers.Alternative{{Text: "wreck a nice beach"}},
+	}
+	normalize(&result)
+
+	assert.Equal(t, "Recognize speech.", result.Text)
+	assert.Equal(t, "Wreck a nice beach.", result.Alternatives[0].Text)
+}
+
+func TestFormatting_InverseTextNormalization(t *testing.T) {
+	cfg := FormattingConfig{InverseTextNormalization: true}
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"it costs twenty five dollars", "it costs $25"},
+		{"that's twenty-five dollars and fifty cents", "that's $25.50"},
+		{"up ten percent", "up 10%"},
+		{"about three point five", "about 3.5"},
+		{"one hundred and five people", "105 people"},
+		{"two thousand three hundred", "2300"},
+		{"forty two thousand", "42,000"},
+		{"three million two hundred thousand", "3,200,000"},
+		{"in nineteen eighty four", "in 1984"},
+		{"call five five five one two three four", "call 5551234"},
+		{"Twenty One", "21"},
+		// Lone small numbers stay words
+		{"one of them has two", "one of them has two"},
+		{"five dollars", "$5"},
+		{"no numbers here", "no numbers here"},
+		// Punctuation breaks a number
+		{"twenty, five", "20, five"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, format(cfg, tt.text), tt.text)
+	}
+}
+
+func TestFormatting_InverseTextNormalizationWords(t *testing.T) {
+	result := providers.TranscriptionResult{
+		Text:  "pay twenty five dollars",
+		Words: timedWords("pay", "twenty", "five", "dollars"),
+	}
+	spanStage{find: findSpokenNumbers}.Process(&result)
+
+	assert.Equal(t, "pay $25", result.Text)
+	require.Len(t, result.Words, 2)
+	assert.Equal(t, "$25", result.Words[1].Text)
+	assert.Equal(t, 1*time.Second, result.Words[1].Start)
+	assert.Equal(t, 3500*time.Millisecond, result.Words[1].End)
+}
+
+func TestFormatting_Replacements(t *testing.T) {
+	cfg := FormattingConfig{Replacements: []ReplaceRule{
+		{Find: regexp.MustCompile(`(?i)\bacme cloud\b`), Replace: "AcmeCloud"},
+		{Find: regexp.MustCompile(`\b(\w+) dot com\b`), Replace: "$1.com"},
+		{Find: regexp.MustCompile(`x*`), Replace: "-"},
+	}}
+
+	assert.Equal(t, "try AcmeCloud at acme.com", format(cfg, "try Acme Cloud at acme dot com"))
+}
+
+func TestFormatting_Order(t *testing.T) {
+	cfg := FormattingConfig{
+		Normalize:                true,
+		InverseTextNormalization: true,
+		Replacements: []ReplaceRule{
+			{Find: regexp.MustCompile(`\$(\d+)`), Replace: "USD $1"},
+		},
+	}
+
+	assert.Equal(t, "It costs USD 25.", format(cfg, "it costs twenty five dollars"))
+}
+
+func TestParseReplaceRule(t *testing.T) {
+	rule, err := ParseReplaceRule(`(?i)gonna=>going to`)
+	require.NoError(t, err)
+	assert.Equal(t, "(?i)gonna", rule.Find.String())
+	assert.Equal(t, "going to", rule.Replace)
+
+	// The replacement may be empty, to remove matches.
+	rule, err = ParseReplaceRule(`\bum\b=>`)
+	require.NoError(t, err)
+	assert.Empty(t, rule.Replace)
+
+	for _, s := range []string{"", "no arrow", "=>empty pattern", "(=>unbalanced"} {
+		_, err := ParseReplaceRule(s)
+		assert.Error(t, err, s)
+	}
+}
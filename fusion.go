@@ -0,0 +1,399 @@
+package stt_challenge
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// fusionProviderName is the provider name of fused results.
+const fusionProviderName = "fusion"
+
+// gapConfidence is the confidence of a provider not hearing a word at all,
+// where the others did. It is what makes a word most providers skipped, but
+// one heard with high confidence, still make it into the transcript.
+const gapConfidence = 0.5
+
+// fusionGroup collects the final results of every provider for the same
+// utterance, until they are all in or the window runs out.
+type fusionGroup struct {
+	deadline time.Time
+	results  map[string]providers.TranscriptionResult
+}
+
+// fusionSelector is the selector of the fusion strategy. Instead of picking a
+// provider, it waits for every provider to transcribe an utterance and fuses
+// their transcripts into one, word by word.
+//
+// Providers don't say which utterance a result belongs to, so a group is
+// started by the first final result, and takes the first final result of
+// every other provider within the window. Later results of a provider already
+// in the group are taken as the rest of its transcript, since providers split
+// utterances differently. Much like sendMissedMessages, this is not perfect:
+// a provider lagging by more than the window ends up in the next group.
+func (ps *ProviderSelector) fusionSelector() {
+	defer ps.wg.Done()
+
+	// Channels are transcribed separately, so they are fused separately.
+	groups := make(map[int]*fusionGroup)
+	timer := time.NewTimer(ps.selectorConfig.effectiveFusionWindow())
+	timer.Stop()
+
+	emit := func(channel int) bool {
+		group := groups[channel]
+		delete(groups, channel)
+
+		result := fuseResults(group.results, ps.providerNames, ps.selectorConfig.ProviderWeights)
+		return ps.emit(result)
+	}
+
+	// resetTimer sets the timer off at the earliest deadline of any group.
+	resetTimer := func() {
+		timer.Stop()
+		var next time.Time
+		for _, group := range groups {
+			if next.IsZero() || group.deadline.Before(next) {
+				next = group.deadline
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+
+	add := func(result providers.TranscriptionResult) bool {
+		if !result.IsFinal {
+			return true
+		}
+		ps.speakers.mapResult(&result)
+
+		group, ok := groups[result.Channel]
+		if !ok {
+			group = &fusionGroup{
+				deadline: time.Now().Add(ps.selectorConfig.effectiveFusionWindow()),
+				results:  make(map[string]providers.TranscriptionResult),
+			}
+			groups[result.Channel] = group
+		}
+		if earlier, ok := group.results[result.ProviderName]; ok {
+			result = appendResult(earlier, result)
+		}
+		group.results[result.ProviderName] = result
+
+		if ps.groupComplete(group) {
+			return emit(result.Channel)
+		}
+		return true
+	}
+
+	for {
+		select {
+		case result := <-ps.transcriptionBuffer:
+			if !add(result) {
+				return
+			}
+			resetTimer()
+
+		case <-ps.collected:
+			// No more results are coming, so the groups are fused with
+			// whichever providers they have.
+			if !ps.drainCollected(add) {
+				return
+			}
+			for _, channel := range slices.Sorted(maps.Keys(groups)) {
+				if !emit(channel) {
+					return
+				}
+			}
+			close(ps.finished)
+			return
+
+		case <-ps.streamEnded:
+			// A provider is gone, so groups may be waiting for it only.
+			// Its last results come first.
+			if !ps.drainCollected(add) {
+				return
+			}
+			for _, channel := range slices.Sorted(maps.Keys(groups)) {
+				if ps.groupComplete(groups[channel]) {
+					if !emit(channel) {
+						return
+					}
+				}
+			}
+			resetTimer()
+
+		case <-timer.C:
+			now := time.Now()
+			for _, channel := range slices.Sorted(maps.Keys(groups)) {
+				if !groups[channel].deadline.After(now) {
+					if !emit(channel) {
+						return
+					}
+				}
+			}
+			resetTimer()
+
+		case <-ps.ctx.Done():
+			return
+		}
+	}
+}
+
+// groupComplete reports whether a group has a result of every provider whose
+// stream is still going. A provider disconnected for being slow is finished,
+// and so ends its stream once it returned its last results.
+func (ps *ProviderSelector) groupComplete(group *fusionGroup) bool {
+	for i, name := range ps.providerNames {
+		if _, ok := group.results[name]; !ok && !ps.ended[i].Load() {
+			return false
+		}
+	}
+	return true
+}
+
+// appendResult returns the result of a provider with the next result of the
+// same provider appended to it.
+func appendResult(result, next providers.TranscriptionResult) providers.TranscriptionResult {
+	words := len(strings.Fields(result.Text))
+	nextWords := len(strings.Fields(next.Text))
+	if words+nextWords > 0 {
+		// Weigh the confidence of both by their number of words.
+		result.Confidence = (result.Confidence*float32(words) + next.Confidence*float32(nextWords)) / float32(words+nextWords)
+	}
+	result.Text = strings.TrimSpace(result.Text + " " + next.Text)
+	result.Words = append(slices.Clip(result.Words), next.Words...)
+	result.Speaker = providers.DominantSpeaker(result.Words)
+	result.Alternatives = nil
+	result.ReceivedAt = next.ReceivedAt
+	return result
+}
+
+// hypothesisWord is a word of one provider's transcript.
+type hypothesisWord struct {
+	word     providers.Word
+	key      string // what words are compared by
+	timed    bool
+	provider string
+}
+
+// hypothesisWords returns the words of a result, from its text if the
+// provider returned no words, with the confidence of the whole result.
+func hypothesisWords(result providers.TranscriptionResult) []hypothesisWord {
+	var words []hypothesisWord
+	if len(result.Words) > 0 {
+		for _, w := range result.Words {
+			words = append(words, hypothesisWord{word: w, key: wordKey(w.Text), timed: true, provider: result.ProviderName})
+		}
+		return words
+	}
+	for _, text := range strings.Fields(result.Text) {
+		w := providers.Word{Text: text, Confidence: result.Confidence}
+		words = append(words, hypothesisWord{word: w, key: wordKey(text), provider: result.ProviderName})
+	}
+	return words
+}
+
+// wordKey returns a word without casing and punctuation, since providers
+// agreeing on a word don't always agree on how to write it.
+func wordKey(text string) string {
+	return strings.ToLower(strings.TrimFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// fuseResults fuses the transcripts of the same utterance by several
+// providers, the way ROVER does: the transcripts are aligned word by word
+// into columns, and every column votes on its word. A provider's vote is its
+// weight times the average of one and its confidence in the word, so that
+// both the number of providers hearing a word and how sure they are count.
+// Providers missing from a column vote for no word at all.
+//
+// order is the order providers are aligned in, and the tie breaker.
+func fuseResults(results map[string]providers.TranscriptionResult, order []string, weights map[string]float64) providers.TranscriptionResult {
+	var names []string
+	for _, name := range order {
+		if _, ok := results[name]; ok {
+			names = append(names, name)
+		}
+	}
+	// A single transcript has nothing to vote on.
+	if len(names) == 1 {
+		return results[names[0]]
+	}
+
+	weight := func(provider string) float64 {
+		if w, ok := weights[provider]; ok {
+			return w
+		}
+		return 1
+	}
+	// The heaviest provider's transcript is the base the others are
+	// aligned with.
+	slices.SortStableFunc(names, func(a, b string) int {
+		switch wa, wb := weight(a), weight(b); {
+		case wa > wb:
+			return -1
+		case wa < wb:
+			return 1
+		}
+		return 0
+	})
+
+	var columns [][]hypothesisWord
+	for i, name := range names {
+		columns = alignHypothesis(columns, i, hypothesisWords(results[name]))
+	}
+
+	base := results[names[0]]
+	fused := providers.TranscriptionResult{
+		IsFinal:      true,
+		ProviderName: fusionProviderName,
+		Channel:      base.Channel,
+		LanguageCode: base.LanguageCode,
+		ReceivedAt:   time.Now(),
+	}
+
+	var texts []string
+	var words []providers.Word
+	timed := true
+	var confidence float32
+	for _, column := range columns {
+		best, ok := voteColumn(column, names, weight)
+		if !ok {
+			continue
+		}
+		texts = append(texts, best.word.Text)
+		words = append(words, best.word)
+		timed = timed && best.timed
+		confidence += best.word.Confidence
+	}
+	if len(words) == 0 {
+		return base
+	}
+
+	fused.Text = strings.Join(texts, " ")
+	fused.Confidence = confidence / float32(len(words))
+	// Words are only returned if all of them have timings.
+	if timed {
+		fused.Words = words
+		fused.Speaker = providers.DominantSpeaker(words)
+	}
+	return fused
+}
+
+// Alignment costs. A substitution costs less than a deletion and an insertion,
+// but more than either, so that matching words are aligned where they can be.
+const (
+	gapCost          = 2
+	substitutionCost = 3
+)
+
+// alignHypothesis aligns the words of the n-th hypothesis with the columns
+// of the ones before it, by edit distance, and returns the new columns.
+// Every column has a slot per hypothesis, empty where it has no word.
+func alignHypothesis(columns [][]hypothesisWord, n int, words []hypothesisWord) [][]hypothesisWord {
+	matches := func(column []hypothesisWord, w hypothesisWord) bool {
+		return slices.ContainsFunc(column, func(c hypothesisWord) bool {
+			return c.key != "" && c.key == w.key
+		})
+	}
+
+	// cost[i][j] is the cost of aligning the first i columns with the
+	// first j words.
+	cost := make([][]int, len(columns)+1)
+	for i := range cost {
+		cost[i] = make([]int, len(words)+1)
+		cost[i][0] = i * gapCost
+	}
+	for j := range words {
+		cost[0][j+1] = (j + 1) * gapCost
+	}
+	substitution := func(i, j int) int {
+		if matches(columns[i], words[j]) {
+			return 0
+		}
+		return substitutionCost
+	}
+	for i := range columns {
+		for j := range words {
+			cost[i+1][j+1] = min(cost[i][j]+substitution(i, j), cost[i][j+1]+gapCost, cost[i+1][j]+gapCost)
+		}
+	}
+
+	// Walk back from the end, filling the slot of the new hypothesis.
+	var aligned [][]hypothesisWord
+	i, j := len(columns), len(words)
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && cost[i][j] == cost[i-1][j-1]+substitution(i-1, j-1):
+			aligned = append(aligned, withSlot(columns[i-1], n, words[j-1]))
+			i, j = i-1, j-1
+		case i > 0 && cost[i][j] == cost[i-1][j]+gapCost:
+			aligned = append(aligned, withSlot(columns[i-1], n, hypothesisWord{}))
+			i--
+		default:
+			column := make([]hypothesisWord, n)
+			aligned = append(aligned, append(column, words[j-1]))
+			j--
+		}
+	}
+	slices.Reverse(aligned)
+	return aligned
+}
+
+// withSlot returns the column with w in the slot of the n-th hypothesis.
+func withSlot(column []hypothesisWord, n int, w hypothesisWord) []hypothesisWord {
+	column = append(slices.Clip(column), make([]hypothesisWord, n+1-len(column))...)
+	column[n] = w
+	return column
+}
+
+// voteColumn returns the word of a column with the most votes, and false if
+// no word at all wins. Of the providers voting for the winning word, the one
+// with the most say decides how it is written and timed.
+func voteColumn(column []hypothesisWord, names []string, weight func(string) float64) (hypothesisWord, bool) {
+	votes := make(map[string]float64)
+	var keys []string
+	gap := 0.0
+	for i, name := range names {
+		if i >= len(column) || column[i].provider == "" {
+			gap += weight(name) * (1 + gapConfidence) / 2
+			continue
+		}
+		w := column[i]
+		if _, ok := votes[w.key]; !ok {
+			keys = append(keys, w.key)
+		}
+		votes[w.key] += weight(name) * (1 + float64(w.word.Confidence)) / 2
+	}
+
+	if len(keys) == 0 {
+		return hypothesisWord{}, false
+	}
+	best := keys[0]
+	for _, key := range keys[1:] {
+		if votes[key] > votes[best] {
+			best = key
+		}
+	}
+	if votes[best] <= gap {
+		return hypothesisWord{}, false
+	}
+
+	var winner hypothesisWord
+	say := -1.0
+	for i, name := range names {
+		if i >= len(column) || column[i].provider == "" || column[i].key != best {
+			continue
+		}
+		if s := weight(name) * (1 + float64(column[i].word.Confidence)); s > say {
+			winner, say = column[i], s
+		}
+	}
+	return winner, true
+}
@@ -0,0 +1,269 @@
+package stt_challenge
+
+import (
+	"context"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// hypothesis returns the result of a provider with a word per text, all with
+// the same confidence.
+func hypothesis(provider string, confidence float32, texts ...string) providers.TranscriptionResult {
+	words := timedWords(texts...)
+	for i := range words {
+		words[i].Confidence = confidence
+	}
+	result := providers.TranscriptionResult{ProviderName: provider, IsFinal: true, Confidence: confidence, Words: words}
+	for i, w := range words {
+		if i > 0 {
+			result.Text += " "
+		}
+		result.Text += w.Text
+	}
+	return result
+}
+
+func TestFuseResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []providers.TranscriptionResult
+		weights  map[string]float64
+		expected string
+	}{
+		{
+			name: "majority wins a substitution",
+			results: []providers.TranscriptionResult{
+				hypothesis("a", 0.9, "I", "red", "the", "book"),
+				hypothesis("b", 0.9, "I", "read", "the", "book"),
+				hypothesis("c", 0.8, "I", "read", "a", "book"),
+			},
+			expected: "I read the book",
+		},
+		{
+			name: "confidence breaks a tie",
+			results: []providers.TranscriptionResult{
+				hypothesis("a", 0.6, "I", "red", "it"),
+				hypothesis("b", 0.95, "I", "read", "it"),
+			},
+			expected: "I read it",
+		},
+		{
+			name: "weights outvote a majority",
+			results: []providers.TranscriptionResult{
+				hypothesis("a", 0.9, "call", "Jon"),
+				hypothesis("b", 0.9, "call", "John"),
+				hypothesis("c", 0.9, "call", "John"),
+			},
+			weights:  map[string]float64{"a": 3},
+			expected: "call Jon",
+		},
+		{
+			name: "a word only one of three heard is dropped",
+			results: []providers.TranscriptionResult{
+				hypothesis("a", 0.9, "see", "you", "um", "later"),
+				hypothesis("b", 0.9, "see", "you", "later"),
+				hypothesis("c", 0.9, "see", "you", "later"),
+			},
+			expected: "see you later",
+		},
+		{
+			name: "a word most heard is kept",
+			results: []providers.TranscriptionResult{
+				hypothesis("a", 0.9, "see", "later"),
+				hypothesis("b", 0.9, "see", "you", "later"),
+				hypothesis("c", 0.9, "see", "you", "later"),
+			},
+			expected: "see you later",
+		},
+		{
+			name: "casing and punctuation don't split votes",
+			results: []providers.TranscriptionResult{
+				hypothesis("a", 0.7, "Hello,", "world."),
+				hypothesis("b", 0.9, "hello", "world"),
+			},
+			expected: "hello world",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make(map[string]providers.TranscriptionResult)
+			var order []string
+			for _, r := range tt.results {
+				results[r.ProviderName] = r
+				order = append(order, r.ProviderName)
+			}
+
+			fused := fuseResults(results, order, tt.weights)
+			assert.Equal(t, tt.expected, fused.Text)
+			assert.Equal(t, fusionProviderName, fused.ProviderName)
+			assert.True(t, fused.IsFinal)
+		})
+	}
+}
+
+func TestFuseResults_Words(t *testing.T) {
+	timed := hypothesis("deepgram", 0.9, "pay", "the", "bill")
+	untimed := providers.TranscriptionResult{ProviderName: "google", IsFinal: true, Text: "pay the bill", Confidence: 0.8}
+
+	// Words keep the timing of the provider that won them.
+	fused := fuseResults(map[string]providers.TranscriptionResult{"deepgram": timed, "google": untimed}, []string{"deepgram", "google"}, nil)
+	assert.Equal(t, "pay the bill", fused.Text)
+	assert.Equal(t, timed.Words, fused.Words)
+	assert.InDelta(t, 0.9, fused.Confidence, 0.001)
+
+	// Without timings for every word, there are no words.
+	fused = fuseResults(map[string]providers.TranscriptionResult{"deepgram": timed, "google": untimed}, []string{"deepgram", "google"}, map[string]float64{"google": 2})
+	assert.Equal(t, "pay the bill", fused.Text)
+	assert.Nil(t, fused.Words)
+
+	// A single provider is returned as is.
+	fused = fuseResults(map[string]providers.TranscriptionResult{"google": untimed}, []string{"deepgram", "google"}, nil)
+	assert.Equal(t, untimed, fused)
+}
+
+func TestAlignHypothesis(t *testing.T) {
+	words := func(provider string, texts ...string) []hypothesisWord {
+		return hypothesisWords(hypothesis(provider, 0.9, texts...))
+	}
+	keys := func(columns [][]hypothesisWord) [][]string {
+		var out [][]string
+		for _, column := range columns {
+			var row []string
+			for _, w := range column {
+				row = append(row, w.key)
+			}
+			out = append(out, row)
+		}
+		return out
+	}
+
+	columns := alignHypothesis(nil, 0, words("a", "the", "cat", "sat"))
+	columns = alignHypothesis(columns, 1, words("b", "the", "black", "cat"))
+	assert.Equal(t, [][]string{
+		{"the", "the"},
+		{"", "black"},
+		{"cat", "cat"},
+		{"sat", ""},
+	}, keys(columns))
+}
+
+func TestAppendResult(t *testing.T) {
+	first := hypothesis("a", 0.9, "good", "morning")
+	next := hypothesis("a", 0.6, "everyone")
+
+	appended := appendResult(first, next)
+	assert.Equal(t, "good morning everyone", appended.Text)
+	assert.Len(t, appended.Words, 3)
+	assert.InDelta(t, 0.8, appended.Confidence, 0.001)
+	// The first result is left alone
+	assert.Len(t, first.Words, 2)
+}
+
+func TestProviderSelector_Fusion(t *testing.T) {
+	// newSelector returns a fusion selector over two providers, whose
+	// sessions return the given results, each after a delay. The streams of
+	// the ended providers end after their results, the others stay open.
+	newSelector := func(t *testing.T, window time.Duration, results map[string][]providers.TranscriptionResult, delays map[string]time.Duration, ended ...string) *ProviderSelector {
+		var list []providers.Provider
+		for _, name := range []string{"google", "deepgram"} {
+			provider := mocks.NewMockProvider(t)
+			session := mocks.NewMockSession(t)
+			var sessionCtx context.Context
+			provider.EXPECT().Name().Return(name)
+			provider.EXPECT().NewSession(mock.Anything, mock.Anything).
+				RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+					sessionCtx = ctx
+					return session, nil
+				})
+			for _, r := range results[name] {
+				session.EXPECT().ReceiveTranscription().After(delays[name]).Return(r, nil).Once()
+			}
+			session.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+				if !slices.Contains(ended, name) {
+					<-sessionCtx.Done()
+				}
+				return providers.TranscriptionResult{}, io.EOF
+			}).Maybe()
+			session.EXPECT().Close().Return(nil)
+			list = append(list, provider)
+		}
+
+		ps, err := NewProviderSelector(list, providers.SessionConfig{}, SelectorConfig{Strategy: StrategyFusion, FusionWindow: window}, log.New(&ThreadSafeBuffer{}, "", 0))
+		require.NoError(t, err)
+		t.Cleanup(func() { ps.Close() })
+		return ps
+	}
+
+	t.Run("fuses once every provider is in", func(t *testing.T) {
+		ps := newSelector(t, time.Minute, map[string][]providers.TranscriptionResult{
+			"google":   {hypothesis("google", 0.9, "hello", "word")},
+			"deepgram": {hypothesis("deepgram", 0.95, "hello", "world")},
+		}, nil)
+
+		result, err := ps.ReceiveTranscription()
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", result.Text)
+		assert.Equal(t, fusionProviderName, result.ProviderName)
+	})
+
+	t.Run("stops waiting after the window", func(t *testing.T) {
+		ps := newSelector(t, 50*time.Millisecond, map[string][]providers.TranscriptionResult{
+			"google": {hypothesis("google", 0.9, "hello", "world")},
+		}, nil)
+
+		start := time.Now()
+		result, err := ps.ReceiveTranscription()
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", result.Text)
+		assert.Equal(t, "google", result.ProviderName)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("does not wait for a provider that is gone", func(t *testing.T) {
+		ps := newSelector(t, time.Minute, map[string][]providers.TranscriptionResult{
+			"google": {hypothesis("google", 0.9, "hello", "world")},
+		}, map[string]time.Duration{"google": 50 * time.Millisecond}, "deepgram")
+
+		start := time.Now()
+		result, err := ps.ReceiveTranscription()
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", result.Text)
+		assert.Equal(t, "google", result.ProviderName)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("stops waiting once the provider left is gone", func(t *testing.T) {
+		ps := newSelector(t, time.Minute, map[string][]providers.TranscriptionResult{
+			"google":   {hypothesis("google", 0.9, "hello", "world")},
+			"deepgram": {hypothesis("deepgram", 0.9, "hello", "world")},
+		}, map[string]time.Duration{"deepgram": 100 * time.Millisecond}, "deepgram")
+
+		// deepgram's stream ends right after its result, which is still
+		// fused rather than left out
+		result, err := ps.ReceiveTranscription()
+		require.NoError(t, err)
+		assert.Equal(t, fusionProviderName, result.ProviderName)
+	})
+
+	t.Run("later results of a provider extend its transcript", func(t *testing.T) {
+		ps := newSelector(t, time.Second, map[string][]providers.TranscriptionResult{
+			"google":   {hypothesis("google", 0.9, "see", "you"), hypothesis("google", 0.9, "later")},
+			"deepgram": {hypothesis("deepgram", 0.9, "see", "you", "later")},
+		}, map[string]time.Duration{"deepgram": 100 * time.Millisecond})
+
+		result, err := ps.ReceiveTranscription()
+		require.NoError(t, err)
+		assert.Equal(t, "see you later", result.Text)
+	})
+}
@@ -0,0 +1,361 @@
+package stt_challenge
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// maxAlternatives is the most transcripts a client can ask for per result.
+const maxAlternatives = 10
+
+// languageCode is what a BCP-47 language tag, like "en-US" or "hi",
+// roughly looks like.
+var languageCode = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// SessionParams holds the per-connection options a client negotiates in the
+// query string of the /ws upgrade request. Zero values leave the server's
+// defaults in place.
+type SessionParams struct {
+	// Encoding is the format of the audio the client will send.
+	Encoding providers.AudioEncoding
+
+	// LanguageCode is the language of the audio, like "en-US".
+	LanguageCode string
+
+	// AlternativeLanguageCodes are other languages the audio may be in.
+	AlternativeLanguageCodes []string
+
+	// SampleRate is the sample rate of the audio in Hz.
+	SampleRate int
+
+	// Channels is the number of interleaved channels in the audio.
+	Channels int
+
+	// SeparateChannels asks for every channel to be transcribed on its own.
+	SeparateChannels bool
+
+	// Diarize asks for speaker labels, with an optional hint at how many
+	// speakers there are.
+	Diarize     bool
+	MinSpeakers int
+	MaxSpeakers int
+
+	// Phrases are hints for words and phrases likely to come up.
+	Phrases []providers.PhraseHint
+
+	// Vocabularies are the names of server-side vocabularies to use.
+	Vocabularies []string
+
+	// Models maps provider names to the models to transcribe with,
+	// out of the ones the server allows.
+	Models map[string]string
+
+	// Redaction asks for profanity and personal data to be masked, on top
+	// of what the server masks anyway.
+	Redaction RedactionConfig
+
+	// MaxAlternatives is the most transcripts to return for every
+	// sentence, the best one included.
+	MaxAlternatives int
+
+	// Strategy is how the results of the providers are picked, in place
+	// of the server's strategy.
+	Strategy SelectionStrategy
+}
+
+// Query encodes the params as URL query values.
+func (p SessionParams) Query() url.Values {
+	q := url.Values{}
+	if p.Encoding != "" {
+		q.Set("encoding", string(p.Encoding))
+	}
+	if p.LanguageCode != "" {
+		q.Set("language", p.LanguageCode)
+	}
+	for _, code := range p.AlternativeLanguageCodes {
+		q.Add("alternative_language", code)
+	}
+	if p.SampleRate != 0 {
+		q.Set("sample_rate", strconv.Itoa(p.SampleRate))
+	}
+	if p.Channels != 0 {
+		q.Set("channels", strconv.Itoa(p.Channels))
+	}
+	if p.SeparateChannels {
+		q.Set("separate_channels", "true")
+	}
+	if p.Diarize {
+		q.Set("diarize", "true")
+	}
+	if p.MinSpeakers != 0 {
+		q.Set("min_speakers", strconv.Itoa(p.MinSpeakers))
+	}
+	if p.MaxSpeakers != 0 {
+		q.Set("max_speakers", strconv.Itoa(p.MaxSpeakers))
+	}
+	for _, phrase := range p.Phrases {
+		q.Add("phrase", formatPhraseHint(phrase))
+	}
+	for _, name := range p.Vocabularies {
+		q.Add("vocabulary", name)
+	}
+	for _, provider := range slices.Sorted(maps.Keys(p.Models)) {
+		q.Add("model", provider+":"+p.Models[provider])
+	}
+	if p.Redaction.Profanity {
+		q.Set("profanity_filter", "true")
+	}
+	if len(p.Redaction.PII) > 0 {
+		types := make([]string, 0, len(p.Redaction.PII))
+		for _, pii := range p.Redaction.PII {
+			types = append(types, string(pii))
+		}
+		q.Set("redact", strings.Join(types, ","))
+	}
+	if p.Redaction.ProviderNative {
+		q.Set("provider_redaction", "true")
+	}
+	if p.MaxAlternatives != 0 {
+		q.Set("alternatives", strconv.Itoa(p.MaxAlternatives))
+	}
+	if p.Strategy != "" {
+		q.Set("strategy", string(p.Strategy))
+	}
+	return q
+}
+
+// ParseSessionParams decodes params from URL query values.
+func ParseSessionParams(q url.Values) (SessionParams, error) {
+	var p SessionParams
+
+	if v := q.Get("encoding"); v != "" {
+		switch enc := providers.AudioEncoding(v); enc {
+		case providers.EncodingLinear16, providers.EncodingFLAC, providers.EncodingMulaw,
+			providers.EncodingOggOpus, providers.EncodingWebmOpus:
+			p.Encoding = enc
+		default:
+			return p, fmt.Errorf("unsupported encoding %q", v)
+		}
+	}
+
+	if v := q.Get("language"); v != "" {
+		if !languageCode.MatchString(v) {
+			return p, fmt.Errorf("invalid language %q", v)
+		}
+		p.LanguageCode = v
+	}
+	for _, v := range q["alternative_language"] {
+		if !languageCode.MatchString(v) {
+			return p, fmt.Errorf("invalid alternative_language %q", v)
+		}
+		p.AlternativeLanguageCodes = append(p.AlternativeLanguageCodes, v)
+	}
+
+	if v := q.Get("sample_rate"); v != "" {
+		rate, err := strconv.Atoi(v)
+		if err != nil || rate <= 0 {
+			return p, fmt.Errorf("invalid sample_rate %q", v)
+		}
+		p.SampleRate = rate
+	}
+
+	if v := q.Get("channels"); v != "" {
+		channels, err := strconv.Atoi(v)
+		if err != nil || channels <= 0 {
+			return p, fmt.Errorf("invalid channels %q", v)
+		}
+		p.Channels = channels
+	}
+
+	if v := q.Get("separate_channels"); v != "" {
+		separate, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid separate_channels %q", v)
+		}
+		p.SeparateChannels = separate
+	}
+
+	if v := q.Get("diarize"); v != "" {
+		diarize, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid diarize %q", v)
+		}
+		p.Diarize = diarize
+	}
+
+	if v := q.Get("min_speakers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid min_speakers %q", v)
+		}
+		p.MinSpeakers = n
+	}
+
+	if v := q.Get("max_speakers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid max_speakers %q", v)
+		}
+		p.MaxSpeakers = n
+	}
+	if p.MaxSpeakers != 0 && p.MinSpeakers > p.MaxSpeakers {
+		return p, fmt.Errorf("min_speakers %d is more than max_speakers %d", p.MinSpeakers, p.MaxSpeakers)
+	}
+
+	for _, v := range q["phrase"] {
+		phrase, err := ParsePhraseHint(v)
+		if err != nil {
+			return p, err
+		}
+		p.Phrases = append(p.Phrases, phrase)
+	}
+
+	// Whether the vocabularies exist is up to the server's store,
+	// only their names are checked here.
+	for _, name := range q["vocabulary"] {
+		if !vocabularyName.MatchString(name) {
+			return p, fmt.Errorf("invalid vocabulary %q", name)
+		}
+		p.Vocabularies = append(p.Vocabularies, name)
+	}
+
+	for _, v := range q["model"] {
+		provider, model, err := ParseModel(v)
+		if err != nil {
+			return p, err
+		}
+		if _, ok := p.Models[provider]; ok {
+			return p, fmt.Errorf("more than one model for %s", provider)
+		}
+		if p.Models == nil {
+			p.Models = make(map[string]string)
+		}
+		p.Models[provider] = model
+	}
+
+	if v := q.Get("profanity_filter"); v != "" {
+		profanity, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid profanity_filter %q", v)
+		}
+		p.Redaction.Profanity = profanity
+	}
+
+	if v := q.Get("redact"); v != "" {
+		types, err := ParsePIITypes(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid redact %q: %w", v, err)
+		}
+		p.Redaction.PII = types
+	}
+
+	if v := q.Get("provider_redaction"); v != "" {
+		native, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid provider_redaction %q", v)
+		}
+		p.Redaction.ProviderNative = native
+	}
+
+	if v := q.Get("alternatives"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAlternatives {
+			return p, fmt.Errorf("invalid alternatives %q: want 1 to %d", v, maxAlternatives)
+		}
+		p.MaxAlternatives = n
+	}
+
+	if v := q.Get("strategy"); v != "" {
+		strategy, err := ParseSelectionStrategy(v)
+		if err != nil {
+			return p, err
+		}
+		p.Strategy = strategy
+	}
+
+	return p, nil
+}
+
+// apply overrides the server defaults in config with the negotiated params.
+func (p SessionParams) apply(config *providers.SessionConfig) {
+	if p.Encoding != "" {
+		config.Encoding = p.Encoding
+	}
+	if p.LanguageCode != "" {
+		config.LanguageCode = p.LanguageCode
+	}
+	if len(p.AlternativeLanguageCodes) > 0 {
+		config.AlternativeLanguageCodes = p.AlternativeLanguageCodes
+	}
+	if p.SampleRate != 0 {
+		config.SampleRate = p.SampleRate
+	}
+	if p.Channels != 0 {
+		config.Channels = p.Channels
+	}
+	if p.SeparateChannels {
+		config.SeparateChannels = true
+	}
+	if p.Diarize {
+		config.Diarization = providers.DiarizationConfig{
+			Enabled:     true,
+			MinSpeakers: p.MinSpeakers,
+			MaxSpeakers: p.MaxSpeakers,
+		}
+	}
+	if len(p.Phrases) > 0 {
+		config.Phrases = append(config.Phrases, p.Phrases...)
+	}
+	if p.MaxAlternatives != 0 {
+		config.MaxAlternatives = p.MaxAlternatives
+	}
+}
+
+// ParseModel parses a model choice written as "provider:model",
+// like "google:phone_call".
+func ParseModel(s string) (provider, model string, err error) {
+	provider, model, ok := strings.Cut(s, ":")
+	if !ok || provider == "" || model == "" {
+		return "", "", fmt.Errorf("invalid model %q: want provider:model", s)
+	}
+	return provider, model, nil
+}
+
+// ParsePhraseHint parses a phrase hint written as "text" or "text:boost",
+// like "Acme Cloud:10".
+func ParsePhraseHint(s string) (providers.PhraseHint, error) {
+	text := s
+	var boost float64
+	// Only a number after the last colon is a boost, so that
+	// phrases can contain colons themselves.
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		if b, err := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 32); err == nil {
+			text, boost = s[:i], b
+		}
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return providers.PhraseHint{}, fmt.Errorf("invalid phrase %q: no text", s)
+	}
+	if math.IsNaN(boost) || math.IsInf(boost, 0) {
+		return providers.PhraseHint{}, fmt.Errorf("invalid phrase %q: boost must be a finite number", s)
+	}
+	return providers.PhraseHint{Text: text, Boost: float32(boost)}, nil
+}
+
+// formatPhraseHint is the inverse of ParsePhraseHint.
+func formatPhraseHint(p providers.PhraseHint) string {
+	if p.Boost == 0 {
+		return p.Text
+	}
+	return p.Text + ":" + strconv.FormatFloat(float64(p.Boost), 'g', -1, 32)
+}
@@ -0,0 +1,227 @@
+package stt_challenge
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+func TestSessionParams_RoundTrip(t *testing.T) {
+	params := SessionParams{
+		Encoding:                 providers.EncodingFLAC,
+		LanguageCode:             "en-IN",
+		AlternativeLanguageCodes: []string{"hi-IN", "ta"},
+		SampleRate:               48000,
+		Channels:                 2,
+		SeparateChannels:         true,
+		Diarize:                  true,
+		MinSpeakers:              2,
+		MaxSpeakers:              3,
+		Phrases: []providers.PhraseHint{
+			{Text: "Acme Cloud", Boost: 10},
+			{Text: "Jane Doe"},
+			{Text: "ratio 16:9", Boost: 2.5},
+		},
+		Vocabularies: []string{"medical", "product-catalog"},
+		Models:       map[string]string{"google": "phone_call", "deepgram": "nova-3-medical"},
+		Redaction: RedactionConfig{
+			Profanity:      true,
+			PII:            []providers.PIIType{providers.PIICreditCard, providers.PIIEmail},
+			ProviderNative: true,
+		},
+		MaxAlternatives: 3,
+		Strategy:        StrategyFusion,
+	}
+
+	got, err := ParseSessionParams(params.Query())
+	require.NoError(t, err)
+	assert.Equal(t, params, got)
+
+	// Zero params encode to nothing
+	assert.Empty(t, SessionParams{}.Query())
+}
+
+func TestParseSessionParams_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "unknown encoding",
+			query: "encoding=mp3",
+			err:   `unsupported encoding "mp3"`,
+		},
+		{
+			name:  "non-numeric sample rate",
+			query: "sample_rate=fast",
+			err:   `invalid sample_rate "fast"`,
+		},
+		{
+			name:  "negative sample rate",
+			query: "sample_rate=-16000",
+			err:   `invalid sample_rate "-16000"`,
+		},
+		{
+			name:  "non-boolean separate_channels",
+			query: "separate_channels=maybe",
+			err:   `invalid separate_channels "maybe"`,
+		},
+		{
+			name:  "non-boolean diarize",
+			query: "diarize=sometimes",
+			err:   `invalid diarize "sometimes"`,
+		},
+		{
+			name:  "zero max speakers",
+			query: "diarize=true&max_speakers=0",
+			err:   `invalid max_speakers "0"`,
+		},
+		{
+			name:  "min speakers above max",
+			query: "diarize=true&min_speakers=4&max_speakers=2",
+			err:   "min_speakers 4 is more than max_speakers 2",
+		},
+		{
+			name:  "empty phrase",
+			query: "phrase=:10",
+			err:   `invalid phrase ":10": no text`,
+		},
+		{
+			name:  "invalid vocabulary name",
+			query: "vocabulary=../etc",
+			err:   `invalid vocabulary "../etc"`,
+		},
+		{
+			name:  "model without provider",
+			query: "model=phone_call",
+			err:   `invalid model "phone_call": want provider:model`,
+		},
+		{
+			name:  "two models for a provider",
+			query: "model=google:phone_call&model=google:video",
+			err:   "more than one model for google",
+		},
+		{
+			name:  "invalid language",
+			query: "language=english",
+			err:   `invalid language "english"`,
+		},
+		{
+			name:  "invalid alternative language",
+			query: "alternative_language=hi_IN",
+			err:   `invalid alternative_language "hi_IN"`,
+		},
+		{
+			name:  "unknown kind of personal data",
+			query: "redact=ssn,passport",
+			err:   `invalid redact "ssn,passport": unknown kind of personal data "passport"`,
+		},
+		{
+			name:  "non-boolean profanity_filter",
+			query: "profanity_filter=mild",
+			err:   `invalid profanity_filter "mild"`,
+		},
+		{
+			name:  "too many alternatives",
+			query: "alternatives=11",
+			err:   `invalid alternatives "11": want 1 to 10`,
+		},
+		{
+			name:  "zero alternatives",
+			query: "alternatives=0",
+			err:   `invalid alternatives "0": want 1 to 10`,
+		},
+		{
+			name:  "unknown strategy",
+			query: "strategy=fastest",
+			err:   `unknown strategy "fastest": want latency or fusion`,
+		},
+		{
+			name:  "zero channels",
+			query: "channels=0",
+			err:   `invalid channels "0"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			_, err = ParseSessionParams(q)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestSessionParams_Apply(t *testing.T) {
+	config := providers.SessionConfig{
+		SampleRate:   16000,
+		LanguageCode: "en-US",
+	}
+
+	// Empty params keep the defaults
+	SessionParams{}.apply(&config)
+	assert.Equal(t, 16000, config.SampleRate)
+	assert.Equal(t, providers.EncodingLinear16, config.EffectiveEncoding())
+
+	assert.Equal(t, 1, config.EffectiveChannels())
+
+	SessionParams{Encoding: providers.EncodingOggOpus, SampleRate: 48000, Channels: 2}.apply(&config)
+	assert.Equal(t, 48000, config.SampleRate)
+	assert.Equal(t, providers.EncodingOggOpus, config.EffectiveEncoding())
+	assert.Equal(t, 2, config.EffectiveChannels())
+	assert.Equal(t, "en-US", config.LanguageCode)
+
+	SessionParams{LanguageCode: "en-IN", AlternativeLanguageCodes: []string{"hi-IN"}}.apply(&config)
+	assert.Equal(t, "en-IN", config.LanguageCode)
+	assert.Equal(t, []string{"hi-IN"}, config.AlternativeLanguageCodes)
+
+	SessionParams{MaxAlternatives: 3}.apply(&config)
+	assert.Equal(t, 3, config.MaxAlternatives)
+}
+
+func TestSessionParams_ApplyDiarization(t *testing.T) {
+	var config providers.SessionConfig
+
+	// Speaker counts alone don't turn diarization on
+	SessionParams{MaxSpeakers: 3}.apply(&config)
+	assert.False(t, config.Diarization.Enabled)
+
+	SessionParams{Diarize: true, MaxSpeakers: 3}.apply(&config)
+	assert.Equal(t, providers.DiarizationConfig{Enabled: true, MaxSpeakers: 3}, config.Diarization)
+}
+
+func TestParsePhraseHint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected providers.PhraseHint
+		err      string
+	}{
+		{input: "Acme", expected: providers.PhraseHint{Text: "Acme"}},
+		{input: "Acme Cloud:10", expected: providers.PhraseHint{Text: "Acme Cloud", Boost: 10}},
+		{input: " Jane Doe : 2.5", expected: providers.PhraseHint{Text: "Jane Doe", Boost: 2.5}},
+		{input: "16:9", expected: providers.PhraseHint{Text: "16", Boost: 9}},
+		{input: "ratio 16:9:0", expected: providers.PhraseHint{Text: "ratio 16:9"}},
+		{input: "note: urgent", expected: providers.PhraseHint{Text: "note: urgent"}},
+		{input: "", err: `invalid phrase "": no text`},
+		{input: "Acme:NaN", err: `invalid phrase "Acme:NaN": boost must be a finite number`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePhraseHint(tt.input)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
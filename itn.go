@@ -0,0 +1,232 @@
+package stt_challenge
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numberKind is the role of a number word in a spoken number.
+type numberKind int
+
+const (
+	noNumber numberKind = iota
+	onesNumber
+	teensNumber
+	tensNumber
+	scaleNumber
+)
+
+type numberWord struct {
+	kind  numberKind
+	value int64
+}
+
+var numberWords = map[string]numberWord{
+	"zero": {onesNumber, 0}, "one": {onesNumber, 1}, "two": {onesNumber, 2},
+	"three": {onesNumber, 3}, "four": {onesNumber, 4}, "five": {onesNumber, 5},
+	"six": {onesNumber, 6}, "seven": {onesNumber, 7}, "eight": {onesNumber, 8},
+	"nine": {onesNumber, 9},
+
+	"ten": {teensNumber, 10}, "eleven": {teensNumber, 11}, "twelve": {teensNumber, 12},
+	"thirteen": {teensNumber, 13}, "fourteen": {teensNumber, 14}, "fifteen": {teensNumber, 15},
+	"sixteen": {teensNumber, 16}, "seventeen": {teensNumber, 17}, "eighteen": {teensNumber, 18},
+	"nineteen": {teensNumber, 19},
+
+	"twenty": {tensNumber, 20}, "thirty": {tensNumber, 30}, "forty": {tensNumber, 40},
+	"fifty": {tensNumber, 50}, "sixty": {tensNumber, 60}, "seventy": {tensNumber, 70},
+	"eighty": {tensNumber, 80}, "ninety": {tensNumber, 90},
+
+	"hundred": {scaleNumber, 100}, "thousand": {scaleNumber, 1_000},
+	"million": {scaleNumber, 1_000_000}, "billion": {scaleNumber, 1_000_000_000},
+}
+
+// wordToken is a word of a transcript, by byte offsets.
+type wordToken struct {
+	start, end int
+	lower      string
+}
+
+var letters = regexp.MustCompile(`[A-Za-z]+`)
+
+// findSpokenNumbers finds numbers spelled out in words, to be written as
+// digits: "twenty five" as "25", "twenty five dollars" as "$25", "ten
+// percent" as "10%" and "three point five" as "3.5". Digits said one by
+// one, like in "five five five one two three four", are strung together.
+// A lone number below ten is left alone, since "one" often isn't a number.
+func findSpokenNumbers(text string) []span {
+	var tokens []wordToken
+	for _, loc := range letters.FindAllStringIndex(text, -1) {
+		tokens = append(tokens, wordToken{loc[0], loc[1], strings.ToLower(text[loc[0]:loc[1]])})
+	}
+
+	// adjacent reports whether tokens i and i+1 are only separated by
+	// spaces or a hyphen, as in "twenty-five".
+	adjacent := func(i int) bool {
+		if i+1 >= len(tokens) {
+			return false
+		}
+		gap := text[tokens[i].end:tokens[i+1].start]
+		return gap == "-" || (gap != "" && strings.TrimSpace(gap) == "")
+	}
+	isNumber := func(i int) bool {
+		return i < len(tokens) && numberWords[tokens[i].lower].kind != noNumber
+	}
+	isWord := func(i int, words ...string) bool {
+		if i >= len(tokens) {
+			return false
+		}
+		for _, w := range words {
+			if tokens[i].lower == w {
+				return true
+			}
+		}
+		return false
+	}
+
+	// run returns the end of the number words starting at i, allowing
+	// an "and" after hundreds and thousands, as in "one hundred and five".
+	run := func(i int) int {
+		j := i + 1
+		for adjacent(j - 1) {
+			switch {
+			case isNumber(j):
+				j++
+			case isWord(j, "and") && numberWords[tokens[j-1].lower].kind == scaleNumber &&
+				adjacent(j) && isNumber(j+1) && numberWords[tokens[j+1].lower].kind != scaleNumber:
+				j += 2
+			default:
+				return j
+			}
+		}
+		return j
+	}
+
+	var spans []span
+	for i := 0; i < len(tokens); {
+		if !isNumber(i) {
+			i++
+			continue
+		}
+
+		end := run(i)
+		number := spokenNumber(tokens[i:end])
+		last := end - 1
+		kept := end-i > 1 || numberWords[tokens[i].lower].kind != onesNumber
+
+		// Decimals are said digit by digit after "point".
+		if adjacent(last) && isWord(end, "point") && adjacent(end) && isOnes(tokens, end+1) {
+			j := end + 1
+			decimals := ""
+			for isOnes(tokens, j) {
+				decimals += strconv.FormatInt(numberWords[tokens[j].lower].value, 10)
+				last = j
+				if !adjacent(j) {
+					break
+				}
+				j++
+			}
+			number += "." + decimals
+			kept = true
+		}
+
+		switch {
+		case adjacent(last) && isWord(last+1, "dollar", "dollars"):
+			number = "$" + number
+			last++
+			// "and fifty cents"
+			if adjacent(last) && isWord(last+1, "and") && adjacent(last+1) && isNumber(last+2) {
+				centsEnd := run(last + 2)
+				cents, err := strconv.Atoi(spokenNumber(tokens[last+2 : centsEnd]))
+				if err == nil && cents < 100 && adjacent(centsEnd-1) && isWord(centsEnd, "cent", "cents") {
+					number += fmt.Sprintf(".%02d", cents)
+					last = centsEnd
+				}
+			}
+			kept = true
+		case adjacent(last) && isWord(last+1, "percent"):
+			number += "%"
+			last++
+			kept = true
+		}
+
+		if kept {
+			spans = append(spans, span{start: tokens[i].start, end: tokens[last].end, replacement: number})
+		}
+		i = last + 1
+	}
+	return spans
+}
+
+func isOnes(tokens []wordToken, i int) bool {
+	return i < len(tokens) && numberWords[tokens[i].lower].kind == onesNumber
+}
+
+// spokenNumber returns the digits of a run of number words. Runs that don't
+// make up a single number, like "nineteen eighty four" or "five five five",
+// are split into the numbers that do, and strung together.
+func spokenNumber(tokens []wordToken) string {
+	var digits strings.Builder
+	var total, current int64
+	var last numberKind
+	segments := 0
+
+	flush := func() {
+		digits.WriteString(strconv.FormatInt(total+current, 10))
+		total, current = 0, 0
+		segments++
+	}
+
+	for _, t := range tokens {
+		w, ok := numberWords[t.lower]
+		if !ok {
+			// An "and" within the number
+			continue
+		}
+
+		switch w.kind {
+		case onesNumber:
+			if last == onesNumber || last == teensNumber {
+				flush()
+			}
+			current += w.value
+		case teensNumber, tensNumber:
+			if last == onesNumber || last == teensNumber || last == tensNumber {
+				flush()
+			}
+			current += w.value
+		case scaleNumber:
+			if w.value == 100 {
+				current = max(current, 1) * 100
+			} else {
+				total += max(current, 1) * w.value
+				current = 0
+			}
+		}
+		last = w.kind
+	}
+	flush()
+
+	number := digits.String()
+	if segments == 1 {
+		return groupThousands(number)
+	}
+	return number
+}
+
+// groupThousands puts commas between the thousands of numbers from 10,000
+// up. Smaller ones are left alone, since they are often years.
+func groupThousands(number string) string {
+	if len(number) < 5 {
+		return number
+	}
+	var sb strings.Builder
+	for i, r := range number {
+		if i > 0 && (len(number)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
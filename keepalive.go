@@ -0,0 +1,88 @@
+package stt_challenge
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultPingInterval is how often connections are pinged by default.
+const defaultPingInterval = 20 * time.Second
+
+// pingWriteTimeout is how long writing a ping may take.
+const pingWriteTimeout = 5 * time.Second
+
+// defaultWriteTimeout is how long writing a response may take on
+// connections that aren't pinged.
+const defaultWriteTimeout = 10 * time.Second
+
+// writeTimeout is how long writing a response may take. A client taking no
+// response for a whole ping interval is taken to no longer read.
+func (wc *WebConn) writeTimeout() time.Duration {
+	if wc.pingInterval > 0 {
+		return wc.pingInterval
+	}
+	return defaultWriteTimeout
+}
+
+// keepAlive gives the connection a read deadline of two ping intervals,
+// pushed back by every pong. A client gone without closing the connection,
+// like one that lost its network, stops answering pings, and the reader
+// finds out once the deadline passes.
+func (wc *WebConn) keepAlive(conn *websocket.Conn) {
+	if wc.pingInterval <= 0 {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * wc.pingInterval))
+	conn.SetPongHandler(func(string) error {
+		// The deadline of a connection being closed stays as it is.
+		select {
+		case <-wc.closing:
+			return nil
+		default:
+		}
+		return conn.SetReadDeadline(time.Now().Add(2 * wc.pingInterval))
+	})
+}
+
+// pinger pings the connection every ping interval, until the session ends.
+// Nothing is pinged while a resumable session waits for its client.
+func (wc *WebConn) pinger() {
+	ticker := time.NewTicker(wc.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Control frames may be written alongside the writer, and
+			// the connection is read without waiting for it.
+			if conn := wc.currentConn(); conn != nil {
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteTimeout))
+			}
+		case <-wc.closing:
+			return
+		}
+	}
+}
+
+// idle ends the session of a client that sent no audio for the idle
+// timeout, to stop paying the providers for it. The session is finished
+// like a client would, to send the results it still has.
+func (wc *WebConn) idle() {
+	if wc.idled.Swap(true) {
+		return
+	}
+	wc.log.Printf("No audio for %v, ending the session\n", wc.idleTimeout)
+	wc.writeJSON(WebSocketResponse{
+		Type:    ResponseIdle,
+		Message: "no audio for " + wc.idleTimeout.String() + ", ending the session",
+	})
+	wc.interrupt()
+}
+
+// touch pushes back the idle timeout, once audio comes in.
+func (wc *WebConn) touch() {
+	if wc.idleTimer != nil {
+		wc.idleTimer.Reset(wc.idleTimeout)
+	}
+}
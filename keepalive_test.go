@@ -0,0 +1,136 @@
+package stt_challenge
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// newKeepAliveTestServer returns a server pinging every pingInterval, whose
+// session transcribes nothing, and the channel closed with the session.
+func newKeepAliveTestServer(t *testing.T, pingInterval time.Duration) (string, chan struct{}) {
+	t.Helper()
+
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+	closed := make(chan struct{})
+
+	var sessionCtx context.Context
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).
+		RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+			sessionCtx = ctx
+			return mockSession, nil
+		}).Once()
+	mockSession.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		<-sessionCtx.Done()
+		return providers.TranscriptionResult{}, io.EOF
+	}).Once()
+	mockSession.EXPECT().Close().RunAndReturn(func() error {
+		close(closed)
+		return nil
+	}).Once()
+
+	cfg := DefaultConfig()
+	cfg.PingInterval = pingInterval
+	_, wsURL := newConfigTestServer(t, cfg, mockProvider)
+	return wsURL, closed
+}
+
+func TestWebSocketKeepAlive(t *testing.T) {
+	wsURL, closed := newKeepAliveTestServer(t, 20*time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+
+	pinged := make(chan struct{}, 10)
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		read <- err
+	}()
+
+	// A client answering pings stays connected well past the read deadline
+	select {
+	case <-pinged:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection should be pinged")
+	}
+	select {
+	case err := <-read:
+		t.Fatalf("the connection should stay open, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	conn.Close()
+	waitClosed(t, closed)
+}
+
+func TestWebSocketKeepAliveDeadConnection(t *testing.T) {
+	wsURL, closed := newKeepAliveTestServer(t, 20*time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// A client that doesn't read doesn't answer pings either, and its
+	// session is closed once the read deadline passes
+	waitClosed(t, closed)
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err, "the connection should be dropped")
+}
+
+func TestWebSocketKeepAliveStalledWrites(t *testing.T) {
+	mockProvider, closed := newFloodTestProvider(t)
+	cfg := DefaultConfig()
+	cfg.PingInterval = 100 * time.Millisecond
+	cfg.Formatting = FormattingConfig{}
+	server, wsURL := newConfigTestServer(t, cfg, mockProvider)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// A client that doesn't read stalls the writes of the server, which
+	// give up within the ping interval, so that the connection goes away
+	waitClosed(t, closed)
+	require.Eventually(t, func() bool {
+		return server.Metrics().Connections == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	mockProvider, closed := newFinishTestProvider(t)
+	cfg := DefaultConfig()
+	cfg.IdleTimeout = 100 * time.Millisecond
+	_, wsURL := newConfigTestServer(t, cfg, mockProvider)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(WebSocketRequest{Buf: []byte("audio")}))
+
+	// The session is finished once no audio came in for the idle timeout
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, ResponseIdle, response.Type)
+	assert.NotEmpty(t, response.Message)
+	readEndOfTranscript(t, conn)
+	waitClosed(t, closed)
+}
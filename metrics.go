@@ -0,0 +1,46 @@
+package stt_challenge
+
+import (
+	"net/http"
+)
+
+// Metrics is what GET /metrics reports.
+type Metrics struct {
+	// Connections is how many WebSocket connections are open.
+	Connections int `json:"connections"`
+
+	// Queues are the queue depths of the open connections, and what the
+	// overflow policies of every connection so far did.
+	Queues QueueStats `json:"queues"`
+}
+
+// Metrics returns the current metrics of the server.
+func (s *Server) Metrics() Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := Metrics{Connections: len(s.conns)}
+	m.Queues.add(s.endedQueues)
+	for wc := range s.conns {
+		if wc.selector != nil {
+			m.Queues.add(wc.selector.QueueStats())
+		}
+	}
+	return m
+}
+
+// endQueues keeps what the overflow policies of a closed connection did,
+// dropping its queue depths. It must be called with mu held.
+func (s *Server) endQueues(wc *WebConn) {
+	if wc.selector == nil {
+		return
+	}
+	stats := wc.selector.QueueStats()
+	stats.AudioQueued = nil
+	stats.ResultsQueued = 0
+	s.endedQueues.add(stats)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Metrics())
+}
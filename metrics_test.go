@@ -0,0 +1,48 @@
+package stt_challenge
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	server, url := newDrainTestServer(t, newDrainTestProvider(t))
+
+	getMetrics := func() Metrics {
+		t.Helper()
+		resp, err := http.Get(url + "/metrics")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var m Metrics
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		return m
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	require.NoError(t, err)
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+
+	// Every provider of the open connections has a queue
+	m := getMetrics()
+	assert.Equal(t, 1, m.Connections)
+	assert.Equal(t, map[string]int{"mock-provider": 0}, m.Queues.AudioQueued)
+	assert.Equal(t, map[string]int64{"mock-provider": 0}, m.Queues.AudioDropped)
+
+	// Closed connections have no queues left, but their counts are kept
+	conn.Close()
+	require.Eventually(t, func() bool {
+		return server.Metrics().Connections == 0
+	}, 2*time.Second, 5*time.Millisecond)
+	m = getMetrics()
+	assert.Empty(t, m.Queues.AudioQueued)
+	assert.Equal(t, map[string]int64{"mock-provider": 0}, m.Queues.AudioDropped)
+}
@@ -0,0 +1,182 @@
+package stt_challenge
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// ResultStage transforms a result on its way from the providers to the
+// client. Stages run one after the other on every result the session
+// selects, so they see the result as the previous stage left it.
+type ResultStage interface {
+	Process(result *providers.TranscriptionResult)
+}
+
+// ResultStageFunc adapts a function to a ResultStage.
+type ResultStageFunc func(result *providers.TranscriptionResult)
+
+// Process calls f(result).
+func (f ResultStageFunc) Process(result *providers.TranscriptionResult) {
+	f(result)
+}
+
+// resultPipeline wraps a session and runs every result it returns through
+// a series of stages, in order, before anyone else gets to see it.
+type resultPipeline struct {
+	providers.Session
+
+	stages []ResultStage
+}
+
+// ReceiveTranscription returns the next result of the session, processed.
+func (p *resultPipeline) ReceiveTranscription() (providers.TranscriptionResult, error) {
+	result, err := p.Session.ReceiveTranscription()
+	if err != nil {
+		return result, err
+	}
+	for _, stage := range p.stages {
+		stage.Process(&result)
+	}
+	return result, nil
+}
+
+// span is a part of a text to replace, by byte offsets.
+type span struct {
+	start, end  int
+	replacement string
+}
+
+// spanStage replaces the spans of text that find returns, in the text of a
+// result, in its words and in its alternatives.
+type spanStage struct {
+	find func(text string) []span
+}
+
+func (s spanStage) Process(result *providers.TranscriptionResult) {
+	result.Text = replaceSpans(result.Text, s.find(result.Text))
+	result.Words = replaceWordSpans(result.Words, s.find)
+	for i, alt := range result.Alternatives {
+		result.Alternatives[i].Text = replaceSpans(alt.Text, s.find(alt.Text))
+	}
+}
+
+// replaceSpans replaces spans of text. The spans must be sorted and must
+// not overlap.
+func replaceSpans(text string, spans []span) string {
+	if len(spans) == 0 {
+		return text
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, sp := range spans {
+		sb.WriteString(text[last:sp.start])
+		sb.WriteString(sp.replacement)
+		last = sp.end
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// replaceWordSpans finds spans in the words joined by spaces, so that spans
+// running across words, like a card number said in groups of four, are
+// found as well. The words a span touches are merged into a single word
+// carrying the replacement, which keeps the timing of the whole span.
+func replaceWordSpans(words []providers.Word, find func(text string) []span) []providers.Word {
+	if len(words) == 0 {
+		return words
+	}
+
+	// offsets[i] is where word i starts in the joined text.
+	offsets := make([]int, len(words))
+	var sb strings.Builder
+	for i, w := range words {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		offsets[i] = sb.Len()
+		sb.WriteString(w.Text)
+	}
+
+	text := sb.String()
+	spans := find(text)
+	if len(spans) == 0 {
+		return words
+	}
+
+	// wordAt returns the first word that ends after offset.
+	wordAt := func(offset int) int {
+		i, _ := slices.BinarySearchFunc(offsets, offset, func(start, offset int) int {
+			return start - offset
+		})
+		if i > 0 && offsets[i-1]+len(words[i-1].Text) > offset {
+			i--
+		}
+		return i
+	}
+
+	replaced := make([]providers.Word, 0, len(words))
+	next := 0
+	for len(spans) > 0 {
+		// Group the spans touching the same words, and replace them
+		// within the text of those words.
+		first := wordAt(spans[0].start)
+		last := max(wordAt(spans[0].end-1), first)
+		n := 1
+		for n < len(spans) && wordAt(spans[n].start) <= last {
+			last = max(last, wordAt(spans[n].end-1))
+			n++
+		}
+		if first >= len(words) {
+			break
+		}
+		last = min(last, len(words)-1)
+
+		from, to := offsets[first], offsets[last]+len(words[last].Text)
+		group := make([]span, 0, n)
+		for _, sp := range spans[:n] {
+			group = append(group, span{
+				start:       min(max(sp.start, from), to) - from,
+				end:         min(max(sp.end, from), to) - from,
+				replacement: sp.replacement,
+			})
+		}
+		spans = spans[n:]
+
+		merged := words[first]
+		merged.Text = replaceSpans(text[from:to], group)
+		merged.End = words[last].End
+		for _, w := range words[first+1 : last+1] {
+			merged.Confidence = min(merged.Confidence, w.Confidence)
+		}
+		replaced = append(replaced, words[next:first]...)
+		replaced = append(replaced, merged)
+		next = last + 1
+	}
+	return append(replaced, words[next:]...)
+}
+
+// mergeSpans sorts spans found by several finders, and drops the ones
+// overlapping an earlier span. Of two spans starting together, the longer
+// one wins.
+func mergeSpans(spans []span) []span {
+	slices.SortFunc(spans, func(a, b span) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+
+	merged := spans[:0]
+	end := 0
+	for _, sp := range spans {
+		if len(merged) > 0 && sp.start < end {
+			continue
+		}
+		merged = append(merged, sp)
+		end = sp.end
+	}
+	return merged
+}
@@ -0,0 +1,121 @@
+package stt_challenge
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// findWord finds every occurrence of word, to be replaced with "<word>".
+func findWord(word string) func(text string) []span {
+	return func(text string) []span {
+		var spans []span
+		for i := 0; ; {
+			j := strings.Index(text[i:], word)
+			if j < 0 {
+				return spans
+			}
+			spans = append(spans, span{start: i + j, end: i + j + len(word), replacement: "<" + word + ">"})
+			i += j + len(word)
+		}
+	}
+}
+
+// timedWords builds one word per second of audio.
+func timedWords(texts ...string) []providers.Word {
+	words := make([]providers.Word, 0, len(texts))
+	for i, text := range texts {
+		at := time.Duration(i) * time.Second
+		words = append(words, providers.Word{Text: text, Start: at, End: at + 500*time.Millisecond, Confidence: 0.9})
+	}
+	return words
+}
+
+func TestResultPipeline(t *testing.T) {
+	mockSession := mocks.NewMockSession(t)
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{Text: "a b c"}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, errors.New("boom")).Once()
+
+	pipeline := &resultPipeline{
+		Session: mockSession,
+		stages:  []ResultStage{spanStage{find: findWord("a")}, spanStage{find: findWord("<a>")}},
+	}
+
+	// Stages run in order
+	result, err := pipeline.ReceiveTranscription()
+	require.NoError(t, err)
+	assert.Equal(t, "<<a>> b c", result.Text)
+
+	_, err = pipeline.ReceiveTranscription()
+	assert.EqualError(t, err, "boom")
+}
+
+func TestSpanStage_Alternatives(t *testing.T) {
+	result := providers.TranscriptionResult{
+		Text: "a b",
+		Alternatives: []providers.Alternative{
+			{Text: "b a a", Confidence: 0.5},
+			{Text: "b c", Confidence: 0.2},
+		},
+	}
+	spanStage{find: findWord("a")}.Process(&result)
+
+	assert.Equal(t, "<a> b", result.Text)
+	assert.Equal(t, []providers.Alternative{
+		{Text: "b <a> <a>", Confidence: 0.5},
+		{Text: "b c", Confidence: 0.2},
+	}, result.Alternatives)
+}
+
+func TestReplaceWordSpans(t *testing.T) {
+	t.Run("spans across words are merged", func(t *testing.T) {
+		words := timedWords("card", "4111", "1111,", "thanks")
+		words[2].Confidence = 0.5
+
+		got := replaceWordSpans(words, findWord("4111 1111"))
+		require.Len(t, got, 3)
+		assert.Equal(t, "card", got[0].Text)
+		assert.Equal(t, providers.Word{
+			Text:       "<4111 1111>,",
+			Start:      time.Second,
+			End:        2500 * time.Millisecond,
+			Confidence: 0.5,
+		}, got[1])
+		assert.Equal(t, "thanks", got[2].Text)
+	})
+
+	t.Run("several spans in one word", func(t *testing.T) {
+		got := replaceWordSpans(timedWords("aa-aa", "b"), findWord("aa"))
+		require.Len(t, got, 2)
+		assert.Equal(t, "<aa>-<aa>", got[0].Text)
+		assert.Equal(t, "b", got[1].Text)
+	})
+
+	t.Run("separate spans", func(t *testing.T) {
+		got := replaceWordSpans(timedWords("x", "b", "x"), findWord("x"))
+		assert.Equal(t, []string{"<x>", "b", "<x>"}, []string{got[0].Text, got[1].Text, got[2].Text})
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		words := timedWords("a", "b")
+		assert.Equal(t, words, replaceWordSpans(words, findWord("x")))
+		assert.Empty(t, replaceWordSpans(nil, findWord("x")))
+	})
+}
+
+func TestMergeSpans(t *testing.T) {
+	spans := mergeSpans([]span{
+		{start: 10, end: 12},
+		{start: 0, end: 4},
+		{start: 0, end: 8},
+		{start: 6, end: 11},
+	})
+	assert.Equal(t, []span{{start: 0, end: 8}, {start: 10, end: 12}}, spans)
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/agnivade/stt_challenge/providers"
@@ -21,7 +22,8 @@ type ProviderResultWithSeq struct {
 }
 
 // ProviderSelector manages multiple transcription providers and dynamically
-// selects the best provider based on latency and performance metrics.
+// selects the best provider based on latency and performance metrics, or
+// fuses the results of all of them, depending on its strategy.
 type ProviderSelector struct {
 	sessions            []providers.Session
 	providerNames       []string
@@ -29,10 +31,46 @@ type ProviderSelector struct {
 	transcriptionOutput chan providers.TranscriptionResult
 	transcriptionBuffer chan providers.TranscriptionResult
 
+	// Backpressure. Every provider has a queue of audio of its own, by
+	// index in sessions, so that a slow one doesn't hold up the others.
+	// overflowing is only touched by the audioDistributor, and
+	// resultsOverflowing and emitted by the selector goroutine, which
+	// numbers the results it queues. slowClient is closed
+	// once the client is disconnected for being too slow. slowProvider is
+	// the SlowProvider policy in effect.
+	slowProvider       OverflowPolicy
+	audioQueues        []chan []byte
+	audioDropped       []atomic.Int64
+	disconnected       []atomic.Bool
+	overflowing        []bool
+	resultsDropped     atomic.Int64
+	resultsOverflowing bool
+	emitted            uint64
+	slowClient         chan struct{}
+	slowClientOnce     sync.Once
+
+	// Streams. ended is set once the stream of a provider ended, after
+	// which it returns no more results, and streamEnded is then signalled
+	// for the selector to stop waiting for it.
+	ended       []atomic.Bool
+	streamEnded chan struct{}
+
 	// Active provider tracking
 	activeProvider      string
 	providerResults     map[string][]ProviderResultWithSeq
 	providerSeqCounters map[string]uint64
+	speakers            *speakerMapper
+	selectorConfig      SelectorConfig
+
+	// Finishing. finishing is closed by Finish, collected once every
+	// provider session has returned its last result after that, and
+	// finished once the selector has forwarded every result.
+	closeAudio sync.Once
+	finishOnce sync.Once
+	finishing  chan struct{}
+	collected  chan struct{}
+	finished   chan struct{}
+	collectors sync.WaitGroup
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -41,17 +79,25 @@ type ProviderSelector struct {
 }
 
 // NewProviderSelector creates a new provider selector with the given providers.
-func NewProviderSelector(providersList []providers.Provider, config providers.SessionConfig, logger *log.Logger) (*ProviderSelector, error) {
+func NewProviderSelector(providersList []providers.Provider, config providers.SessionConfig, selectorConfig SelectorConfig, logger *log.Logger) (*ProviderSelector, error) {
 	selectorCtx, cancel := context.WithCancel(context.Background())
 
 	ps := &ProviderSelector{
 		sessions:            make([]providers.Session, 0, len(providersList)),
 		providerNames:       make([]string, 0, len(providersList)),
 		audioInput:          make(chan []byte, 100), // Buffered channel
-		transcriptionOutput: make(chan providers.TranscriptionResult, 10),
+		transcriptionOutput: make(chan providers.TranscriptionResult, selectorConfig.Backpressure.effectiveResultQueueSize()),
 		transcriptionBuffer: make(chan providers.TranscriptionResult, 100),
 		providerResults:     make(map[string][]ProviderResultWithSeq),
 		providerSeqCounters: make(map[string]uint64),
+		speakers:            newSpeakerMapper(),
+		selectorConfig:      selectorConfig,
+		slowProvider:        selectorConfig.Backpressure.slowProviderPolicy(config.EffectiveEncoding()),
+		slowClient:          make(chan struct{}),
+		streamEnded:         make(chan struct{}, 1),
+		finishing:           make(chan struct{}),
+		collected:           make(chan struct{}),
+		finished:            make(chan struct{}),
 		ctx:                 selectorCtx,
 		cancel:              cancel,
 		log:                 logger,
@@ -78,18 +124,40 @@ func NewProviderSelector(providersList []providers.Provider, config providers.Se
 	// Initialize active provider to first available
 	ps.activeProvider = ps.providerNames[0]
 
+	ps.audioQueues = make([]chan []byte, len(ps.sessions))
+	for i := range ps.audioQueues {
+		ps.audioQueues[i] = make(chan []byte, selectorConfig.Backpressure.effectiveAudioQueueSize())
+	}
+	ps.audioDropped = make([]atomic.Int64, len(ps.sessions))
+	ps.disconnected = make([]atomic.Bool, len(ps.sessions))
+	ps.overflowing = make([]bool, len(ps.sessions))
+	ps.ended = make([]atomic.Bool, len(ps.sessions))
+
 	// Start goroutines
 	ps.wg.Add(1)
 	go ps.audioDistributor()
 
+	for i := range ps.sessions {
+		ps.wg.Add(1)
+		go ps.audioSender(i)
+	}
+
 	ps.wg.Add(1)
-	go ps.heuristicSelector()
+	if selectorConfig.Strategy == StrategyFusion {
+		go ps.fusionSelector()
+	} else {
+		go ps.heuristicSelector()
+	}
 
-	for i, session := range ps.sessions {
+	for i := range ps.sessions {
 		ps.wg.Add(1)
-		go ps.transcriptionCollector(session, ps.providerNames[i])
+		ps.collectors.Add(1)
+		go ps.transcriptionCollector(i)
 	}
 
+	ps.wg.Add(1)
+	go ps.waitCollected()
+
 	return ps, nil
 }
 
@@ -106,11 +174,28 @@ func (ps *ProviderSelector) SendAudio(audioData []byte) error {
 	}
 }
 
-// ReceiveTranscription implements the providers.Session interface
+// ReceiveTranscription implements the providers.Session interface. Once the
+// client is disconnected for being too slow, it returns ErrSlowClient.
 func (ps *ProviderSelector) ReceiveTranscription() (providers.TranscriptionResult, error) {
+	select {
+	case <-ps.slowClient:
+		return providers.TranscriptionResult{}, ErrSlowClient
+	default:
+	}
+
 	select {
 	case result := <-ps.transcriptionOutput:
 		return result, nil
+	case <-ps.finished:
+		// Results still queued come first.
+		select {
+		case result := <-ps.transcriptionOutput:
+			return result, nil
+		default:
+			return providers.TranscriptionResult{}, io.EOF
+		}
+	case <-ps.slowClient:
+		return providers.TranscriptionResult{}, ErrSlowClient
 	case <-ps.ctx.Done():
 		if ps.ctx.Err() == context.Canceled {
 			return providers.TranscriptionResult{}, io.EOF
@@ -119,13 +204,26 @@ func (ps *ProviderSelector) ReceiveTranscription() (providers.TranscriptionResul
 	}
 }
 
+// Finish implements the providers.Session interface. Once the audio sent so
+// far has reached every provider, their sessions are finished too. The
+// selector then forwards their last results, and ReceiveTranscription
+// returns io.EOF.
+func (ps *ProviderSelector) Finish() error {
+	ps.finishOnce.Do(func() {
+		close(ps.finishing)
+		// Every session is finished once it was sent everything.
+		ps.closeAudio.Do(func() { close(ps.audioInput) })
+	})
+	return nil
+}
+
 // Close implements the providers.Session interface
 func (ps *ProviderSelector) Close() error {
 	// Cancel reader stream, to allow for transcriptionCollector to exit.
 	ps.cancel()
 	// Close will be called after ws reader exits. So there's no chance
-	// of writing to ps.audioInput again.
-	close(ps.audioInput)
+	// of writing to ps.audioInput again. Finish may have closed it already.
+	ps.closeAudio.Do(func() { close(ps.audioInput) })
 
 	// Wait for all goroutines to finish before closing sessions
 	// This ensures audioDistributor goroutines complete before we close sessions
@@ -145,42 +243,36 @@ func (ps *ProviderSelector) Close() error {
 	return nil
 }
 
-// audioDistributor distributes audio data to all provider sessions synchronously
+// audioDistributor distributes audio data to the queue of every provider
+// session. It never waits for a provider, each has an audioSender of its own.
 func (ps *ProviderSelector) audioDistributor() {
 	defer ps.wg.Done()
 
 	for audioData := range ps.audioInput {
-		// Copy buffer for each provider to avoid race conditions
-		var wg sync.WaitGroup
-
-		for i, session := range ps.sessions {
-			wg.Add(1)
-			go func(s providers.Session, providerID int) {
-				defer wg.Done()
-
-				// Create a copy of the audio data
-				// TODO: this is expensive, need to reuse byte buffers.
-				audioCopy := make([]byte, len(audioData))
-				copy(audioCopy, audioData)
-
-				if err := s.SendAudio(audioCopy); err != nil {
-					if errors.Is(err, io.EOF) {
-						return
-					}
-					ps.log.Printf("Provider %s audio send failed: %v",
-						ps.providerNames[providerID], err)
-				}
-			}(session, i)
+		for i := range ps.sessions {
+			// Copy buffer for each provider to avoid race conditions
+			// TODO: this is expensive, need to reuse byte buffers.
+			audioCopy := make([]byte, len(audioData))
+			copy(audioCopy, audioData)
+			ps.queueAudio(i, audioCopy)
 		}
+	}
 
-		// Wait for all providers to receive audio before processing next chunk
-		wg.Wait()
+	// The audioSenders finish the sessions once they have sent them
+	// everything. Queues of disconnected providers are already closed.
+	for i, queue := range ps.audioQueues {
+		if !ps.disconnected[i].Load() {
+			close(queue)
+		}
 	}
 }
 
 // transcriptionCollector collects transcription results from a single provider
-func (ps *ProviderSelector) transcriptionCollector(session providers.Session, providerName string) {
+func (ps *ProviderSelector) transcriptionCollector(i int) {
 	defer ps.wg.Done()
+	defer ps.collectors.Done()
+	defer ps.endStream(i)
+	session, providerName := ps.sessions[i], ps.providerNames[i]
 
 	for {
 		result, err := session.ReceiveTranscription()
@@ -201,6 +293,46 @@ func (ps *ProviderSelector) transcriptionCollector(session providers.Session, pr
 	}
 }
 
+// endStream marks the stream of a provider as ended, and lets the selector
+// know.
+func (ps *ProviderSelector) endStream(i int) {
+	ps.ended[i].Store(true)
+	select {
+	case ps.streamEnded <- struct{}{}:
+	default:
+		// The selector has yet to take the last one, and checks them all.
+	}
+}
+
+// waitCollected closes collected once the sessions are finished, and every
+// provider session has returned its last result.
+func (ps *ProviderSelector) waitCollected() {
+	defer ps.wg.Done()
+
+	ps.collectors.Wait()
+	select {
+	case <-ps.finishing:
+		close(ps.collected)
+	case <-ps.ctx.Done():
+	}
+}
+
+// drainCollected calls handle with the results left in the buffer, once
+// every collector is done, or the one of a provider whose stream ended. It
+// returns false if handle does.
+func (ps *ProviderSelector) drainCollected(handle func(providers.TranscriptionResult) bool) bool {
+	for {
+		select {
+		case result := <-ps.transcriptionBuffer:
+			if !handle(result) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
 // heuristicSelector implements the active provider streaming strategy
 func (ps *ProviderSelector) heuristicSelector() {
 	defer ps.wg.Done()
@@ -208,33 +340,46 @@ func (ps *ProviderSelector) heuristicSelector() {
 	windowTicker := time.NewTicker(2 * time.Second)
 	// No need for defer windowTicker.Stop() post Go 1.23
 
+	handle := func(result providers.TranscriptionResult) bool {
+		// We are not storing intermediate results.
+		if !result.IsFinal {
+			return true
+		}
+
+		// Relabel speakers before anything is stored or sent, so that
+		// a switch of the active provider doesn't relabel everyone.
+		ps.speakers.mapResult(&result)
+
+		// Increment sequence number for this provider
+		ps.providerSeqCounters[result.ProviderName]++
+		seqNum := ps.providerSeqCounters[result.ProviderName]
+
+		// Store result with sequence number
+		resultWithSeq := ProviderResultWithSeq{
+			Result: result,
+			SeqNum: seqNum,
+		}
+		ps.providerResults[result.ProviderName] = append(ps.providerResults[result.ProviderName], resultWithSeq)
+
+		// If result is from active provider, forward immediately
+		if result.ProviderName == ps.activeProvider {
+			return ps.emit(result)
+		}
+		return true
+	}
+
 	for {
 		select {
 		case result := <-ps.transcriptionBuffer:
-			// We are not storing intermediate results.
-			if !result.IsFinal {
-				continue
+			if !handle(result) {
+				return
 			}
 
-			// Increment sequence number for this provider
-			ps.providerSeqCounters[result.ProviderName]++
-			seqNum := ps.providerSeqCounters[result.ProviderName]
-
-			// Store result with sequence number
-			resultWithSeq := ProviderResultWithSeq{
-				Result: result,
-				SeqNum: seqNum,
-			}
-			ps.providerResults[result.ProviderName] = append(ps.providerResults[result.ProviderName], resultWithSeq)
-
-			// If result is from active provider, forward immediately
-			if result.ProviderName == ps.activeProvider {
-				select {
-				case ps.transcriptionOutput <- result:
-				case <-ps.ctx.Done():
-					return
-				}
+		case <-ps.collected:
+			if ps.drainCollected(handle) {
+				close(ps.finished)
 			}
+			return
 
 		case <-windowTicker.C:
 			// Update active provider based on latency analysis
@@ -304,7 +449,8 @@ func (ps *ProviderSelector) updateActiveProvider() {
 // would be the same, which may not be the case. Even if it's the same,
 // the detection logic might differ. But we keep it simple for now.
 // A side issue is that it does not play well with replayed audio since
-// the samples get sent too quickly.
+// the samples get sent too quickly. Replay files with the client's -realtime
+// flag to avoid that.
 func (ps *ProviderSelector) sendMissedMessages(oldProvider, newProvider string) {
 	oldResults := ps.providerResults[oldProvider]
 	newResults := ps.providerResults[newProvider]
@@ -319,12 +465,11 @@ func (ps *ProviderSelector) sendMissedMessages(oldProvider, newProvider string)
 	// Send any results from new provider with higher sequence numbers
 	for _, resultWithSeq := range newResults {
 		if resultWithSeq.SeqNum > lastOldSeq {
-			ps.log.Printf("Sending missed message from %s (seq:%d): %s",
-				newProvider, resultWithSeq.SeqNum, resultWithSeq.Result.Text)
+			// The text isn't logged, since it is yet to be redacted.
+			ps.log.Printf("Sending missed message from %s (seq:%d)",
+				newProvider, resultWithSeq.SeqNum)
 
-			select {
-			case ps.transcriptionOutput <- resultWithSeq.Result:
-			case <-ps.ctx.Done():
+			if !ps.emit(resultWithSeq.Result) {
 				return
 			}
 		}
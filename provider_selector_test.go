@@ -2,15 +2,18 @@ package stt_challenge
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
 )
 
 func TestProviderSelector_updateActiveProvider(t *testing.T) {
@@ -549,3 +552,87 @@ func TestProviderSelector_SendAudio(t *testing.T) {
 		}
 	})
 }
+
+func TestProviderSelector_Finish(t *testing.T) {
+	// newSelector returns a selector over two providers, whose sessions
+	// only return their last result once they are finished.
+	newSelector := func(t *testing.T, selectorConfig SelectorConfig, results map[string][]providers.TranscriptionResult) *ProviderSelector {
+		var list []providers.Provider
+		for _, name := range []string{"google", "deepgram"} {
+			provider := mocks.NewMockProvider(t)
+			session := mocks.NewMockSession(t)
+			finished := make(chan struct{})
+			provider.EXPECT().Name().Return(name)
+			provider.EXPECT().NewSession(mock.Anything, mock.Anything).Return(session, nil)
+			session.EXPECT().SendAudio(mock.Anything).Return(nil)
+			// The audio sent before reaches the session first
+			session.EXPECT().Finish().RunAndReturn(func() error {
+				close(finished)
+				return nil
+			}).Once()
+			for _, r := range results[name] {
+				session.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+					<-finished
+					return r, nil
+				}).Once()
+			}
+			session.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+				<-finished
+				return providers.TranscriptionResult{}, io.EOF
+			}).Once()
+			session.EXPECT().Close().Return(nil)
+			list = append(list, provider)
+		}
+
+		ps, err := NewProviderSelector(list, providers.SessionConfig{}, selectorConfig, log.New(&ThreadSafeBuffer{}, "", 0))
+		require.NoError(t, err)
+		t.Cleanup(func() { ps.Close() })
+		return ps
+	}
+
+	tests := []struct {
+		name           string
+		selectorConfig SelectorConfig
+		results        map[string][]providers.TranscriptionResult
+		expected       []string
+	}{
+		{
+			name:           "latency forwards the last results of the active provider",
+			selectorConfig: SelectorConfig{Strategy: StrategyLatency},
+			results: map[string][]providers.TranscriptionResult{
+				"google":   {hypothesis("google", 0.9, "last", "words")},
+				"deepgram": {hypothesis("deepgram", 0.9, "last", "word")},
+			},
+			expected: []string{"last words"},
+		},
+		{
+			name:           "fusion fuses whatever it has without waiting for the window",
+			selectorConfig: SelectorConfig{Strategy: StrategyFusion, FusionWindow: time.Minute},
+			results: map[string][]providers.TranscriptionResult{
+				"google": {hypothesis("google", 0.9, "last", "words")},
+			},
+			expected: []string{"last words"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newSelector(t, tt.selectorConfig, tt.results)
+			require.NoError(t, ps.SendAudio([]byte("audio")))
+			require.NoError(t, ps.Finish())
+			// Finishing twice is fine
+			require.NoError(t, ps.Finish())
+
+			var texts []string
+			for {
+				result, err := ps.ReceiveTranscription()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				texts = append(texts, result.Text)
+			}
+			assert.Equal(t, tt.expected, texts)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package providers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// maxClassExpansions caps how many phrases ExpandClasses makes out of a
+// single phrase. Past it, the items of the classes are used on their own.
+const maxClassExpansions = 100
+
+var classReference = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// ClassReferences returns the names of the custom classes a phrase refers
+// to, in order of appearance.
+func ClassReferences(text string) []string {
+	var names []string
+	for _, m := range classReference.FindAllStringSubmatch(text, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// ExpandClasses turns phrases that refer to custom classes into plain
+// phrases, for providers that don't support classes. A phrase is repeated
+// with every item of the classes it refers to, so "call ${contacts}" becomes
+// "call Jane Doe", "call John Doe" and so on. Classes no phrase refers to
+// add their items as phrases of their own. Phrases referring to unknown
+// classes are dropped, and duplicates are only kept once.
+func ExpandClasses(phrases []PhraseHint, classes []CustomClass) []PhraseHint {
+	byName := make(map[string]CustomClass, len(classes))
+	for _, c := range classes {
+		byName[c.Name] = c
+	}
+
+	var expanded []PhraseHint
+	seen := make(map[string]bool)
+	add := func(p PhraseHint) {
+		if p.Text != "" && !seen[p.Text] {
+			seen[p.Text] = true
+			expanded = append(expanded, p)
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, p := range phrases {
+		names := ClassReferences(p.Text)
+		if len(names) == 0 {
+			add(p)
+			continue
+		}
+
+		combinations := 1
+		known := true
+		for _, name := range names {
+			referenced[name] = true
+			c, ok := byName[name]
+			if !ok {
+				known = false
+				break
+			}
+			combinations *= max(len(c.Items), 1)
+		}
+		if !known {
+			continue
+		}
+
+		if combinations > maxClassExpansions {
+			for _, name := range names {
+				for _, item := range byName[name].Items {
+					add(PhraseHint{Text: item, Boost: p.Boost})
+				}
+			}
+			continue
+		}
+		for _, text := range expandReferences(p.Text, byName) {
+			add(PhraseHint{Text: strings.Join(strings.Fields(text), " "), Boost: p.Boost})
+		}
+	}
+
+	for _, c := range classes {
+		if referenced[c.Name] {
+			continue
+		}
+		for _, item := range c.Items {
+			add(PhraseHint{Text: item})
+		}
+	}
+	return expanded
+}
+
+// expandReferences replaces every class reference in text with every item
+// of the class, in all combinations.
+func expandReferences(text string, classes map[string]CustomClass) []string {
+	loc := classReference.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return []string{text}
+	}
+
+	var texts []string
+	prefix, name := text[:loc[0]], text[loc[2]:loc[3]]
+	rests := expandReferences(text[loc[1]:], classes)
+	for _, item := range classes[name].Items {
+		for _, rest := range rests {
+			texts = append(texts, prefix+item+rest)
+		}
+	}
+	return texts
+}
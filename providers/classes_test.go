@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassReferences(t *testing.T) {
+	assert.Equal(t, []string{"from", "to"}, ClassReferences("fly from ${from} to ${to}"))
+	assert.Empty(t, ClassReferences("no classes here, just $5"))
+}
+
+func TestExpandClasses(t *testing.T) {
+	classes := []CustomClass{
+		{Name: "cities", Items: []string{"Paris", "Berlin"}},
+		{Name: "drugs", Items: []string{"ibuprofen", "paracetamol"}},
+	}
+
+	tests := []struct {
+		name     string
+		phrases  []PhraseHint
+		classes  []CustomClass
+		expected []PhraseHint
+	}{
+		{
+			name:     "plain phrases are kept",
+			phrases:  []PhraseHint{{Text: "Acme Cloud", Boost: 10}},
+			expected: []PhraseHint{{Text: "Acme Cloud", Boost: 10}},
+		},
+		{
+			name:    "every combination of items",
+			phrases: []PhraseHint{{Text: "fly from ${cities} to ${cities}", Boost: 5}},
+			classes: classes[:1],
+			expected: []PhraseHint{
+				{Text: "fly from Paris to Paris", Boost: 5},
+				{Text: "fly from Paris to Berlin", Boost: 5},
+				{Text: "fly from Berlin to Paris", Boost: 5},
+				{Text: "fly from Berlin to Berlin", Boost: 5},
+			},
+		},
+		{
+			name:    "unreferenced classes add their items",
+			phrases: []PhraseHint{{Text: "${cities}"}},
+			classes: classes,
+			expected: []PhraseHint{
+				{Text: "Paris"},
+				{Text: "Berlin"},
+				{Text: "ibuprofen"},
+				{Text: "paracetamol"},
+			},
+		},
+		{
+			name:     "unknown classes drop the phrase",
+			phrases:  []PhraseHint{{Text: "take ${pills}"}, {Text: "Paris"}},
+			expected: []PhraseHint{{Text: "Paris"}},
+		},
+		{
+			name:     "duplicates are kept once",
+			phrases:  []PhraseHint{{Text: "Paris", Boost: 10}, {Text: "${cities}"}},
+			classes:  classes[:1],
+			expected: []PhraseHint{{Text: "Paris", Boost: 10}, {Text: "Berlin"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ExpandClasses(tt.phrases, tt.classes))
+		})
+	}
+}
+
+func TestExpandClasses_TooManyCombinations(t *testing.T) {
+	var items []string
+	for i := range maxClassExpansions + 1 {
+		items = append(items, fmt.Sprintf("item %d", i))
+	}
+	classes := []CustomClass{{Name: "big", Items: items}}
+
+	// The items are used on their own instead
+	expanded := ExpandClasses([]PhraseHint{{Text: "buy ${big}", Boost: 3}}, classes)
+	assert.Len(t, expanded, len(items))
+	assert.Equal(t, PhraseHint{Text: "item 0", Boost: 3}, expanded[0])
+}
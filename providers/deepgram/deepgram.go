@@ -1,11 +1,14 @@
 package deepgram
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	api "github.com/deepgram/deepgram-go-sdk/v3/pkg/api/listen/v1/websocket/interfaces"
@@ -17,13 +20,29 @@ import (
 
 const providerName = "deepgram"
 
+// redactions maps the kinds of personal data Deepgram can redact onto its
+// own names for them. Deepgram has no redaction of phone numbers or emails.
+var redactions = map[providers.PIIType]string{
+	providers.PIICreditCard: "pci",
+	providers.PIISSN:        "ssn",
+}
+
+// defaultModel is the model used when the session config names none.
+const defaultModel = "nova-3"
+
 // dgWriter is a local interface that wraps the methods we need
 // from listenv1ws.WSCallback to enable easier testing
 type dgWriter interface {
 	io.Writer
+	Finalize() error
+	WriteJSON(payload interface{}) error
 	Stop()
 }
 
+// closeStreamMessage asks Deepgram to send the results of the audio it has,
+// and then to close the stream.
+var closeStreamMessage = map[string]string{"type": "CloseStream"}
+
 // ChannelHandler implements the LiveMessageChan interface for receiving Deepgram messages
 type ChannelHandler struct {
 	openChan          chan *api.OpenResponse
@@ -93,11 +112,17 @@ func (ch *ChannelHandler) GetUnhandled() []*chan *[]byte {
 // Provider implements the providers.Provider interface for Deepgram's speech-to-text API.
 type Provider struct {
 	apiKey string
+	// host replaces Deepgram's default host, when set.
+	host string
 }
 
+// initSDK initializes the Deepgram SDK. It registers its logging flags, so
+// doing it twice panics, and every provider instance shares it.
+var initSDK = sync.OnceFunc(client.InitWithDefault)
+
 // NewProvider creates a new Deepgram provider with the given API key.
 func NewProvider(apiKey string) *Provider {
-	client.InitWithDefault()
+	initSDK()
 
 	return &Provider{
 		apiKey: apiKey,
@@ -109,26 +134,41 @@ func (p *Provider) Name() string {
 	return providerName
 }
 
+func init() {
+	providers.Register(providerName, newFromSettings)
+}
+
+// newFromSettings creates a provider for an instance declared in a config
+// file. The credentials are an API key, in DEEPGRAM_API_KEY by default.
+// Deepgram has no regions to pick, but dedicated and self-hosted
+// deployments have an endpoint of their own.
+func newFromSettings(_ context.Context, settings providers.Settings) (providers.Provider, io.Closer, error) {
+	if settings.Region != "" && settings.Endpoint == "" {
+		return nil, nil, fmt.Errorf("deepgram has no region %q, set the endpoint instead", settings.Region)
+	}
+	apiKey, err := settings.Credentials.Secret("DEEPGRAM_API_KEY")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := NewProvider(apiKey)
+	provider.host = settings.Endpoint
+	return provider, nil, nil
+}
+
 // NewSession creates a new Deepgram transcription session.
 func (p *Provider) NewSession(ctx context.Context, config providers.SessionConfig) (providers.Session, error) {
 	// Configure Deepgram client options
 	cOptions := &interfaces.ClientOptions{
 		APIKey:          p.apiKey,
+		Host:            p.host,
 		EnableKeepAlive: true,
 	}
 
 	// Configure transcription options
-	tOptions := &interfaces.LiveTranscriptionOptions{
-		Model:          "nova-3",
-		Keyterm:        []string{"deepgram"},
-		Language:       config.LanguageCode,
-		Punctuate:      true,
-		Encoding:       "linear16",
-		Channels:       1,
-		SampleRate:     config.SampleRate,
-		VadEvents:      true,
-		InterimResults: config.InterimResults,
-		UtteranceEndMs: "1000",
+	tOptions, err := liveTranscriptionOptions(config)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create channel handler
@@ -145,6 +185,7 @@ func (p *Provider) NewSession(ctx context.Context, config providers.SessionConfi
 		ctx:            ctx,
 		client:         dgClient,
 		channelHandler: channelHandler,
+		multichannel:   tOptions.Multichannel,
 	}
 
 	// Connect to Deepgram
@@ -155,11 +196,95 @@ func (p *Provider) NewSession(ctx context.Context, config providers.SessionConfi
 	return session, nil
 }
 
+// liveTranscriptionOptions converts the provider-agnostic session config
+// into Deepgram's live transcription options.
+func liveTranscriptionOptions(config providers.SessionConfig) (*interfaces.LiveTranscriptionOptions, error) {
+	tOptions := &interfaces.LiveTranscriptionOptions{
+		Model:          cmp.Or(config.Models[providerName], defaultModel),
+		Language:       config.LanguageCode,
+		Punctuate:      true,
+		VadEvents:      true,
+		InterimResults: config.InterimResults,
+		Alternatives:   config.MaxAlternatives,
+		UtteranceEndMs: "1000",
+		// Deepgram works out the number of speakers on its own.
+		Diarize: config.Diarization.Enabled,
+	}
+
+	tOptions.ProfanityFilter = config.Redaction.Profanity
+	for _, pii := range config.Redaction.PII {
+		if redact, ok := redactions[pii]; ok {
+			tOptions.Redact = append(tOptions.Redact, redact)
+		}
+	}
+
+	// Deepgram can't choose between given languages, but its multilingual
+	// mode transcribes a set of common languages in any combination.
+	if len(config.AlternativeLanguageCodes) > 0 {
+		if !strings.HasPrefix(tOptions.Model, "nova-2") && !strings.HasPrefix(tOptions.Model, "nova-3") {
+			return nil, &providers.UnsupportedConfigError{
+				Provider: providerName,
+				Encoding: config.EffectiveEncoding(),
+				Reason:   fmt.Sprintf("alternative languages need a Nova-2 or Nova-3 model, not %s", tOptions.Model),
+			}
+		}
+		tOptions.Language = "multi"
+	}
+
+	// Deepgram has no custom classes, so they are expanded into phrases.
+	setPhrases(tOptions, providers.ExpandClasses(config.Phrases, config.CustomClasses))
+
+	enc := config.EffectiveEncoding()
+	switch enc {
+	case providers.EncodingLinear16, providers.EncodingMulaw:
+		// Raw audio needs its encoding, sample rate and channels spelled out.
+		if config.SampleRate <= 0 {
+			return nil, &providers.UnsupportedConfigError{
+				Provider: providerName,
+				Encoding: enc,
+				Reason:   "raw audio needs a sample rate",
+			}
+		}
+		tOptions.Encoding = string(enc)
+		tOptions.SampleRate = config.SampleRate
+		tOptions.Channels = config.EffectiveChannels()
+		tOptions.Multichannel = config.SeparateChannels && tOptions.Channels > 1
+	case providers.EncodingFLAC, providers.EncodingOggOpus, providers.EncodingWebmOpus:
+		// Containerized audio describes itself. Deepgram asks for
+		// encoding and sample rate to be left out in that case.
+		tOptions.Multichannel = config.SeparateChannels
+	default:
+		return nil, &providers.UnsupportedEncodingError{Provider: providerName, Encoding: enc}
+	}
+
+	return tOptions, nil
+}
+
+// setPhrases passes phrase hints on as keyterms for Nova-3 models, which
+// support whole phrases but no boost, or as keywords with an intensifier
+// for older models.
+func setPhrases(tOptions *interfaces.LiveTranscriptionOptions, phrases []providers.PhraseHint) {
+	for _, p := range phrases {
+		switch {
+		case strings.HasPrefix(tOptions.Model, "nova-3"):
+			tOptions.Keyterm = append(tOptions.Keyterm, p.Text)
+		case p.Boost != 0:
+			tOptions.Keywords = append(tOptions.Keywords, p.Text+":"+strconv.FormatFloat(float64(p.Boost), 'g', -1, 32))
+		default:
+			tOptions.Keywords = append(tOptions.Keywords, p.Text)
+		}
+	}
+}
+
 // Session implements the providers.Session interface for Deepgram's speech-to-text API.
 type Session struct {
 	ctx            context.Context
 	client         dgWriter
 	channelHandler *ChannelHandler
+	multichannel   bool
+	// closed is set once Deepgram has closed the stream. The results it
+	// sent before are still returned, only touched by ReceiveTranscription.
+	closed bool
 }
 
 // SendAudio sends audio data to the Deepgram stream.
@@ -172,6 +297,21 @@ func (s *Session) SendAudio(audioData []byte) error {
 // It blocks until a final result is available or an error occurs.
 func (s *Session) ReceiveTranscription() (providers.TranscriptionResult, error) {
 	for {
+		if s.closed {
+			select {
+			case msg := <-s.channelHandler.messageChan:
+				if msg == nil {
+					continue
+				}
+				if result := s.processMessage(msg); result != nil {
+					return *result, nil
+				}
+				continue
+			default:
+				return providers.TranscriptionResult{}, io.EOF
+			}
+		}
+
 		select {
 		case msg := <-s.channelHandler.messageChan:
 			if msg == nil {
@@ -186,8 +326,9 @@ func (s *Session) ReceiveTranscription() (providers.TranscriptionResult, error)
 				return providers.TranscriptionResult{}, fmt.Errorf("%s", err)
 			}
 		case <-s.channelHandler.closeChan:
-			// Connection closed by Deepgram
-			return providers.TranscriptionResult{}, io.EOF
+			// Connection closed by Deepgram. The results it sent
+			// before closing may still be waiting.
+			s.closed = true
 		case <-s.channelHandler.openChan:
 			// Consume open events (no action needed)
 		case <-s.channelHandler.metadataChan:
@@ -225,9 +366,18 @@ func (s *Session) processMessage(msg *api.MessageResponse) *providers.Transcript
 		IsFinal:      msg.IsFinal,
 		Confidence:   float32(alternative.Confidence),
 		ProviderName: providerName,
+		LanguageCode: detectedLanguage(alternative),
+		Alternatives: convertAlternatives(msg.Channel.Alternatives[1:]),
 		ReceivedAt:   time.Now(),
 	}
 
+	// Deepgram numbers channels from 0, as [index, total].
+	if s.multichannel && len(msg.ChannelIndex) > 0 {
+		result.Channel = msg.ChannelIndex[0] + 1
+	}
+	result.Words = convertWords(alternative.Words)
+	result.Speaker = providers.DominantSpeaker(result.Words)
+
 	// Only return final results to match our interface expectation
 	if result.IsFinal {
 		return result
@@ -235,6 +385,84 @@ func (s *Session) processMessage(msg *api.MessageResponse) *providers.Transcript
 	return nil
 }
 
+// convertAlternatives converts the runner-up transcripts of a result.
+func convertAlternatives(alternatives []api.Alternative) []providers.Alternative {
+	if len(alternatives) == 0 {
+		return nil
+	}
+
+	out := make([]providers.Alternative, 0, len(alternatives))
+	for _, alt := range alternatives {
+		out = append(out, providers.Alternative{
+			Text:       strings.TrimSpace(alt.Transcript),
+			Confidence: float32(alt.Confidence),
+		})
+	}
+	return out
+}
+
+// detectedLanguage returns the language of a multilingual transcript.
+// Deepgram lists the languages of the transcript, most spoken first, and
+// tags every word. Outside of multilingual mode there are neither.
+func detectedLanguage(alternative api.Alternative) string {
+	if len(alternative.Languages) > 0 {
+		return alternative.Languages[0]
+	}
+	counts := make(map[string]int)
+	best := ""
+	for _, w := range alternative.Words {
+		if w.Language == "" {
+			continue
+		}
+		counts[w.Language]++
+		if best == "" || counts[w.Language] > counts[best] {
+			best = w.Language
+		}
+	}
+	return best
+}
+
+// convertWords converts Deepgram's words into provider-agnostic words.
+func convertWords(words []api.Word) []providers.Word {
+	if len(words) == 0 {
+		return nil
+	}
+
+	out := make([]providers.Word, 0, len(words))
+	for _, w := range words {
+		text := w.PunctuatedWord
+		if text == "" {
+			text = w.Word
+		}
+		word := providers.Word{
+			Text:       text,
+			Start:      secondsToDuration(w.Start),
+			End:        secondsToDuration(w.End),
+			Confidence: float32(w.Confidence),
+		}
+		// Speakers are numbered from 0, and only present with diarization.
+		if w.Speaker != nil {
+			word.Speaker = *w.Speaker + 1
+		}
+		out = append(out, word)
+	}
+	return out
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Finish asks Deepgram to finalize the audio it has, and to close the stream
+// once it has sent the results. ReceiveTranscription returns io.EOF once it
+// has returned them.
+func (s *Session) Finish() error {
+	if err := s.client.Finalize(); err != nil {
+		return err
+	}
+	return s.client.WriteJSON(closeStreamMessage)
+}
+
 // Close closes the Deepgram session.
 func (s *Session) Close() error {
 	if s.client != nil {
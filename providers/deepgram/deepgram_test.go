@@ -8,9 +8,11 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/agnivade/stt_challenge/providers"
 	api "github.com/deepgram/deepgram-go-sdk/v3/pkg/api/listen/v1/websocket/interfaces"
+	interfaces "github.com/deepgram/deepgram-go-sdk/v3/pkg/client/interfaces"
 )
 
 // createTestSession creates a minimal session for testing
@@ -248,6 +250,48 @@ func TestSession_ReceiveTranscription_CloseChannel(t *testing.T) {
 	assert.ErrorIs(t, err, io.EOF)
 }
 
+func TestSession_ReceiveTranscription_ResultsBeforeClose(t *testing.T) {
+	session, channelHandler := createTestSession()
+
+	// Deepgram sends the last results, then closes the stream
+	channelHandler.messageChan <- &api.MessageResponse{
+		IsFinal: true,
+		Channel: api.Channel{Alternatives: []api.Alternative{{Transcript: "last words"}}},
+	}
+	channelHandler.closeChan <- &api.CloseResponse{}
+
+	result, err := session.ReceiveTranscription()
+	require.NoError(t, err)
+	assert.Equal(t, "last words", result.Text)
+	_, err = session.ReceiveTranscription()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSession_Finish(t *testing.T) {
+	mockClient := newMockdgWriter(t)
+	mockClient.EXPECT().Finalize().Return(nil).Once()
+	mockClient.EXPECT().WriteJSON(closeStreamMessage).Return(nil).Once()
+
+	session := &Session{
+		ctx:            context.Background(),
+		client:         mockClient,
+		channelHandler: NewChannelHandler(),
+	}
+	assert.NoError(t, session.Finish())
+}
+
+func TestSession_Finish_FinalizeError(t *testing.T) {
+	mockClient := newMockdgWriter(t)
+	mockClient.EXPECT().Finalize().Return(errors.New("connection lost")).Once()
+
+	session := &Session{
+		ctx:            context.Background(),
+		client:         mockClient,
+		channelHandler: NewChannelHandler(),
+	}
+	assert.EqualError(t, session.Finish(), "connection lost")
+}
+
 func TestSession_ReceiveTranscription_ContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	channelHandler := NewChannelHandler()
@@ -464,3 +508,308 @@ func TestChannelHandler_InterfaceMethods(t *testing.T) {
 		assert.Equal(t, &handler.unhandledChan, channels[0])
 	})
 }
+
+func TestLiveTranscriptionOptions(t *testing.T) {
+	// Raw audio needs the encoding, sample rate and channels
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{SampleRate: 16000, LanguageCode: "en-US"})
+	assert.NoError(t, err)
+	assert.Equal(t, "linear16", opts.Encoding)
+	assert.Equal(t, 16000, opts.SampleRate)
+	assert.Equal(t, 1, opts.Channels)
+	assert.Equal(t, "en-US", opts.Language)
+
+	opts, err = liveTranscriptionOptions(providers.SessionConfig{Encoding: providers.EncodingMulaw, SampleRate: 8000, Channels: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "mulaw", opts.Encoding)
+	assert.Equal(t, 8000, opts.SampleRate)
+	assert.Equal(t, 2, opts.Channels)
+
+	// Containers leave them out
+	for _, enc := range []providers.AudioEncoding{providers.EncodingFLAC, providers.EncodingOggOpus, providers.EncodingWebmOpus} {
+		opts, err = liveTranscriptionOptions(providers.SessionConfig{Encoding: enc, SampleRate: 16000, Channels: 2})
+		assert.NoError(t, err, enc)
+		assert.Empty(t, opts.Encoding, enc)
+		assert.Zero(t, opts.SampleRate, enc)
+		assert.Zero(t, opts.Channels, enc)
+	}
+
+	_, err = liveTranscriptionOptions(providers.SessionConfig{Encoding: "mp3"})
+	var encErr *providers.UnsupportedEncodingError
+	assert.ErrorAs(t, err, &encErr)
+	assert.EqualError(t, err, `deepgram: unsupported encoding "mp3"`)
+
+	_, err = liveTranscriptionOptions(providers.SessionConfig{Encoding: providers.EncodingMulaw})
+	var cfgErr *providers.UnsupportedConfigError
+	assert.ErrorAs(t, err, &cfgErr)
+	assert.EqualError(t, err, "deepgram: unsupported config for mulaw: raw audio needs a sample rate")
+}
+
+func TestSession_ProcessMessage_Multichannel(t *testing.T) {
+	msg := &api.MessageResponse{
+		IsFinal:      true,
+		ChannelIndex: []int{1, 2},
+		Channel: api.Channel{
+			Alternatives: []api.Alternative{
+				{Transcript: "how can I help", Confidence: 0.9},
+			},
+		},
+	}
+
+	// Channel indexes are 0-based, and only meaningful with multichannel
+	session, _ := createTestSession()
+	session.multichannel = true
+	result := session.processMessage(msg)
+	assert.NotNil(t, result)
+	assert.Equal(t, 2, result.Channel)
+
+	session, _ = createTestSession()
+	result = session.processMessage(msg)
+	assert.NotNil(t, result)
+	assert.Zero(t, result.Channel)
+}
+
+func TestLiveTranscriptionOptions_Multichannel(t *testing.T) {
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{SampleRate: 8000, Channels: 2, SeparateChannels: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, opts.Channels)
+	assert.True(t, opts.Multichannel)
+
+	// Nothing to separate in mono audio
+	opts, err = liveTranscriptionOptions(providers.SessionConfig{SampleRate: 8000, SeparateChannels: true})
+	assert.NoError(t, err)
+	assert.False(t, opts.Multichannel)
+}
+
+func TestSession_ProcessMessage_Diarization(t *testing.T) {
+	speaker := func(n int) *int { return &n }
+
+	msg := &api.MessageResponse{
+		IsFinal: true,
+		Channel: api.Channel{
+			Alternatives: []api.Alternative{
+				{
+					Transcript: "hello there hi",
+					Confidence: 0.9,
+					Words: []api.Word{
+						{Word: "hello", PunctuatedWord: "Hello", Start: 0, End: 0.5, Confidence: 0.9, Speaker: speaker(0)},
+						{Word: "there", Start: 0.5, End: 1, Confidence: 0.8, Speaker: speaker(0)},
+						{Word: "hi", PunctuatedWord: "hi.", Start: 2, End: 2.5, Confidence: 0.7, Speaker: speaker(1)},
+					},
+				},
+			},
+		},
+	}
+
+	session, _ := createTestSession()
+	result := session.processMessage(msg)
+	assert.NotNil(t, result)
+
+	// Speakers are shifted to be 1-based, and punctuated words preferred
+	assert.Equal(t, 1, result.Speaker)
+	assert.Equal(t, []providers.Word{
+		{Text: "Hello", Start: 0, End: 500 * time.Millisecond, Confidence: 0.9, Speaker: 1},
+		{Text: "there", Start: 500 * time.Millisecond, End: time.Second, Confidence: 0.8, Speaker: 1},
+		{Text: "hi.", Start: 2 * time.Second, End: 2500 * time.Millisecond, Confidence: 0.7, Speaker: 2},
+	}, result.Words)
+}
+
+func TestLiveTranscriptionOptions_Diarization(t *testing.T) {
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{
+		SampleRate:  16000,
+		Diarization: providers.DiarizationConfig{Enabled: true},
+	})
+	assert.NoError(t, err)
+	assert.True(t, opts.Diarize)
+}
+
+func TestLiveTranscriptionOptions_Phrases(t *testing.T) {
+	phrases := []providers.PhraseHint{
+		{Text: "Acme Cloud", Boost: 10},
+		{Text: "Jane"},
+	}
+
+	// Nova-3 takes whole phrases as keyterms, without boosts
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{SampleRate: 16000, Phrases: phrases})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Acme Cloud", "Jane"}, opts.Keyterm)
+	assert.Empty(t, opts.Keywords)
+
+	// No phrases, no keyterms
+	opts, err = liveTranscriptionOptions(providers.SessionConfig{SampleRate: 16000})
+	assert.NoError(t, err)
+	assert.Empty(t, opts.Keyterm)
+
+	// Older models take keywords with an intensifier
+	opts = &interfaces.LiveTranscriptionOptions{Model: "nova-2"}
+	setPhrases(opts, phrases)
+	assert.Equal(t, []string{"Acme Cloud:10", "Jane"}, opts.Keywords)
+	assert.Empty(t, opts.Keyterm)
+}
+
+func TestLiveTranscriptionOptions_CustomClasses(t *testing.T) {
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{
+		SampleRate: 16000,
+		Phrases:    []providers.PhraseHint{{Text: "order ${products}", Boost: 5}},
+		CustomClasses: []providers.CustomClass{
+			{Name: "products", Items: []string{"Acme Cloud", "Acme Edge"}},
+			{Name: "drugs", Items: []string{"ibuprofen"}},
+		},
+	})
+	assert.NoError(t, err)
+	// Classes are expanded, and unreferenced ones become phrases of their own
+	assert.Equal(t, []string{"order Acme Cloud", "order Acme Edge", "ibuprofen"}, opts.Keyterm)
+}
+
+func TestLiveTranscriptionOptions_Models(t *testing.T) {
+	// Nova-3 by default
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{SampleRate: 16000})
+	assert.NoError(t, err)
+	assert.Equal(t, "nova-3", opts.Model)
+
+	config := providers.SessionConfig{
+		SampleRate: 16000,
+		Models:     map[string]string{"google": "phone_call", "deepgram": "nova-2-phonecall"},
+		Phrases:    []providers.PhraseHint{{Text: "Acme Cloud", Boost: 10}},
+	}
+	opts, err = liveTranscriptionOptions(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "nova-2-phonecall", opts.Model)
+	// Older models take keywords instead of keyterms
+	assert.Equal(t, []string{"Acme Cloud:10"}, opts.Keywords)
+	assert.Empty(t, opts.Keyterm)
+}
+
+func TestLiveTranscriptionOptions_AlternativeLanguages(t *testing.T) {
+	config := providers.SessionConfig{
+		SampleRate:               16000,
+		LanguageCode:             "en",
+		AlternativeLanguageCodes: []string{"hi"},
+	}
+	opts, err := liveTranscriptionOptions(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "multi", opts.Language)
+
+	// Older models have no multilingual mode
+	config.Models = map[string]string{"deepgram": "enhanced"}
+	_, err = liveTranscriptionOptions(config)
+	var configErr *providers.UnsupportedConfigError
+	assert.ErrorAs(t, err, &configErr)
+}
+
+func TestSession_ProcessMessage_Language(t *testing.T) {
+	tests := []struct {
+		name        string
+		alternative api.Alternative
+		expected    string
+	}{
+		{
+			name: "languages of the transcript",
+			alternative: api.Alternative{
+				Transcript: "hello namaste",
+				Languages:  []string{"hi", "en"},
+			},
+			expected: "hi",
+		},
+		{
+			name: "languages of the words",
+			alternative: api.Alternative{
+				Transcript: "hello namaste ji",
+				Words: []api.Word{
+					{Word: "hello", Language: "en"},
+					{Word: "namaste", Language: "hi"},
+					{Word: "ji", Language: "hi"},
+				},
+			},
+			expected: "hi",
+		},
+		{
+			name:        "not multilingual",
+			alternative: api.Alternative{Transcript: "hello"},
+			expected:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, _ := createTestSession()
+			result := session.processMessage(&api.MessageResponse{
+				IsFinal: true,
+				Channel: api.Channel{Alternatives: []api.Alternative{tt.alternative}},
+			})
+			assert.NotNil(t, result)
+			assert.Equal(t, tt.expected, result.LanguageCode)
+		})
+	}
+}
+
+func TestLiveTranscriptionOptions_Redaction(t *testing.T) {
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{
+		SampleRate: 16000,
+		Redaction: providers.RedactionConfig{
+			Profanity: true,
+			PII:       []providers.PIIType{providers.PIICreditCard, providers.PIIEmail, providers.PIISSN},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, opts.ProfanityFilter)
+	// Emails are left to the server
+	assert.Equal(t, []string{"pci", "ssn"}, opts.Redact)
+}
+
+func TestLiveTranscriptionOptions_Alternatives(t *testing.T) {
+	opts, err := liveTranscriptionOptions(providers.SessionConfig{SampleRate: 16000, MaxAlternatives: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, opts.Alternatives)
+}
+
+func TestSession_ProcessMessage_Alternatives(t *testing.T) {
+	msg := &api.MessageResponse{
+		IsFinal: true,
+		Channel: api.Channel{
+			Alternatives: []api.Alternative{
+				{Transcript: "recognize speech", Confidence: 0.9},
+				{Transcript: " wreck a nice beach ", Confidence: 0.6},
+			},
+		},
+	}
+
+	session, _ := createTestSession()
+	result := session.processMessage(msg)
+	assert.NotNil(t, result)
+	assert.Equal(t, "recognize speech", result.Text)
+	assert.Equal(t, []providers.Alternative{{Text: "wreck a nice beach", Confidence: 0.6}}, result.Alternatives)
+
+	// A single transcript has no alternatives
+	msg.Channel.Alternatives = msg.Channel.Alternatives[:1]
+	result = session.processMessage(msg)
+	assert.NotNil(t, result)
+	assert.Nil(t, result.Alternatives)
+}
+
+func TestNewFromSettings(t *testing.T) {
+	t.Setenv("DEEPGRAM_API_KEY", "test-key")
+
+	p, closer, err := newFromSettings(context.Background(), providers.Settings{Type: "deepgram", Endpoint: "dg.example.com"})
+	require.NoError(t, err)
+	assert.Nil(t, closer)
+	assert.Equal(t, "test-key", p.(*Provider).apiKey)
+	assert.Equal(t, "dg.example.com", p.(*Provider).host)
+
+	_, _, err = newFromSettings(context.Background(), providers.Settings{Type: "deepgram", Region: "eu"})
+	assert.EqualError(t, err, `deepgram has no region "eu", set the endpoint instead`)
+
+	t.Setenv("DEEPGRAM_API_KEY", "")
+	_, _, err = newFromSettings(context.Background(), providers.Settings{Type: "deepgram"})
+	assert.EqualError(t, err, "DEEPGRAM_API_KEY environment variable is required")
+}
+
+func TestNewFromSettings_SeveralInstances(t *testing.T) {
+	t.Setenv("DEEPGRAM_API_KEY", "test-key")
+
+	// Every instance shares the SDK, initialized once
+	for _, endpoint := range []string{"", "dg.example.com"} {
+		p, _, err := newFromSettings(context.Background(), providers.Settings{Type: "deepgram", Endpoint: endpoint})
+		require.NoError(t, err)
+		assert.Equal(t, endpoint, p.(*Provider).host)
+	}
+}
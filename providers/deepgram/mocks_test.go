@@ -35,6 +35,50 @@ func (_m *mockdgWriter) EXPECT() *mockdgWriter_Expecter {
 	return &mockdgWriter_Expecter{mock: &_m.Mock}
 }
 
+// Finalize provides a mock function for the type mockdgWriter
+func (_mock *mockdgWriter) Finalize() error {
+	ret := _mock.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Finalize")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func() error); ok {
+		r0 = returnFunc()
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// mockdgWriter_Finalize_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Finalize'
+type mockdgWriter_Finalize_Call struct {
+	*mock.Call
+}
+
+// Finalize is a helper method to define mock.On call
+func (_e *mockdgWriter_Expecter) Finalize() *mockdgWriter_Finalize_Call {
+	return &mockdgWriter_Finalize_Call{Call: _e.mock.On("Finalize")}
+}
+
+func (_c *mockdgWriter_Finalize_Call) Run(run func()) *mockdgWriter_Finalize_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *mockdgWriter_Finalize_Call) Return(err error) *mockdgWriter_Finalize_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *mockdgWriter_Finalize_Call) RunAndReturn(run func() error) *mockdgWriter_Finalize_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Stop provides a mock function for the type mockdgWriter
 func (_mock *mockdgWriter) Stop() {
 	_mock.Called()
@@ -127,3 +171,54 @@ func (_c *mockdgWriter_Write_Call) RunAndReturn(run func(p []byte) (int, error))
 	_c.Call.Return(run)
 	return _c
 }
+
+// WriteJSON provides a mock function for the type mockdgWriter
+func (_mock *mockdgWriter) WriteJSON(payload interface{}) error {
+	ret := _mock.Called(payload)
+
+	if len(ret) == 0 {
+		panic("no return value specified for WriteJSON")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(interface{}) error); ok {
+		r0 = returnFunc(payload)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// mockdgWriter_WriteJSON_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'WriteJSON'
+type mockdgWriter_WriteJSON_Call struct {
+	*mock.Call
+}
+
+// WriteJSON is a helper method to define mock.On call
+//   - payload interface{}
+func (_e *mockdgWriter_Expecter) WriteJSON(payload interface{}) *mockdgWriter_WriteJSON_Call {
+	return &mockdgWriter_WriteJSON_Call{Call: _e.mock.On("WriteJSON", payload)}
+}
+
+func (_c *mockdgWriter_WriteJSON_Call) Run(run func(payload interface{})) *mockdgWriter_WriteJSON_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 interface{}
+		if args[0] != nil {
+			arg0 = args[0].(interface{})
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *mockdgWriter_WriteJSON_Call) Return(err error) *mockdgWriter_WriteJSON_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *mockdgWriter_WriteJSON_Call) RunAndReturn(run func(payload interface{}) error) *mockdgWriter_WriteJSON_Call {
+	_c.Call.Return(run)
+	return _c
+}
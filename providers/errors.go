@@ -0,0 +1,35 @@
+package providers
+
+import "fmt"
+
+// UnsupportedEncodingError is returned by Provider.NewSession when the
+// provider cannot transcribe audio in the requested encoding.
+type UnsupportedEncodingError struct {
+	// Provider is the name of the provider rejecting the encoding.
+	Provider string
+
+	// Encoding is the requested encoding.
+	Encoding AudioEncoding
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("%s: unsupported encoding %q", e.Provider, e.Encoding)
+}
+
+// UnsupportedConfigError is returned by Provider.NewSession when the
+// encoding is supported, but not in combination with the other options
+// in the SessionConfig, like its sample rate or channel count.
+type UnsupportedConfigError struct {
+	// Provider is the name of the provider rejecting the config.
+	Provider string
+
+	// Encoding is the requested encoding.
+	Encoding AudioEncoding
+
+	// Reason describes what is wrong with the combination.
+	Reason string
+}
+
+func (e *UnsupportedConfigError) Error() string {
+	return fmt.Sprintf("%s: unsupported config for %s: %s", e.Provider, e.Encoding, e.Reason)
+}
@@ -3,11 +3,16 @@ package google
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"os"
+	"slices"
+	"strconv"
 	"time"
 
 	speech "cloud.google.com/go/speech/apiv1"
 	"cloud.google.com/go/speech/apiv1/speechpb"
+	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -16,6 +21,19 @@ import (
 
 const providerName = "google"
 
+// maxChannels is the most channels Google accepts in a single stream.
+const maxChannels = 8
+
+// maxAlternativeLanguages is the most alternative languages Google accepts.
+const maxAlternativeLanguages = 3
+
+// enhancedModels are the models Google has an enhanced version of, which
+// is more accurate at no extra cost.
+var enhancedModels = []string{"phone_call", "video"}
+
+// opusSampleRates are the sample rates Google accepts for Opus audio.
+var opusSampleRates = []int{8000, 12000, 16000, 24000, 48000}
+
 // streamingRecognizeClient is a local interface that wraps the methods we need
 // from speechpb.Speech_StreamingRecognizeClient to enable easier testing
 type streamingRecognizeClient interface {
@@ -41,8 +59,51 @@ func (p *Provider) Name() string {
 	return providerName
 }
 
+func init() {
+	providers.Register(providerName, newFromSettings)
+}
+
+// newFromSettings creates a provider for an instance declared in a config
+// file. The credentials are a service account file, or an environment
+// variable holding its path. Without either, Google's default credentials
+// apply, as found through GOOGLE_APPLICATION_CREDENTIALS.
+func newFromSettings(ctx context.Context, settings providers.Settings) (providers.Provider, io.Closer, error) {
+	var opts []option.ClientOption
+	switch creds := settings.Credentials; {
+	case creds.File != "":
+		opts = append(opts, option.WithCredentialsFile(creds.File))
+	case creds.Env != "":
+		path := os.Getenv(creds.Env)
+		if path == "" {
+			return nil, nil, fmt.Errorf("%s environment variable is required", creds.Env)
+		}
+		opts = append(opts, option.WithCredentialsFile(path))
+	case os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "":
+		return nil, nil, errors.New("GOOGLE_APPLICATION_CREDENTIALS environment variable is required")
+	}
+
+	// Regional endpoints keep the audio in the region, like "eu".
+	switch {
+	case settings.Endpoint != "":
+		opts = append(opts, option.WithEndpoint(settings.Endpoint))
+	case settings.Region != "":
+		opts = append(opts, option.WithEndpoint(settings.Region+"-speech.googleapis.com:443"))
+	}
+
+	client, err := speech.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Google Speech client: %w", err)
+	}
+	return NewProvider(client), client, nil
+}
+
 // NewSession creates a new Google Speech transcription session.
 func (p *Provider) NewSession(ctx context.Context, config providers.SessionConfig) (providers.Session, error) {
+	streamingConfig, err := streamingRecognitionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
 	stream, err := p.client.StreamingRecognize(ctx)
 	if err != nil {
 		return nil, err
@@ -51,14 +112,7 @@ func (p *Provider) NewSession(ctx context.Context, config providers.SessionConfi
 	// Send initial configuration
 	req := &speechpb.StreamingRecognizeRequest{
 		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
-			StreamingConfig: &speechpb.StreamingRecognitionConfig{
-				Config: &speechpb.RecognitionConfig{
-					Encoding:        speechpb.RecognitionConfig_LINEAR16,
-					SampleRateHertz: int32(config.SampleRate),
-					LanguageCode:    config.LanguageCode,
-				},
-				InterimResults: config.InterimResults,
-			},
+			StreamingConfig: streamingConfig,
 		},
 	}
 
@@ -68,15 +122,172 @@ func (p *Provider) NewSession(ctx context.Context, config providers.SessionConfi
 	}
 
 	return &Session{
-		stream: stream,
-		ctx:    ctx,
+		stream:           stream,
+		ctx:              ctx,
+		separateChannels: streamingConfig.Config.EnableSeparateRecognitionPerChannel,
+		diarization:      streamingConfig.Config.DiarizationConfig != nil,
 	}, nil
 }
 
+// streamingRecognitionConfig converts the provider-agnostic session config
+// into Google's streaming recognition config. Combinations Google would
+// reject are caught here, before a stream is opened.
+func streamingRecognitionConfig(config providers.SessionConfig) (*speechpb.StreamingRecognitionConfig, error) {
+	enc := config.EffectiveEncoding()
+	channels := config.EffectiveChannels()
+
+	unsupported := func(reason string, args ...any) error {
+		return &providers.UnsupportedConfigError{
+			Provider: providerName,
+			Encoding: enc,
+			Reason:   fmt.Sprintf(reason, args...),
+		}
+	}
+
+	var encoding speechpb.RecognitionConfig_AudioEncoding
+	switch enc {
+	case providers.EncodingLinear16:
+		encoding = speechpb.RecognitionConfig_LINEAR16
+	case providers.EncodingFLAC:
+		encoding = speechpb.RecognitionConfig_FLAC
+	case providers.EncodingMulaw:
+		encoding = speechpb.RecognitionConfig_MULAW
+	case providers.EncodingOggOpus:
+		encoding = speechpb.RecognitionConfig_OGG_OPUS
+	case providers.EncodingWebmOpus:
+		encoding = speechpb.RecognitionConfig_WEBM_OPUS
+	default:
+		return nil, &providers.UnsupportedEncodingError{Provider: providerName, Encoding: enc}
+	}
+
+	switch enc {
+	case providers.EncodingMulaw:
+		if channels != 1 {
+			return nil, unsupported("%d channels, only mono is supported", channels)
+		}
+	default:
+		if channels > maxChannels {
+			return nil, unsupported("%d channels, at most %d are supported", channels, maxChannels)
+		}
+	}
+
+	switch enc {
+	case providers.EncodingOggOpus, providers.EncodingWebmOpus:
+		if !slices.Contains(opusSampleRates, config.SampleRate) {
+			return nil, unsupported("sample rate %d Hz, want one of %v", config.SampleRate, opusSampleRates)
+		}
+	case providers.EncodingLinear16, providers.EncodingMulaw:
+		if config.SampleRate <= 0 {
+			return nil, unsupported("raw audio needs a sample rate")
+		}
+	}
+
+	if n := len(config.AlternativeLanguageCodes); n > maxAlternativeLanguages {
+		return nil, unsupported("%d alternative languages, at most %d are supported", n, maxAlternativeLanguages)
+	}
+
+	recognitionConfig := &speechpb.RecognitionConfig{
+		Encoding:                 encoding,
+		SampleRateHertz:          int32(config.SampleRate),
+		LanguageCode:             config.LanguageCode,
+		AlternativeLanguageCodes: config.AlternativeLanguageCodes,
+		Model:                    config.Models[providerName],
+		// Deepgram punctuates too, so results look alike whichever
+		// provider is selected.
+		EnableAutomaticPunctuation: true,
+		MaxAlternatives:            int32(config.MaxAlternatives),
+	}
+	recognitionConfig.UseEnhanced = slices.Contains(enhancedModels, recognitionConfig.Model)
+	// Google can mask profanity, but has no redaction of personal data.
+	recognitionConfig.ProfanityFilter = config.Redaction.Profanity
+	if channels > 1 {
+		recognitionConfig.AudioChannelCount = int32(channels)
+		recognitionConfig.EnableSeparateRecognitionPerChannel = config.SeparateChannels
+	}
+
+	if d := config.Diarization; d.Enabled {
+		if d.MaxSpeakers > 0 && d.MinSpeakers > d.MaxSpeakers {
+			return nil, unsupported("min speakers %d is more than max speakers %d", d.MinSpeakers, d.MaxSpeakers)
+		}
+		recognitionConfig.DiarizationConfig = &speechpb.SpeakerDiarizationConfig{
+			EnableSpeakerDiarization: true,
+			MinSpeakerCount:          int32(d.MinSpeakers),
+			MaxSpeakerCount:          int32(d.MaxSpeakers),
+		}
+		// Speaker labels come per word, so ask for the words.
+		recognitionConfig.EnableWordTimeOffsets = true
+		recognitionConfig.EnableWordConfidence = true
+	}
+
+	// Custom classes need speech adaptation, which supersedes speech
+	// contexts, so the phrases go with them when there are any.
+	if len(config.CustomClasses) > 0 {
+		recognitionConfig.Adaptation = speechAdaptation(config.Phrases, config.CustomClasses)
+	} else {
+		recognitionConfig.SpeechContexts = speechContexts(config.Phrases)
+	}
+
+	return &speechpb.StreamingRecognitionConfig{
+		Config:         recognitionConfig,
+		InterimResults: config.InterimResults,
+	}, nil
+}
+
+// speechContexts groups phrase hints by boost, since Google sets
+// the boost per speech context rather than per phrase.
+func speechContexts(phrases []providers.PhraseHint) []*speechpb.SpeechContext {
+	var contexts []*speechpb.SpeechContext
+	byBoost := make(map[float32]*speechpb.SpeechContext)
+	for _, p := range phrases {
+		ctx, ok := byBoost[p.Boost]
+		if !ok {
+			ctx = &speechpb.SpeechContext{Boost: p.Boost}
+			byBoost[p.Boost] = ctx
+			contexts = append(contexts, ctx)
+		}
+		ctx.Phrases = append(ctx.Phrases, p.Text)
+	}
+	return contexts
+}
+
+// speechAdaptation builds inline speech adaptation out of phrase hints and
+// custom classes. Classes no phrase refers to get a phrase of their own,
+// since Google only favors a class where a phrase refers to it.
+func speechAdaptation(phrases []providers.PhraseHint, classes []providers.CustomClass) *speechpb.SpeechAdaptation {
+	phraseSet := &speechpb.PhraseSet{}
+	referenced := make(map[string]bool)
+	for _, p := range phrases {
+		for _, name := range providers.ClassReferences(p.Text) {
+			referenced[name] = true
+		}
+		phraseSet.Phrases = append(phraseSet.Phrases, &speechpb.PhraseSet_Phrase{Value: p.Text, Boost: p.Boost})
+	}
+
+	adaptation := &speechpb.SpeechAdaptation{PhraseSets: []*speechpb.PhraseSet{phraseSet}}
+	for _, c := range classes {
+		class := &speechpb.CustomClass{CustomClassId: c.Name}
+		for _, item := range c.Items {
+			class.Items = append(class.Items, &speechpb.CustomClass_ClassItem{Value: item})
+		}
+		adaptation.CustomClasses = append(adaptation.CustomClasses, class)
+
+		if !referenced[c.Name] {
+			phraseSet.Phrases = append(phraseSet.Phrases, &speechpb.PhraseSet_Phrase{Value: "${" + c.Name + "}"})
+		}
+	}
+	return adaptation
+}
+
 // Session implements the providers.Session interface for Google Speech-to-Text API.
 type Session struct {
-	stream streamingRecognizeClient
-	ctx    context.Context
+	stream           streamingRecognizeClient
+	ctx              context.Context
+	separateChannels bool
+	diarization      bool
+	// wordsSeen is the number of words already returned in earlier results.
+	// With diarization, Google repeats every word from the start of the
+	// stream in each response.
+	wordsSeen int
 }
 
 // SendAudio sends audio data to the Google Speech stream.
@@ -104,19 +315,85 @@ func (s *Session) ReceiveTranscription() (providers.TranscriptionResult, error)
 		for _, result := range resp.Results {
 			if result.IsFinal && len(result.Alternatives) > 0 {
 				alt := result.Alternatives[0]
-				return providers.TranscriptionResult{
+				tr := providers.TranscriptionResult{
 					Text:         alt.Transcript,
 					IsFinal:      true,
 					Confidence:   alt.Confidence,
 					ProviderName: providerName,
+					LanguageCode: result.LanguageCode,
+					Alternatives: convertAlternatives(result.Alternatives[1:]),
 					ReceivedAt:   time.Now(),
-				}, nil
+				}
+				// Channel tags are already 1-based.
+				if s.separateChannels {
+					tr.Channel = int(result.ChannelTag)
+				}
+
+				words := alt.Words
+				if s.diarization {
+					words = words[min(s.wordsSeen, len(words)):]
+					s.wordsSeen = len(alt.Words)
+				}
+				tr.Words = convertWords(words)
+				tr.Speaker = providers.DominantSpeaker(tr.Words)
+				return tr, nil
 			}
 		}
 		// Continue loop if no final results found
 	}
 }
 
+// convertAlternatives converts the runner-up transcripts of a result.
+func convertAlternatives(alternatives []*speechpb.SpeechRecognitionAlternative) []providers.Alternative {
+	if len(alternatives) == 0 {
+		return nil
+	}
+
+	out := make([]providers.Alternative, 0, len(alternatives))
+	for _, alt := range alternatives {
+		out = append(out, providers.Alternative{
+			Text:       alt.Transcript,
+			Confidence: alt.Confidence,
+		})
+	}
+	return out
+}
+
+// convertWords converts Google's word info into provider-agnostic words.
+func convertWords(words []*speechpb.WordInfo) []providers.Word {
+	if len(words) == 0 {
+		return nil
+	}
+
+	out := make([]providers.Word, 0, len(words))
+	for _, w := range words {
+		out = append(out, providers.Word{
+			Text:       w.Word,
+			Start:      w.StartTime.AsDuration(),
+			End:        w.EndTime.AsDuration(),
+			Confidence: w.Confidence,
+			Speaker:    speakerNumber(w),
+		})
+	}
+	return out
+}
+
+// speakerNumber returns the 1-based speaker of a word. Labels are numbers
+// for all the models we use, the older speaker tag is the fallback.
+func speakerNumber(w *speechpb.WordInfo) int {
+	if n, err := strconv.Atoi(w.SpeakerLabel); err == nil {
+		return n
+	}
+	return int(w.GetSpeakerTag())
+}
+
+// Finish half-closes the Google Speech stream. Google then sends the final
+// results of the audio it has, and ends the stream, which
+// ReceiveTranscription returns as io.EOF.
+func (s *Session) Finish() error {
+	return s.stream.CloseSend()
+}
+
 // Close closes the Google Speech stream.
 func (s *Session) Close() error {
 	return s.stream.CloseSend()
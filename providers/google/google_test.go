@@ -10,8 +10,10 @@ import (
 	"cloud.google.com/go/speech/apiv1/speechpb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/agnivade/stt_challenge/providers"
 )
@@ -293,3 +295,454 @@ func TestSession_Close(t *testing.T) {
 		})
 	}
 }
+
+func TestSession_Finish(t *testing.T) {
+	mockStream := newMockstreamingRecognizeClient(t)
+	session := &Session{
+		stream: mockStream,
+		ctx:    context.Background(),
+	}
+
+	// Google ends the stream once it has sent the last results
+	mockStream.EXPECT().CloseSend().Return(nil).Once()
+	mockStream.EXPECT().Recv().Return(&speechpb.StreamingRecognizeResponse{
+		Results: []*speechpb.StreamingRecognitionResult{
+			{
+				IsFinal:      true,
+				Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "last words", Confidence: 0.9}},
+			},
+		},
+	}, nil).Once()
+	mockStream.EXPECT().Recv().Return(nil, io.EOF).Once()
+
+	require.NoError(t, session.Finish())
+	result, err := session.ReceiveTranscription()
+	require.NoError(t, err)
+	assert.Equal(t, "last words", result.Text)
+	_, err = session.ReceiveTranscription()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestStreamingRecognitionConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   providers.SessionConfig
+		expected speechpb.RecognitionConfig_AudioEncoding
+		channels int32
+		err      string
+	}{
+		{
+			name:     "default encoding",
+			config:   providers.SessionConfig{SampleRate: 16000, LanguageCode: "en-US"},
+			expected: speechpb.RecognitionConfig_LINEAR16,
+		},
+		{
+			name:     "stereo linear16",
+			config:   providers.SessionConfig{Encoding: providers.EncodingLinear16, SampleRate: 44100, Channels: 2, LanguageCode: "en-US"},
+			expected: speechpb.RecognitionConfig_LINEAR16,
+			channels: 2,
+		},
+		{
+			name:     "flac without sample rate",
+			config:   providers.SessionConfig{Encoding: providers.EncodingFLAC, LanguageCode: "en-US"},
+			expected: speechpb.RecognitionConfig_FLAC,
+		},
+		{
+			name:     "mulaw",
+			config:   providers.SessionConfig{Encoding: providers.EncodingMulaw, SampleRate: 8000, LanguageCode: "en-US"},
+			expected: speechpb.RecognitionConfig_MULAW,
+		},
+		{
+			name:     "ogg opus",
+			config:   providers.SessionConfig{Encoding: providers.EncodingOggOpus, SampleRate: 16000, LanguageCode: "en-US"},
+			expected: speechpb.RecognitionConfig_OGG_OPUS,
+		},
+		{
+			name:     "webm opus",
+			config:   providers.SessionConfig{Encoding: providers.EncodingWebmOpus, SampleRate: 48000, LanguageCode: "en-US"},
+			expected: speechpb.RecognitionConfig_WEBM_OPUS,
+		},
+		{
+			name:   "unsupported encoding",
+			config: providers.SessionConfig{Encoding: "mp3", SampleRate: 16000},
+			err:    `google: unsupported encoding "mp3"`,
+		},
+		{
+			name:   "stereo mulaw",
+			config: providers.SessionConfig{Encoding: providers.EncodingMulaw, SampleRate: 8000, Channels: 2},
+			err:    "google: unsupported config for mulaw: 2 channels, only mono is supported",
+		},
+		{
+			name:   "too many channels",
+			config: providers.SessionConfig{Encoding: providers.EncodingFLAC, Channels: 10},
+			err:    "google: unsupported config for flac: 10 channels, at most 8 are supported",
+		},
+		{
+			name:   "opus at 44.1kHz",
+			config: providers.SessionConfig{Encoding: providers.EncodingOggOpus, SampleRate: 44100},
+			err:    "google: unsupported config for ogg_opus: sample rate 44100 Hz, want one of [8000 12000 16000 24000 48000]",
+		},
+		{
+			name:   "raw audio without sample rate",
+			config: providers.SessionConfig{Encoding: providers.EncodingLinear16},
+			err:    "google: unsupported config for linear16: raw audio needs a sample rate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := streamingRecognitionConfig(tt.config)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg.Config.Encoding)
+			assert.Equal(t, int32(tt.config.SampleRate), cfg.Config.SampleRateHertz)
+			assert.Equal(t, tt.channels, cfg.Config.AudioChannelCount)
+			assert.Equal(t, tt.config.LanguageCode, cfg.Config.LanguageCode)
+		})
+	}
+}
+
+func TestStreamingRecognitionConfig_TypedErrors(t *testing.T) {
+	_, err := streamingRecognitionConfig(providers.SessionConfig{Encoding: "mp3"})
+	var encErr *providers.UnsupportedEncodingError
+	if assert.ErrorAs(t, err, &encErr) {
+		assert.Equal(t, "google", encErr.Provider)
+		assert.Equal(t, providers.AudioEncoding("mp3"), encErr.Encoding)
+	}
+
+	_, err = streamingRecognitionConfig(providers.SessionConfig{Encoding: providers.EncodingMulaw, SampleRate: 8000, Channels: 2})
+	var cfgErr *providers.UnsupportedConfigError
+	if assert.ErrorAs(t, err, &cfgErr) {
+		assert.Equal(t, "google", cfgErr.Provider)
+		assert.Equal(t, providers.EncodingMulaw, cfgErr.Encoding)
+	}
+}
+
+func TestSession_ReceiveTranscription_ChannelTag(t *testing.T) {
+	response := &speechpb.StreamingRecognizeResponse{
+		Results: []*speechpb.StreamingRecognitionResult{
+			{
+				IsFinal:    true,
+				ChannelTag: 2,
+				Alternatives: []*speechpb.SpeechRecognitionAlternative{
+					{Transcript: "how can I help", Confidence: 0.9},
+				},
+			},
+		},
+	}
+
+	// Channel tags are only meaningful with separate recognition
+	for _, separate := range []bool{true, false} {
+		mockStream := newMockstreamingRecognizeClient(t)
+		mockStream.EXPECT().Recv().Return(response, nil).Once()
+
+		session := &Session{
+			stream:           mockStream,
+			ctx:              context.Background(),
+			separateChannels: separate,
+		}
+
+		result, err := session.ReceiveTranscription()
+		assert.NoError(t, err)
+		if separate {
+			assert.Equal(t, 2, result.Channel)
+		} else {
+			assert.Zero(t, result.Channel)
+		}
+	}
+}
+
+func TestStreamingRecognitionConfig_SeparateChannels(t *testing.T) {
+	cfg, err := streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate:       8000,
+		Channels:         2,
+		SeparateChannels: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), cfg.Config.AudioChannelCount)
+	assert.True(t, cfg.Config.EnableSeparateRecognitionPerChannel)
+
+	// Nothing to separate in mono audio
+	cfg, err = streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate:       8000,
+		SeparateChannels: true,
+	})
+	assert.NoError(t, err)
+	assert.False(t, cfg.Config.EnableSeparateRecognitionPerChannel)
+}
+
+func TestStreamingRecognitionConfig_Diarization(t *testing.T) {
+	cfg, err := streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate:  16000,
+		Diarization: providers.DiarizationConfig{Enabled: true, MinSpeakers: 2, MaxSpeakers: 4},
+	})
+	assert.NoError(t, err)
+	assert.True(t, cfg.Config.DiarizationConfig.EnableSpeakerDiarization)
+	assert.Equal(t, int32(2), cfg.Config.DiarizationConfig.MinSpeakerCount)
+	assert.Equal(t, int32(4), cfg.Config.DiarizationConfig.MaxSpeakerCount)
+	assert.True(t, cfg.Config.EnableWordTimeOffsets)
+
+	_, err = streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate:  16000,
+		Diarization: providers.DiarizationConfig{Enabled: true, MinSpeakers: 5, MaxSpeakers: 2},
+	})
+	var cfgErr *providers.UnsupportedConfigError
+	assert.ErrorAs(t, err, &cfgErr)
+}
+
+func TestSession_ReceiveTranscription_Diarization(t *testing.T) {
+	word := func(text string, start, end time.Duration, speaker string) *speechpb.WordInfo {
+		return &speechpb.WordInfo{
+			Word:         text,
+			StartTime:    durationpb.New(start),
+			EndTime:      durationpb.New(end),
+			SpeakerLabel: speaker,
+		}
+	}
+	final := func(transcript string, words ...*speechpb.WordInfo) *speechpb.StreamingRecognizeResponse {
+		return &speechpb.StreamingRecognizeResponse{
+			Results: []*speechpb.StreamingRecognitionResult{
+				{
+					IsFinal: true,
+					Alternatives: []*speechpb.SpeechRecognitionAlternative{
+						{Transcript: transcript, Words: words},
+					},
+				},
+			},
+		}
+	}
+
+	hello := word("hello", 0, 500*time.Millisecond, "1")
+	there := word("there", 500*time.Millisecond, time.Second, "1")
+	hi := word("hi", 2*time.Second, 2500*time.Millisecond, "2")
+
+	// Every response repeats the words from the start of the stream
+	mockStream := newMockstreamingRecognizeClient(t)
+	mockStream.EXPECT().Recv().Return(final("hello there", hello, there), nil).Once()
+	mockStream.EXPECT().Recv().Return(final("hi", hello, there, hi), nil).Once()
+
+	session := &Session{
+		stream:      mockStream,
+		ctx:         context.Background(),
+		diarization: true,
+	}
+
+	result, err := session.ReceiveTranscription()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Speaker)
+	assert.Equal(t, []providers.Word{
+		{Text: "hello", Start: 0, End: 500 * time.Millisecond, Speaker: 1},
+		{Text: "there", Start: 500 * time.Millisecond, End: time.Second, Speaker: 1},
+	}, result.Words)
+
+	result, err = session.ReceiveTranscription()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Speaker)
+	assert.Equal(t, []providers.Word{
+		{Text: "hi", Start: 2 * time.Second, End: 2500 * time.Millisecond, Speaker: 2},
+	}, result.Words)
+}
+
+func TestSpeechContexts(t *testing.T) {
+	contexts := speechContexts([]providers.PhraseHint{
+		{Text: "Acme Cloud", Boost: 10},
+		{Text: "Jane Doe"},
+		{Text: "AcmeDB", Boost: 10},
+	})
+
+	// Grouped by boost, in order of first appearance
+	assert.Len(t, contexts, 2)
+	assert.Equal(t, float32(10), contexts[0].Boost)
+	assert.Equal(t, []string{"Acme Cloud", "AcmeDB"}, contexts[0].Phrases)
+	assert.Equal(t, float32(0), contexts[1].Boost)
+	assert.Equal(t, []string{"Jane Doe"}, contexts[1].Phrases)
+
+	assert.Nil(t, speechContexts(nil))
+}
+
+func TestStreamingRecognitionConfig_CustomClasses(t *testing.T) {
+	config, err := streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate: 16000,
+		Phrases:    []providers.PhraseHint{{Text: "order ${products}", Boost: 5}},
+		CustomClasses: []providers.CustomClass{
+			{Name: "products", Items: []string{"Acme Cloud", "Acme Edge"}},
+			{Name: "drugs", Items: []string{"ibuprofen"}},
+		},
+	})
+	require.NoError(t, err)
+
+	// Adaptation supersedes speech contexts
+	assert.Empty(t, config.Config.SpeechContexts)
+	adaptation := config.Config.Adaptation
+	require.NotNil(t, adaptation)
+
+	require.Len(t, adaptation.CustomClasses, 2)
+	assert.Equal(t, "products", adaptation.CustomClasses[0].CustomClassId)
+	require.Len(t, adaptation.CustomClasses[0].Items, 2)
+	assert.Equal(t, "Acme Edge", adaptation.CustomClasses[0].Items[1].Value)
+
+	// Unreferenced classes get a phrase of their own
+	require.Len(t, adaptation.PhraseSets, 1)
+	phrases := adaptation.PhraseSets[0].Phrases
+	require.Len(t, phrases, 2)
+	assert.Equal(t, "order ${products}", phrases[0].Value)
+	assert.Equal(t, float32(5), phrases[0].Boost)
+	assert.Equal(t, "${drugs}", phrases[1].Value)
+
+	// Without classes, phrases stay speech contexts
+	config, err = streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate: 16000,
+		Phrases:    []providers.PhraseHint{{Text: "Acme Cloud"}},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, config.Config.Adaptation)
+	assert.Len(t, config.Config.SpeechContexts, 1)
+}
+
+func TestStreamingRecognitionConfig_Models(t *testing.T) {
+	tests := []struct {
+		name        string
+		models      map[string]string
+		model       string
+		useEnhanced bool
+	}{
+		{
+			name: "default model",
+		},
+		{
+			name:   "other providers' models are ignored",
+			models: map[string]string{"deepgram": "nova-3-medical"},
+		},
+		{
+			name:   "model without enhanced version",
+			models: map[string]string{"google": "latest_long"},
+			model:  "latest_long",
+		},
+		{
+			name:        "enhanced model",
+			models:      map[string]string{"google": "phone_call"},
+			model:       "phone_call",
+			useEnhanced: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := streamingRecognitionConfig(providers.SessionConfig{SampleRate: 8000, Models: tt.models})
+			require.NoError(t, err)
+			assert.Equal(t, tt.model, config.Config.Model)
+			assert.Equal(t, tt.useEnhanced, config.Config.UseEnhanced)
+		})
+	}
+}
+
+func TestStreamingRecognitionConfig_AlternativeLanguages(t *testing.T) {
+	config, err := streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate:               16000,
+		LanguageCode:             "en-IN",
+		AlternativeLanguageCodes: []string{"hi-IN"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "en-IN", config.Config.LanguageCode)
+	assert.Equal(t, []string{"hi-IN"}, config.Config.AlternativeLanguageCodes)
+
+	_, err = streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate:               16000,
+		LanguageCode:             "en-IN",
+		AlternativeLanguageCodes: []string{"hi-IN", "ta-IN", "te-IN", "mr-IN"},
+	})
+	assert.EqualError(t, err, "google: unsupported config for linear16: 4 alternative languages, at most 3 are supported")
+}
+
+func TestSession_ReceiveTranscription_LanguageCode(t *testing.T) {
+	mockStream := newMockstreamingRecognizeClient(t)
+	mockStream.EXPECT().Recv().Return(&speechpb.StreamingRecognizeResponse{
+		Results: []*speechpb.StreamingRecognitionResult{
+			{
+				IsFinal:      true,
+				LanguageCode: "hi-in",
+				Alternatives: []*speechpb.SpeechRecognitionAlternative{
+					{Transcript: "namaste", Confidence: 0.9},
+				},
+			},
+		},
+	}, nil).Once()
+
+	session := &Session{
+		stream: mockStream,
+		ctx:    context.Background(),
+	}
+
+	result, err := session.ReceiveTranscription()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi-in", result.LanguageCode)
+}
+
+func TestStreamingRecognitionConfig_Redaction(t *testing.T) {
+	config, err := streamingRecognitionConfig(providers.SessionConfig{
+		SampleRate: 16000,
+		Redaction: providers.RedactionConfig{
+			Profanity: true,
+			PII:       []providers.PIIType{providers.PIICreditCard},
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, config.Config.ProfanityFilter)
+}
+
+func TestStreamingRecognitionConfig_Punctuation(t *testing.T) {
+	config, err := streamingRecognitionConfig(providers.SessionConfig{SampleRate: 16000})
+	require.NoError(t, err)
+	assert.True(t, config.Config.EnableAutomaticPunctuation)
+}
+
+func TestStreamingRecognitionConfig_Alternatives(t *testing.T) {
+	config, err := streamingRecognitionConfig(providers.SessionConfig{SampleRate: 16000, MaxAlternatives: 3})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), config.Config.MaxAlternatives)
+}
+
+func TestSession_ReceiveTranscription_Alternatives(t *testing.T) {
+	mockStream := newMockstreamingRecognizeClient(t)
+	mockStream.EXPECT().Recv().Return(&speechpb.StreamingRecognizeResponse{
+		Results: []*speechpb.StreamingRecognitionResult{
+			{
+				IsFinal: true,
+				Alternatives: []*speechpb.SpeechRecognitionAlternative{
+					{Transcript: "recognize speech", Confidence: 0.9},
+					{Transcript: "wreck a nice beach", Confidence: 0.6},
+					{Transcript: "recognise peach", Confidence: 0.3},
+				},
+			},
+		},
+	}, nil).Once()
+
+	session := &Session{
+		stream: mockStream,
+		ctx:    context.Background(),
+	}
+
+	result, err := session.ReceiveTranscription()
+	assert.NoError(t, err)
+	assert.Equal(t, "recognize speech", result.Text)
+	assert.Equal(t, []providers.Alternative{
+		{Text: "wreck a nice beach", Confidence: 0.6},
+		{Text: "recognise peach", Confidence: 0.3},
+	}, result.Alternatives)
+}
+
+func TestNewFromSettings_Credentials(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	_, _, err := newFromSettings(context.Background(), providers.Settings{Type: "google"})
+	assert.EqualError(t, err, "GOOGLE_APPLICATION_CREDENTIALS environment variable is required")
+
+	_, _, err = newFromSettings(context.Background(), providers.Settings{
+		Type:        "google",
+		Credentials: providers.Credentials{Env: "GOOGLE_EU_CREDENTIALS"},
+	})
+	assert.EqualError(t, err, "GOOGLE_EU_CREDENTIALS environment variable is required")
+}
@@ -221,6 +221,50 @@ func (_c *MockSession_Close_Call) RunAndReturn(run func() error) *MockSession_Cl
 	return _c
 }
 
+// Finish provides a mock function for the type MockSession
+func (_mock *MockSession) Finish() error {
+	ret := _mock.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Finish")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func() error); ok {
+		r0 = returnFunc()
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockSession_Finish_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Finish'
+type MockSession_Finish_Call struct {
+	*mock.Call
+}
+
+// Finish is a helper method to define mock.On call
+func (_e *MockSession_Expecter) Finish() *MockSession_Finish_Call {
+	return &MockSession_Finish_Call{Call: _e.mock.On("Finish")}
+}
+
+func (_c *MockSession_Finish_Call) Run(run func()) *MockSession_Finish_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockSession_Finish_Call) Return(err error) *MockSession_Finish_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockSession_Finish_Call) RunAndReturn(run func() error) *MockSession_Finish_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // ReceiveTranscription provides a mock function for the type MockSession
 func (_mock *MockSession) ReceiveTranscription() (providers.TranscriptionResult, error) {
 	ret := _mock.Called()
@@ -29,6 +29,13 @@ type Session interface {
 	// Returns io.EOF when the session is closed, or context is canceled.
 	ReceiveTranscription() (TranscriptionResult, error)
 
+	// Finish tells the session that no more audio is coming, for the
+	// provider to transcribe what it already has, like the end of the last
+	// utterance. ReceiveTranscription then returns the remaining results,
+	// and io.EOF once there are none left. SendAudio must not be called
+	// after Finish.
+	Finish() error
+
 	// Close gracefully closes the transcription session and releases resources.
 	// After calling Close, SendAudio and ReceiveTranscription should not be called.
 	// Also, care must be taken that the readers and writers must be stopped
@@ -36,23 +43,166 @@ type Session interface {
 	Close() error
 }
 
+// AudioEncoding identifies the format of the audio sent to a session.
+type AudioEncoding string
+
+const (
+	// EncodingLinear16 is uncompressed 16-bit signed little-endian PCM.
+	EncodingLinear16 AudioEncoding = "linear16"
+
+	// EncodingFLAC is a FLAC stream, including its header.
+	EncodingFLAC AudioEncoding = "flac"
+
+	// EncodingMulaw is 8-bit G.711 mu-law companded audio.
+	EncodingMulaw AudioEncoding = "mulaw"
+
+	// EncodingOggOpus is Opus encoded audio in an Ogg container.
+	EncodingOggOpus AudioEncoding = "ogg_opus"
+
+	// EncodingWebmOpus is Opus encoded audio in a WebM container,
+	// which is what browsers produce with MediaRecorder.
+	EncodingWebmOpus AudioEncoding = "webm_opus"
+)
+
+// IsRaw reports whether the encoding is headerless, so that the sample rate
+// and channel count cannot be read from the audio itself.
+func (e AudioEncoding) IsRaw() bool {
+	return e == EncodingLinear16 || e == EncodingMulaw
+}
+
 // SessionConfig holds provider-agnostic configuration for transcription sessions.
 // Providers can extend this with provider-specific options using the Extensions field.
 type SessionConfig struct {
 	// SampleRate is the audio sample rate in Hz (e.g., 16000)
 	SampleRate int
 
+	// Encoding is the format of the audio passed to SendAudio.
+	// An empty value means EncodingLinear16.
+	Encoding AudioEncoding
+
+	// Channels is the number of interleaved channels in the audio.
+	// Zero means mono.
+	Channels int
+
+	// SeparateChannels asks for every channel to be transcribed on its own,
+	// like the agent and the customer of a call recording. Results then
+	// carry the channel they came from. Otherwise, providers may only
+	// transcribe the first channel, or a mix of all of them.
+	SeparateChannels bool
+
+	// Diarization configures telling apart the speakers of a single channel.
+	Diarization DiarizationConfig
+
+	// Phrases are words and phrases likely to come up, like product and
+	// customer names, that providers should favor when recognizing speech.
+	Phrases []PhraseHint
+
+	// CustomClasses are named lists of interchangeable items, like all the
+	// products in a catalog. Phrases refer to a class as "${name}".
+	CustomClasses []CustomClass
+
+	// Redaction asks providers to mask profanity and personal data in
+	// their results themselves. Providers skip what they can't redact.
+	Redaction RedactionConfig
+
+	// Models maps provider names to the model each provider transcribes
+	// with, like "phone_call" for Google or "nova-3-medical" for Deepgram.
+	// Providers without an entry use their own default.
+	Models map[string]string
+
 	// LanguageCode specifies the language for transcription (e.g., "en-US")
 	LanguageCode string
 
+	// AlternativeLanguageCodes are other languages the audio may be in, for
+	// speakers who switch between languages. Providers then detect the
+	// language, and report it with every result.
+	AlternativeLanguageCodes []string
+
 	// InterimResults indicates whether to return interim (non-final) results
 	InterimResults bool
 
+	// MaxAlternatives is the most transcripts to return for every result,
+	// the best one included. Zero or one only returns the best one.
+	MaxAlternatives int
+
 	// Extensions allows providers to specify additional configuration options
 	// using a map of key-value pairs specific to their implementation
 	Extensions map[string]interface{}
 }
 
+// DiarizationConfig holds the speaker diarization options of a session.
+type DiarizationConfig struct {
+	// Enabled turns on speaker labels for every word and result.
+	Enabled bool
+
+	// MinSpeakers and MaxSpeakers hint at how many people are talking.
+	// Zero leaves it to the provider. Not every provider uses them.
+	MinSpeakers int
+	MaxSpeakers int
+}
+
+// PhraseHint is a word or phrase to recognize more readily.
+type PhraseHint struct {
+	// Text is the word or phrase as it should be transcribed.
+	Text string
+
+	// Boost is how strongly to favor the phrase. Zero leaves it to
+	// the provider. Providers may ignore it, and the effective range
+	// differs between them, with 0 to 20 being a safe bet.
+	Boost float32
+}
+
+// PIIType is a kind of personal data to redact.
+type PIIType string
+
+const (
+	// PIICreditCard is a payment card number that passes the Luhn check.
+	PIICreditCard PIIType = "credit_card"
+
+	// PIISSN is a US social security number.
+	PIISSN PIIType = "ssn"
+
+	// PIIPhone is a phone number, with or without a country code.
+	PIIPhone PIIType = "phone"
+
+	// PIIEmail is an email address.
+	PIIEmail PIIType = "email"
+)
+
+// RedactionConfig holds the redaction options of a session.
+type RedactionConfig struct {
+	// Profanity masks profane words.
+	Profanity bool
+
+	// PII are the kinds of personal data to redact.
+	PII []PIIType
+}
+
+// CustomClass is a named list of items that phrases can refer to.
+type CustomClass struct {
+	// Name is what phrases refer to the class by, as "${name}".
+	Name string
+
+	// Items are the words or phrases that make up the class.
+	Items []string
+}
+
+// EffectiveEncoding returns the configured encoding, defaulting to EncodingLinear16.
+func (c SessionConfig) EffectiveEncoding() AudioEncoding {
+	if c.Encoding == "" {
+		return EncodingLinear16
+	}
+	return c.Encoding
+}
+
+// EffectiveChannels returns the configured channel count, defaulting to 1.
+func (c SessionConfig) EffectiveChannels() int {
+	if c.Channels == 0 {
+		return 1
+	}
+	return c.Channels
+}
+
 // TranscriptionResult represents a transcription result with metadata.
 type TranscriptionResult struct {
 	// Text is the transcribed text
@@ -67,6 +217,72 @@ type TranscriptionResult struct {
 	// ProviderName identifies which provider generated this result
 	ProviderName string
 
+	// Channel is the 1-based audio channel the text was spoken on,
+	// or 0 when the channels were not transcribed separately.
+	Channel int
+
+	// Speaker is the 1-based speaker who said most of the text,
+	// or 0 when diarization is off.
+	Speaker int
+
+	// Words holds the individual words of the text, if the provider
+	// returned them.
+	Words []Word
+
+	// LanguageCode is the language the text was detected in, as the
+	// provider reported it, or empty if it didn't.
+	LanguageCode string
+
+	// Alternatives are the next best transcripts of the same audio, most
+	// likely first, when more than one was asked for. Text holds the best.
+	Alternatives []Alternative
+
 	// ReceivedAt indicates when this result was received by the provider
 	ReceivedAt time.Time
+
+	// SeqNum numbers the results of a session that numbers them, from 1
+	// up, or is 0. A gap means the results in between were dropped.
+	SeqNum uint64
+}
+
+// Alternative is another transcript of the audio of a result.
+type Alternative struct {
+	// Text is the transcribed text
+	Text string
+
+	// Confidence is the confidence score (0.0 to 1.0) if available
+	Confidence float32
+}
+
+// Word is a single recognized word with its timing.
+type Word struct {
+	// Text is the word as it appears in the transcript.
+	Text string
+
+	// Start and End are offsets from the beginning of the audio stream.
+	Start time.Duration
+	End   time.Duration
+
+	// Confidence is the confidence score (0.0 to 1.0) if available
+	Confidence float32
+
+	// Speaker is the 1-based speaker of the word, or 0 when diarization is off.
+	Speaker int
+}
+
+// DominantSpeaker returns the speaker who said the most words. On a tie,
+// the speaker who got there first wins. It returns 0 if no word has a speaker.
+func DominantSpeaker(words []Word) int {
+	counts := make(map[int]int)
+	best := 0
+	for _, w := range words {
+		if w.Speaker == 0 {
+			continue
+		}
+		counts[w.Speaker]++
+		if best == 0 || counts[w.Speaker] > counts[best] {
+			best = w.Speaker
+		}
+	}
+	return best
 }
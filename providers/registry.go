@@ -0,0 +1,196 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Settings describe a provider instance, as declared in a config file.
+// Several instances of the same type, like Google in two regions, are told
+// apart by their names.
+type Settings struct {
+	// Name is what the instance is known by, in results, logs and model
+	// choices. Empty means the type.
+	Name string `yaml:"name" json:"name"`
+
+	// Type is the registered type of provider, like "google".
+	Type string `yaml:"type" json:"type"`
+
+	// Enabled turns the instance on or off. Nil means on.
+	Enabled *bool `yaml:"enabled" json:"enabled"`
+
+	// Credentials is where the credentials of the instance come from.
+	Credentials Credentials `yaml:"credentials" json:"credentials"`
+
+	// Model is the model sessions use unless they ask for another one,
+	// out of AllowedModels. Empty uses the provider's default.
+	Model         string   `yaml:"model" json:"model"`
+	AllowedModels []string `yaml:"allowed_models" json:"allowed_models"`
+
+	// Region is where the provider processes audio, for the types that
+	// have regional endpoints, like "eu" for Google.
+	Region string `yaml:"region" json:"region"`
+
+	// Endpoint replaces the default endpoint of the type, as a host name
+	// with an optional port. It takes precedence over Region.
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+
+	// Priority orders the instances, highest first. The first one is
+	// the active provider when a session starts.
+	Priority int `yaml:"priority" json:"priority"`
+
+	// Pricing is what the instance costs.
+	Pricing Pricing `yaml:"pricing" json:"pricing"`
+}
+
+// Credentials is where the credentials of a provider instance come from.
+// What the credentials are is up to the type: an API key, or the path of a
+// service account file.
+type Credentials struct {
+	// Env is the environment variable holding the credentials.
+	Env string `yaml:"env" json:"env"`
+
+	// File is the file holding the credentials.
+	File string `yaml:"file" json:"file"`
+}
+
+// Pricing is what a provider instance costs.
+type Pricing struct {
+	// PerMinute is the price of a minute of audio, in dollars.
+	PerMinute float64 `yaml:"per_minute" json:"per_minute"`
+}
+
+// IsEnabled reports whether the instance is on.
+func (s Settings) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// InstanceName returns the name of the instance, defaulting to its type.
+func (s Settings) InstanceName() string {
+	if s.Name == "" {
+		return s.Type
+	}
+	return s.Name
+}
+
+// Factory creates a provider from its settings. The closer, if not nil,
+// releases what the provider holds once it is no longer used.
+type Factory func(ctx context.Context, settings Settings) (Provider, io.Closer, error)
+
+var registry = struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register makes a type of provider available by name, usually from the
+// init function of the package implementing it. It panics if the name is
+// already taken.
+func Register(typeName string, factory Factory) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.factories[typeName]; ok {
+		panic("providers: Register called twice for type " + typeName)
+	}
+	registry.factories[typeName] = factory
+}
+
+// Types returns the registered types of provider, sorted.
+func Types() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(registry.factories))
+}
+
+// New creates a provider from its settings, with the factory registered
+// for its type. The provider goes by the name of the instance.
+func New(ctx context.Context, settings Settings) (Provider, io.Closer, error) {
+	registry.mu.RLock()
+	factory, ok := registry.factories[settings.Type]
+	registry.mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown provider type %q, want one of %s", settings.Type, strings.Join(Types(), ", "))
+	}
+
+	provider, closer, err := factory(ctx, settings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", settings.InstanceName(), err)
+	}
+	if name := settings.InstanceName(); name != provider.Name() {
+		provider = &namedProvider{Provider: provider, name: name}
+	}
+	return provider, closer, nil
+}
+
+// Secret returns the credentials as a string, like an API key, from the file
+// if there is one, or else from the environment variable. defaultEnv is the
+// variable to use when neither is set.
+func (c Credentials) Secret(defaultEnv string) (string, error) {
+	if c.File != "" {
+		b, err := os.ReadFile(c.File)
+		if err != nil {
+			return "", fmt.Errorf("reading credentials: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	env := c.Env
+	if env == "" {
+		env = defaultEnv
+	}
+	secret := os.Getenv(env)
+	if secret == "" {
+		return "", fmt.Errorf("%s environment variable is required", env)
+	}
+	return secret, nil
+}
+
+// namedProvider gives a provider the name of its instance, so that several
+// instances of a type can be told apart. The provider only knows its own
+// name, so models are passed on under it, and results are relabeled.
+type namedProvider struct {
+	Provider
+	name string
+}
+
+func (p *namedProvider) Name() string {
+	return p.name
+}
+
+func (p *namedProvider) NewSession(ctx context.Context, config SessionConfig) (Session, error) {
+	if model, ok := config.Models[p.name]; ok {
+		config.Models = maps.Clone(config.Models)
+		config.Models[p.Provider.Name()] = model
+	} else if _, ok := config.Models[p.Provider.Name()]; ok {
+		// The model of another instance of the same type.
+		config.Models = maps.Clone(config.Models)
+		delete(config.Models, p.Provider.Name())
+	}
+
+	session, err := p.Provider.NewSession(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &namedSession{Session: session, name: p.name}, nil
+}
+
+// namedSession relabels the results of a session with its instance name.
+type namedSession struct {
+	Session
+	name string
+}
+
+func (s *namedSession) ReceiveTranscription() (TranscriptionResult, error) {
+	result, err := s.Session.ReceiveTranscription()
+	if err == nil {
+		result.ProviderName = s.name
+	}
+	return result, err
+}
@@ -0,0 +1,135 @@
+package providers_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// registered counts the types the tests registered.
+var registered atomic.Int64
+
+// newType returns a provider type that isn't registered yet, since types
+// can't be registered twice, even by the same test run again.
+func newType(base string) string {
+	return fmt.Sprintf("%s-%d", base, registered.Add(1))
+}
+
+func TestRegistry(t *testing.T) {
+	typ := newType("fake")
+	var got providers.Settings
+	provider := mocks.NewMockProvider(t)
+	provider.EXPECT().Name().Return(typ)
+	providers.Register(typ, func(_ context.Context, settings providers.Settings) (providers.Provider, io.Closer, error) {
+		got = settings
+		return provider, nil, nil
+	})
+
+	assert.Contains(t, providers.Types(), typ)
+	assert.Panics(t, func() {
+		providers.Register(typ, nil)
+	})
+
+	// An instance named after its type is the provider itself.
+	p, closer, err := providers.New(context.Background(), providers.Settings{Type: typ, Region: "eu"})
+	require.NoError(t, err)
+	assert.Nil(t, closer)
+	assert.Same(t, provider, p)
+	assert.Equal(t, "eu", got.Region)
+
+	_, _, err = providers.New(context.Background(), providers.Settings{Type: "nope"})
+	assert.ErrorContains(t, err, `unknown provider type "nope", want one of `)
+}
+
+func TestRegistry_NamedInstance(t *testing.T) {
+	provider := mocks.NewMockProvider(t)
+	session := mocks.NewMockSession(t)
+	typ := newType("named")
+	name := typ + "-eu"
+	provider.EXPECT().Name().Return(typ)
+	providers.Register(typ, func(context.Context, providers.Settings) (providers.Provider, io.Closer, error) {
+		return provider, nil, nil
+	})
+
+	p, _, err := providers.New(context.Background(), providers.Settings{Name: name, Type: typ})
+	require.NoError(t, err)
+	assert.Equal(t, name, p.Name())
+
+	// The model of the instance is passed on under the name of the type,
+	// and the model of another instance of the type isn't.
+	provider.EXPECT().NewSession(mock.Anything, mock.MatchedBy(func(config providers.SessionConfig) bool {
+		return config.Models[typ] == "eu-model"
+	})).Return(session, nil).Once()
+	provider.EXPECT().NewSession(mock.Anything, mock.MatchedBy(func(config providers.SessionConfig) bool {
+		_, ok := config.Models[typ]
+		return !ok
+	})).Return(session, nil).Once()
+
+	models := map[string]string{name: "eu-model", typ: "us-model"}
+	s, err := p.NewSession(context.Background(), providers.SessionConfig{Models: models})
+	require.NoError(t, err)
+	// The config of the caller is left alone
+	assert.Equal(t, "us-model", models[typ])
+
+	_, err = p.NewSession(context.Background(), providers.SessionConfig{Models: map[string]string{typ: "us-model"}})
+	require.NoError(t, err)
+
+	// Results carry the name of the instance.
+	session.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{Text: "hi", ProviderName: typ}, nil).Once()
+	result, err := s.ReceiveTranscription()
+	require.NoError(t, err)
+	assert.Equal(t, name, result.ProviderName)
+}
+
+func TestRegistry_FactoryError(t *testing.T) {
+	typ := newType("broken")
+	providers.Register(typ, func(context.Context, providers.Settings) (providers.Provider, io.Closer, error) {
+		return nil, nil, io.ErrUnexpectedEOF
+	})
+
+	_, _, err := providers.New(context.Background(), providers.Settings{Name: "broken-1", Type: typ})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.EqualError(t, err, "broken-1: unexpected EOF")
+}
+
+func TestCredentials_Secret(t *testing.T) {
+	t.Setenv("TEST_DEFAULT_KEY", "default-key")
+	t.Setenv("TEST_OTHER_KEY", "other-key")
+
+	secret, err := providers.Credentials{}.Secret("TEST_DEFAULT_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "default-key", secret)
+
+	secret, err = providers.Credentials{Env: "TEST_OTHER_KEY"}.Secret("TEST_DEFAULT_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "other-key", secret)
+
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte("file-key\n"), 0o600))
+	secret, err = providers.Credentials{File: path, Env: "TEST_OTHER_KEY"}.Secret("TEST_DEFAULT_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "file-key", secret)
+
+	_, err = providers.Credentials{Env: "TEST_UNSET_KEY"}.Secret("TEST_DEFAULT_KEY")
+	assert.EqualError(t, err, "TEST_UNSET_KEY environment variable is required")
+}
+
+func TestSettings(t *testing.T) {
+	off := false
+	assert.True(t, providers.Settings{}.IsEnabled())
+	assert.False(t, providers.Settings{Enabled: &off}.IsEnabled())
+
+	assert.Equal(t, "google", providers.Settings{Type: "google"}.InstanceName())
+	assert.Equal(t, "google-eu", providers.Settings{Name: "google-eu", Type: "google"}.InstanceName())
+}
@@ -0,0 +1,275 @@
+package stt_challenge
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// RedactionConfig configures masking of profanity and personal data in
+// results. Redaction happens on the server, before results are logged or
+// sent to the client, whatever the providers did.
+type RedactionConfig struct {
+	// Profanity masks profane words, keeping their first letter, like "f***".
+	Profanity bool
+
+	// PII are the kinds of personal data to replace with a label,
+	// like "[CREDIT_CARD]".
+	PII []providers.PIIType
+
+	// ProviderNative also asks the providers to redact what they can,
+	// so that the data doesn't reach their transcripts in the first place.
+	ProviderNative bool
+}
+
+// merge returns the redaction of both configs, so that sessions can ask for
+// more redaction than the server does, but never less.
+func (c RedactionConfig) merge(other RedactionConfig) RedactionConfig {
+	merged := RedactionConfig{
+		Profanity:      c.Profanity || other.Profanity,
+		PII:            slices.Clone(c.PII),
+		ProviderNative: c.ProviderNative || other.ProviderNative,
+	}
+	for _, pii := range other.PII {
+		if !slices.Contains(merged.PII, pii) {
+			merged.PII = append(merged.PII, pii)
+		}
+	}
+	return merged
+}
+
+// stages returns the pipeline stages doing the redaction.
+func (c RedactionConfig) stages() []ResultStage {
+	var stages []ResultStage
+	if c.Profanity {
+		stages = append(stages, spanStage{find: findProfanity})
+	}
+	if len(c.PII) > 0 {
+		stages = append(stages, spanStage{find: piiFinder(c.PII)})
+	}
+	return stages
+}
+
+// ParsePIITypes parses a comma-separated list of kinds of personal data,
+// like "credit_card,ssn".
+func ParsePIITypes(s string) ([]providers.PIIType, error) {
+	var types []providers.PIIType
+	for _, v := range strings.Split(s, ",") {
+		switch pii := providers.PIIType(strings.TrimSpace(v)); pii {
+		case providers.PIICreditCard, providers.PIISSN, providers.PIIPhone, providers.PIIEmail:
+			if !slices.Contains(types, pii) {
+				types = append(types, pii)
+			}
+		default:
+			return nil, fmt.Errorf("unknown kind of personal data %q", v)
+		}
+	}
+	return types, nil
+}
+
+// profanity matches common English profanity, along with the usual
+// inflections, as whole words.
+var profanity = regexp.MustCompile(`(?i)\b(` + strings.Join([]string{
+	`(mother)?fuck(s|ed|er|ers|ing|in)?`,
+	`(bull)?shit(s|ty|ting)?`,
+	`bitch(es|ing|y)?`,
+	`ass(hole|holes)`,
+	`bastards?`,
+	`cunts?`,
+	`dick(head|heads)?`,
+	`piss(ed)?`,
+	`damn(ed|it)?`,
+	`goddamn(ed|it)?`,
+	`crap`,
+	`wankers?`,
+	`bollocks`,
+}, "|") + `)\b`)
+
+// findProfanity finds profane words, to be masked with asterisks after
+// their first letter.
+func findProfanity(text string) []span {
+	var spans []span
+	for _, loc := range profanity.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		_, size := utf8.DecodeRuneInString(word)
+		spans = append(spans, span{
+			start:       loc[0],
+			end:         loc[1],
+			replacement: word[:size] + strings.Repeat("*", utf8.RuneCountInString(word)-1),
+		})
+	}
+	return spans
+}
+
+var (
+	// Card numbers are 13 to 19 digits, often said in groups, so they are
+	// looked for in runs of digit groups.
+	digitRun   = regexp.MustCompile(`\d+(?:[ -]\d+)*`)
+	digitGroup = regexp.MustCompile(`\d+`)
+	// Social security numbers are 3, 2 and 4 digits.
+	ssn = regexp.MustCompile(`\d{3}[ -]?\d{2}[ -]?\d{4}`)
+	// Phone numbers are 10 digits with an optional country code, with
+	// the usual separators.
+	phoneNumber  = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)|\d{3})[ .-]?\d{3}[ .-]?\d{4}`)
+	emailAddress = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// piiFinder returns a finder for the given kinds of personal data. Numbers
+// spelled out in words are found too, whether or not inverse text
+// normalization writes them as digits, since providers don't always.
+func piiFinder(types []providers.PIIType) func(text string) []span {
+	return func(original string) []span {
+		text, toOriginal := spokenDigits(original)
+		var spans []span
+		for _, pii := range types {
+			label := "[" + strings.ToUpper(string(pii)) + "]"
+			switch pii {
+			case providers.PIICreditCard:
+				spans = appendCardNumbers(spans, text, label)
+			case providers.PIISSN:
+				spans = appendMatches(spans, text, ssn, label, validSSN)
+			case providers.PIIPhone:
+				spans = appendMatches(spans, text, phoneNumber, label, nil)
+			case providers.PIIEmail:
+				spans = appendMatches(spans, text, emailAddress, label, nil)
+			}
+		}
+		for i := range spans {
+			spans[i].start, spans[i].end = toOriginal(spans[i].start, spans[i].end)
+		}
+		return mergeSpans(spans)
+	}
+}
+
+// spokenDigits returns text with the numbers spelled out in words written
+// as digits, like "four one one one" as "4111", and a function mapping a
+// span of the returned text back to text. A span starting or ending within
+// a number covers all of its words.
+func spokenDigits(text string) (string, func(start, end int) (int, int)) {
+	numbers := findSpokenNumbers(text)
+	if len(numbers) == 0 {
+		return text, func(start, end int) (int, int) { return start, end }
+	}
+
+	// starts[i] and ends[i] are the offsets in text that offset i of the
+	// returned text maps to, as the start or the end of a span.
+	var sb strings.Builder
+	var starts, ends []int
+	keep := func(from, to int) {
+		for i := from; i < to; i++ {
+			starts = append(starts, i)
+			ends = append(ends, i)
+		}
+		sb.WriteString(text[from:to])
+	}
+	last := 0
+	for _, number := range numbers {
+		keep(last, number.start)
+		for i := range len(number.replacement) {
+			starts = append(starts, number.start)
+			if i == 0 {
+				ends = append(ends, number.start)
+			} else {
+				ends = append(ends, number.end)
+			}
+		}
+		sb.WriteString(number.replacement)
+		last = number.end
+	}
+	keep(last, len(text))
+	starts = append(starts, len(text))
+	ends = append(ends, len(text))
+
+	return sb.String(), func(start, end int) (int, int) {
+		return starts[start], ends[end]
+	}
+}
+
+// appendCardNumbers appends a span for every card number in text. A run of
+// digit groups may hold more than a card number, like the expiry date said
+// right after it, so every range of whole groups is tried, longest first.
+func appendCardNumbers(spans []span, text, label string) []span {
+	for _, run := range digitRun.FindAllStringIndex(text, -1) {
+		groups := digitGroup.FindAllStringIndex(text[run[0]:run[1]], -1)
+		for i := 0; i < len(groups); i++ {
+			for j := len(groups) - 1; j >= i; j-- {
+				start, end := run[0]+groups[i][0], run[0]+groups[j][1]
+				number := digits(text[start:end])
+				if len(number) < 13 || len(number) > 19 || !standsAlone(text, start, end) || !luhnValid(number) {
+					continue
+				}
+				spans = append(spans, span{start: start, end: end, replacement: label})
+				i = j
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// appendMatches appends a span for every match of re that valid accepts.
+// Matches must stand on their own, so that part of a longer number isn't
+// mistaken for a shorter one.
+func appendMatches(spans []span, text string, re *regexp.Regexp, label string, valid func(string) bool) []span {
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		if !standsAlone(text, loc[0], loc[1]) {
+			continue
+		}
+		if valid != nil && !valid(text[loc[0]:loc[1]]) {
+			continue
+		}
+		spans = append(spans, span{start: loc[0], end: loc[1], replacement: label})
+	}
+	return spans
+}
+
+// standsAlone reports whether text[start:end] isn't directly preceded or
+// followed by a letter or digit.
+func standsAlone(text string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	return !isWord(before) && !isWord(after)
+}
+
+// digits returns the digits of s.
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// luhnValid reports whether a number passes the Luhn checksum all card
+// numbers carry in their last digit.
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validSSN reports whether a number can be a social security number. Area
+// numbers 000, 666 and 900 to 999, group 00 and serial 0000 are never issued.
+func validSSN(s string) bool {
+	d := digits(s)
+	area, group, serial := d[:3], d[3:5], d[5:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
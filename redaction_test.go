@@ -0,0 +1,209 @@
+package stt_challenge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// redact runs text through the stages of a redaction config.
+func redact(cfg RedactionConfig, text string) string {
+	result := providers.TranscriptionResult{Text: text}
+	for _, stage := range cfg.stages() {
+		stage.Process(&result)
+	}
+	return result.Text
+}
+
+func TestRedaction_Profanity(t *testing.T) {
+	cfg := RedactionConfig{Profanity: true}
+
+	assert.Equal(t, "What the f*** is this s***?", redact(cfg, "What the fuck is this shit?"))
+	assert.Equal(t, "F****** finally", redact(cfg, "Fucking finally"))
+	// Only whole words
+	assert.Equal(t, "I'll pass the classic shitake", redact(cfg, "I'll pass the classic shitake"))
+}
+
+func TestRedaction_PII(t *testing.T) {
+	all := RedactionConfig{PII: []providers.PIIType{
+		providers.PIICreditCard, providers.PIISSN, providers.PIIPhone, providers.PIIEmail,
+	}}
+
+	tests := []struct {
+		name     string
+		cfg      RedactionConfig
+		text     string
+		expected string
+	}{
+		{
+			name:     "card number in groups",
+			cfg:      all,
+			text:     "My card is 4111 1111 1111 1111 thanks",
+			expected: "My card is [CREDIT_CARD] thanks",
+		},
+		{
+			name:     "card number without separators",
+			cfg:      all,
+			text:     "It's 5500000000000004.",
+			expected: "It's [CREDIT_CARD].",
+		},
+		{
+			name:     "numbers failing the checksum are no card",
+			cfg:      RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}},
+			text:     "Order 4111 1111 1111 1112",
+			expected: "Order 4111 1111 1111 1112",
+		},
+		{
+			name:     "card number followed by more digits",
+			cfg:      RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}},
+			text:     "Card 4111 1111 1111 1111 12 25 expiry",
+			expected: "Card [CREDIT_CARD] 12 25 expiry",
+		},
+		{
+			name:     "card number after more digits",
+			cfg:      RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}},
+			text:     "Option 2 5500 0000 0000 0004",
+			expected: "Option 2 [CREDIT_CARD]",
+		},
+		{
+			name:     "spoken card number",
+			cfg:      all,
+			text:     "It's four one one one one one one one one one one one one one one one thanks",
+			expected: "It's [CREDIT_CARD] thanks",
+		},
+		{
+			name:     "spoken social security number",
+			cfg:      all,
+			text:     "Mine is one two three four five six seven eight nine.",
+			expected: "Mine is [SSN].",
+		},
+		{
+			name:     "spoken phone number",
+			cfg:      all,
+			text:     "Call five five five one two three four five six seven now",
+			expected: "Call [PHONE] now",
+		},
+		{
+			name:     "spoken numbers that are no personal data",
+			cfg:      all,
+			text:     "Twenty five people, one two three",
+			expected: "Twenty five people, one two three",
+		},
+		{
+			name:     "social security number",
+			cfg:      all,
+			text:     "SSN 123-45-6789",
+			expected: "SSN [SSN]",
+		},
+		{
+			name:     "numbers never issued are no social security number",
+			cfg:      RedactionConfig{PII: []providers.PIIType{providers.PIISSN}},
+			text:     "Ticket 666-45-6789 and 123-00-6789",
+			expected: "Ticket 666-45-6789 and 123-00-6789",
+		},
+		{
+			name:     "phone numbers",
+			cfg:      all,
+			text:     "Call (555) 123-4567 or +1 555.123.4567",
+			expected: "Call [PHONE] or [PHONE]",
+		},
+		{
+			name:     "email",
+			cfg:      all,
+			text:     "Write to jane.doe+work@example.co.uk.",
+			expected: "Write to [EMAIL].",
+		},
+		{
+			name:     "only the kinds asked for",
+			cfg:      RedactionConfig{PII: []providers.PIIType{providers.PIIEmail}},
+			text:     "jane@example.com, 555-123-4567",
+			expected: "[EMAIL], 555-123-4567",
+		},
+		{
+			name:     "part of a longer number",
+			cfg:      all,
+			text:     "Reference 123455566677771234567890",
+			expected: "Reference 123455566677771234567890",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, redact(tt.cfg, tt.text))
+		})
+	}
+}
+
+func TestRedaction_Words(t *testing.T) {
+	cfg := RedactionConfig{Profanity: true, PII: []providers.PIIType{providers.PIICreditCard}}
+
+	result := providers.TranscriptionResult{
+		Text:  "damn it's 4111 1111 1111 1111",
+		Words: timedWords("damn", "it's", "4111", "1111", "1111", "1111"),
+	}
+	for _, stage := range cfg.stages() {
+		stage.Process(&result)
+	}
+
+	assert.Equal(t, "d*** it's [CREDIT_CARD]", result.Text)
+	require.Len(t, result.Words, 3)
+	assert.Equal(t, "d***", result.Words[0].Text)
+	assert.Equal(t, "[CREDIT_CARD]", result.Words[2].Text)
+	// The merged word lasts until the end of the card number
+	assert.Equal(t, 5500*time.Millisecond, result.Words[2].End)
+}
+
+func TestRedaction_SpokenWords(t *testing.T) {
+	cfg := RedactionConfig{PII: []providers.PIIType{providers.PIISSN}}
+
+	result := providers.TranscriptionResult{
+		Text:  "SSN one two three four five six seven eight nine ok",
+		Words: timedWords("SSN", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ok"),
+	}
+	for _, stage := range cfg.stages() {
+		stage.Process(&result)
+	}
+
+	assert.Equal(t, "SSN [SSN] ok", result.Text)
+	require.Len(t, result.Words, 3)
+	assert.Equal(t, "[SSN]", result.Words[1].Text)
+	assert.Equal(t, "ok", result.Words[2].Text)
+}
+
+func TestRedactionConfig_Merge(t *testing.T) {
+	server := RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}}
+
+	// Sessions can only add to what the server redacts
+	merged := server.merge(RedactionConfig{})
+	assert.Equal(t, server, merged)
+
+	merged = server.merge(RedactionConfig{
+		Profanity: true,
+		PII:       []providers.PIIType{providers.PIIEmail, providers.PIICreditCard},
+	})
+	assert.True(t, merged.Profanity)
+	assert.Equal(t, []providers.PIIType{providers.PIICreditCard, providers.PIIEmail}, merged.PII)
+	// The server config is left alone
+	assert.Len(t, server.PII, 1)
+
+	assert.Empty(t, RedactionConfig{}.stages())
+}
+
+func TestParsePIITypes(t *testing.T) {
+	types, err := ParsePIITypes("credit_card, ssn,credit_card")
+	require.NoError(t, err)
+	assert.Equal(t, []providers.PIIType{providers.PIICreditCard, providers.PIISSN}, types)
+
+	_, err = ParsePIITypes("credit_card,passport")
+	assert.EqualError(t, err, `unknown kind of personal data "passport"`)
+}
+
+func TestLuhnValid(t *testing.T) {
+	assert.True(t, luhnValid("4111111111111111"))
+	assert.True(t, luhnValid("378282246310005"))
+	assert.False(t, luhnValid("4111111111111112"))
+}
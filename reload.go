@@ -0,0 +1,75 @@
+package stt_challenge
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// Reload replaces the configuration and the providers of the server,
+// without dropping any connection. New connections use them, while the
+// connections already open keep their ProviderSelector, and the rest of the
+// configuration they started with, until they finish.
+//
+// The returned channel is closed once every connection started before the
+// reload has finished, when the previous providers are no longer used and
+// can be closed. The vocabularies and the reloader of the configuration
+// are kept when cfg leaves them nil.
+func (s *Server) Reload(cfg Config, providers ...providers.Provider) <-chan struct{} {
+	s.cfgMu.Lock()
+	if cfg.Vocabularies == nil {
+		cfg.Vocabularies = s.cfg.Vocabularies
+	}
+	if cfg.Reloader == nil {
+		cfg.Reloader = s.cfg.Reloader
+	}
+	previous := s.sessions
+	s.cfg = cfg
+	s.providers = providers
+	s.sessions = new(sync.WaitGroup)
+	s.cfgMu.Unlock()
+
+	s.log.Printf("Reloaded the configuration, with %d provider(s)\n", len(providers))
+
+	done := make(chan struct{})
+	go func() {
+		previous.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// acquire returns the configuration and providers of a new connection,
+// which must call release once it no longer uses them.
+func (s *Server) acquire() (cfg Config, providers []providers.Provider, release func()) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	// Adding under the lock makes sure Reload never waits on a group
+	// that still gets connections.
+	s.sessions.Add(1)
+	return s.cfg, s.providers, s.sessions.Done
+}
+
+// config returns the current configuration.
+func (s *Server) config() Config {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	return s.cfg
+}
+
+// handleReload reloads the configuration with the reloader of the server.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	reload := s.config().Reloader
+	if reload == nil {
+		http.Error(w, "reloading is not configured", http.StatusNotImplemented)
+		return
+	}
+	if err := reload(); err != nil {
+		s.log.Printf("Failed to reload the configuration: %v\n", err)
+		http.Error(w, "reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
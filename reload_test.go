@@ -0,0 +1,148 @@
+package stt_challenge
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// newReloadTestProvider returns a provider whose sessions transcribe a
+// single result with the name of the provider.
+func newReloadTestProvider(t *testing.T, name string) *mocks.MockProvider {
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	mockProvider.EXPECT().Name().Return(name)
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).Return(mockSession, nil).Once()
+
+	mockSession.EXPECT().ReceiveTranscription().Return(
+		providers.TranscriptionResult{
+			Text:         name,
+			IsFinal:      true,
+			ProviderName: name,
+			ReceivedAt:   time.Now(),
+		}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().Return(nil)
+	return mockProvider
+}
+
+func TestServer_Reload(t *testing.T) {
+	server := New("8081", newReloadTestProvider(t, "old"))
+	server.log = log.New(io.Discard, "", 0)
+
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+
+	dial := func() (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.NoError(t, err)
+		var response WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&response))
+		return conn, response.Sentence
+	}
+
+	oldConn, provider := dial()
+	defer oldConn.Close()
+	assert.Equal(t, "old", provider)
+
+	cfg := DefaultConfig()
+	cfg.Selector.Strategy = StrategyFusion
+	done := server.Reload(cfg, newReloadTestProvider(t, "new"))
+
+	// New connections use the new providers, the open one keeps its own
+	newConn, provider := dial()
+	defer newConn.Close()
+	assert.Equal(t, "new", provider)
+	assert.Equal(t, StrategyFusion, server.config().Selector.Strategy)
+
+	select {
+	case <-done:
+		t.Fatal("the previous providers are released while a connection still uses them")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Only the connections of before the reload hold the previous providers
+	oldConn.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the previous providers should be released once their connections finish")
+	}
+
+	newConn.Close()
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestServer_ReloadKeepsVocabularies(t *testing.T) {
+	server := New("8081")
+	server.log = log.New(io.Discard, "", 0)
+	vocabularies := server.config().Vocabularies
+
+	<-server.Reload(DefaultConfig())
+	assert.Same(t, vocabularies, server.config().Vocabularies)
+}
+
+func TestAdmin_Reload(t *testing.T) {
+	var reloads int
+	var reloadErr error
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	cfg.Reloader = func() error {
+		reloads++
+		return reloadErr
+	}
+	server := NewWithConfig("8081", cfg)
+	server.log = log.New(io.Discard, "", 0)
+
+	testServer := httptest.NewServer(server.srv.Handler)
+	defer testServer.Close()
+	url := testServer.URL + "/admin/reload"
+
+	resp := adminRequest(t, http.MethodPost, url, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 0, reloads)
+
+	resp = adminRequest(t, http.MethodPost, url, "secret", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, 1, reloads)
+
+	reloadErr = errors.New("no providers available")
+	resp = adminRequest(t, http.MethodPost, url, "secret", "")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "no providers available")
+
+	// Reloading without a reloader keeps the current one, and the token
+	// of the new configuration takes over
+	cfg = DefaultConfig()
+	cfg.AdminToken = "rotated"
+	<-server.Reload(cfg)
+	resp = adminRequest(t, http.MethodPost, url, "secret", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = adminRequest(t, http.MethodPost, url, "rotated", "")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, 3, reloads)
+}
+
+func TestAdmin_ReloadNotConfigured(t *testing.T) {
+	testServer := newAdminTestServer(t)
+
+	resp := adminRequest(t, http.MethodPost, testServer.URL+"/admin/reload", "secret", "")
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
@@ -0,0 +1,244 @@
+package stt_challenge
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxUnackedResults is how many results a session keeps until its client
+// acknowledges them. Past that, the oldest ones are dropped, and can't be
+// sent again if the connection drops.
+const maxUnackedResults = 1000
+
+// resumeHandoverTimeout is how long a resuming connection waits for the
+// session to let go of its previous connection.
+const resumeHandoverTimeout = 5 * time.Second
+
+// errConnDown is returned for writes while the connection of a resumable
+// session is down.
+var errConnDown = errors.New("connection is down")
+
+// resumption is a connection resuming a session, with the ID of the last
+// result its client has seen.
+type resumption struct {
+	conn     *websocket.Conn
+	lastSeen uint64
+}
+
+// registerSession makes a session resumable, and returns its token.
+func (s *Server) registerSession(wc *WebConn) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := rand.Text()
+	s.resumable[token] = wc
+	return token
+}
+
+func (s *Server) unregisterSession(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.resumable, token)
+}
+
+// resumeSession hands the connection of a client coming back over to the
+// session it had.
+func (s *Server) resumeSession(w http.ResponseWriter, r *http.Request, token string) {
+	s.mu.Lock()
+	wc, ok := s.resumable[token]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown or expired session", http.StatusNotFound)
+		return
+	}
+
+	var lastSeen uint64
+	if last := r.URL.Query().Get("last"); last != "" {
+		var err error
+		if lastSeen, err = strconv.ParseUint(last, 10, 64); err != nil {
+			http.Error(w, "invalid last: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.Printf("WebSocket upgrade failed: %v\n", err)
+		return
+	}
+
+	// The server may not have noticed the previous connection dropped
+	// yet. Closing it makes the session wait for this one.
+	if previous := wc.currentConn(); previous != nil {
+		previous.Close()
+	}
+
+	select {
+	case wc.attach <- resumption{conn: conn, lastSeen: lastSeen}:
+	case <-wc.closing:
+		s.closeUnresumed(conn)
+	case <-time.After(resumeHandoverTimeout):
+		s.closeUnresumed(conn)
+	}
+}
+
+// closeUnresumed closes the connection of a client whose session ended
+// before it could be resumed.
+func (s *Server) closeUnresumed(conn *websocket.Conn) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session ended"),
+		time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// canResume reports whether the client may resume the session after the
+// read error, rather than the session ending. Clients end their session
+// with a normal closure.
+func (wc *WebConn) canResume(err error) bool {
+	if wc.token == "" || wc.draining.Load() || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return false
+	}
+	select {
+	case <-wc.closing:
+		return false
+	default:
+		return true
+	}
+}
+
+// waitForResume lets go of the connection, and waits for the client to
+// resume the session on another one. The results after the last one the
+// client has seen are sent again once it does. It returns nil if the client
+// doesn't within the resume timeout, or the session ends first.
+func (wc *WebConn) waitForResume() *websocket.Conn {
+	// A write still going on fails, rather than holding the session up.
+	wc.mu.Lock()
+	wc.conn.Close()
+	wc.conn = nil
+	wc.mu.Unlock()
+	wc.log.Printf("Connection dropped, keeping the session for %v\n", wc.resumeTimeout)
+
+	timer := time.NewTimer(wc.resumeTimeout)
+	defer timer.Stop()
+
+	writerDone := wc.writerDone
+	for {
+		select {
+		case r := <-wc.attach:
+			return wc.resume(r)
+
+		case <-timer.C:
+			wc.log.Println("Session wasn't resumed in time")
+			return nil
+		case <-writerDone:
+			// A finished session still has its last results, and
+			// the end of its transcript, to send.
+			if !wc.hasEnded() {
+				return nil
+			}
+			writerDone = nil
+		case <-wc.closing:
+			return nil
+		}
+	}
+}
+
+// resume hands the session over to the connection of the client resuming it,
+// and sends the results after the last one the client has seen again.
+func (wc *WebConn) resume(r resumption) *websocket.Conn {
+	// Results sent from here on wait for the replay, and are not in it.
+	wc.writeMu.Lock()
+	defer wc.writeMu.Unlock()
+
+	wc.mu.Lock()
+	wc.conn = r.conn
+	wc.ackLocked(r.lastSeen)
+	unacked := slices.Clone(wc.unacked)
+	ended := wc.ended
+	wc.mu.Unlock()
+
+	wc.keepAlive(r.conn)
+	if len(unacked) > 0 && unacked[0].ID > r.lastSeen+1 {
+		wc.log.Printf("Results %d to %d were dropped before the client saw them\n",
+			r.lastSeen+1, unacked[0].ID-1)
+	}
+	wc.log.Printf("Session resumed after result %d, replaying %d result(s)\n", r.lastSeen, len(unacked))
+	// After a failed write, the reader finds out this connection
+	// dropped too. The results stay unacknowledged until the client
+	// says otherwise.
+	if err := wc.write(r.conn, WebSocketResponse{Type: ResponseSession, Session: wc.token}); err != nil {
+		return r.conn
+	}
+	for _, response := range unacked {
+		if err := wc.write(r.conn, response); err != nil {
+			return r.conn
+		}
+	}
+	if ended {
+		wc.sendEndOfTranscript(r.conn)
+	}
+	return r.conn
+}
+
+// send writes a result to the client, with the next result ID unless the
+// session numbered it, in which case results it dropped leave a gap. The
+// results of a resumable session are kept until the client acknowledges
+// them, even while its connection is down, to be sent again once it resumes
+// the session. A client taking no result within the write timeout is too
+// slow, and send returns ErrSlowClient.
+func (wc *WebConn) send(response WebSocketResponse) error {
+	wc.mu.Lock()
+	if response.ID == 0 {
+		response.ID = wc.lastID + 1
+	}
+	wc.lastID = response.ID
+	if wc.token != "" {
+		if len(wc.unacked) == maxUnackedResults {
+			wc.log.Printf("Dropping result %d, never acknowledged\n", wc.unacked[0].ID)
+			wc.unacked = wc.unacked[1:]
+		}
+		wc.unacked = append(wc.unacked, response)
+	}
+	conn := wc.conn
+	wc.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	wc.writeMu.Lock()
+	err := wc.write(conn, response)
+	wc.writeMu.Unlock()
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrSlowClient
+	}
+	if err == nil || wc.token == "" {
+		return err
+	}
+	// Make sure the reader finds out the connection dropped.
+	conn.Close()
+	return nil
+}
+
+// ack forgets the results up to id, which the client has acknowledged.
+func (wc *WebConn) ack(id uint64) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.ackLocked(id)
+}
+
+// ackLocked is ack, with mu held.
+func (wc *WebConn) ackLocked(id uint64) {
+	n := 0
+	for n < len(wc.unacked) && wc.unacked[n].ID <= id {
+		n++
+	}
+	wc.unacked = wc.unacked[n:]
+}
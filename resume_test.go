@@ -0,0 +1,284 @@
+package stt_challenge
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// newResumeTestServer returns a resumable server whose session transcribes
+// "one", then "two" once next is closed, and then nothing until it is closed.
+func newResumeTestServer(t *testing.T, resumeTimeout time.Duration) (server *Server, wsURL string, next chan struct{}, closed chan struct{}) {
+	t.Helper()
+
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+	next = make(chan struct{})
+	closed = make(chan struct{})
+
+	var sessionCtx context.Context
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).
+		RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+			sessionCtx = ctx
+			return mockSession, nil
+		}).Once()
+
+	result := func(text string) providers.TranscriptionResult {
+		return providers.TranscriptionResult{Text: text, IsFinal: true, ProviderName: "mock-provider", ReceivedAt: time.Now()}
+	}
+	mockSession.EXPECT().ReceiveTranscription().Return(result("one"), nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		select {
+		case <-next:
+			return result("two"), nil
+		case <-sessionCtx.Done():
+			return providers.TranscriptionResult{}, io.EOF
+		}
+	}).Once()
+	mockSession.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		<-sessionCtx.Done()
+		return providers.TranscriptionResult{}, io.EOF
+	}).Maybe()
+	mockSession.EXPECT().Close().RunAndReturn(func() error {
+		close(closed)
+		return nil
+	}).Once()
+
+	cfg := DefaultConfig()
+	cfg.ResumeTimeout = resumeTimeout
+	server = NewWithConfig("8081", cfg, mockProvider)
+	server.log = log.New(io.Discard, "", 0)
+
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	t.Cleanup(testServer.Close)
+	return server, "ws" + strings.TrimPrefix(testServer.URL, "http"), next, closed
+}
+
+// newFloodTestProvider returns a provider whose session transcribes long
+// results as fast as it can until it is closed, and the channel closed with
+// the session.
+func newFloodTestProvider(t *testing.T) (*mocks.MockProvider, chan struct{}) {
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+	closed := make(chan struct{})
+
+	var sessionCtx context.Context
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).
+		RunAndReturn(func(ctx context.Context, _ providers.SessionConfig) (providers.Session, error) {
+			sessionCtx = ctx
+			return mockSession, nil
+		}).Once()
+	text := strings.Repeat("word ", 4000)
+	mockSession.EXPECT().ReceiveTranscription().RunAndReturn(func() (providers.TranscriptionResult, error) {
+		select {
+		case <-sessionCtx.Done():
+			return providers.TranscriptionResult{}, io.EOF
+		default:
+			return providers.TranscriptionResult{Text: text, IsFinal: true, ProviderName: "mock-provider"}, nil
+		}
+	})
+	mockSession.EXPECT().SendAudio(mock.Anything).Return(nil).Maybe()
+	mockSession.EXPECT().Finish().Return(nil).Maybe()
+	mockSession.EXPECT().Close().RunAndReturn(func() error {
+		close(closed)
+		return nil
+	}).Once()
+	return mockProvider, closed
+}
+
+// readSession reads the session response, and the first transcript.
+func readSession(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	require.Equal(t, ResponseSession, response.Type)
+	require.NotEmpty(t, response.Session)
+	return response.Session
+}
+
+// dropped reports whether the server has noticed the connection of the
+// session dropped.
+func dropped(server *Server, token string) bool {
+	server.mu.Lock()
+	wc, ok := server.resumable[token]
+	server.mu.Unlock()
+	return ok && wc.currentConn() == nil
+}
+
+// endSession ends the session with a normal closure.
+func endSession(t *testing.T, conn *websocket.Conn, closed chan struct{}) {
+	t.Helper()
+
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	waitClosed(t, closed)
+}
+
+func waitClosed(t *testing.T, closed chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the session should be closed")
+	}
+}
+
+func TestWebSocketResumeStalledClient(t *testing.T) {
+	mockProvider, closed := newFloodTestProvider(t)
+	cfg := DefaultConfig()
+	cfg.ResumeTimeout = time.Second
+	cfg.PingInterval = 100 * time.Millisecond
+	cfg.Formatting = FormattingConfig{}
+	server, wsURL := newConfigTestServer(t, cfg, mockProvider)
+
+	// The client never reads, so the writes of the server stall
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// It answers no ping either, so it is taken as too slow, or its
+	// connection as dropped and the session ends once it isn't resumed
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session should be closed")
+	}
+	require.Eventually(t, func() bool {
+		return server.Metrics().Connections == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketResume(t *testing.T) {
+	server, wsURL, next, closed := newResumeTestServer(t, 5*time.Second)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	token := readSession(t, conn)
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "one", response.Sentence)
+	assert.Equal(t, uint64(1), response.ID)
+
+	// The connection drops, without a close frame
+	conn.UnderlyingConn().Close()
+	require.Eventually(t, func() bool { return dropped(server, token) }, 2*time.Second, 10*time.Millisecond)
+
+	// Results keep coming while the client is away
+	close(next)
+	time.Sleep(50 * time.Millisecond)
+
+	resumed, _, err := websocket.DefaultDialer.Dial(wsURL+"?resume="+token+"&last=1", nil)
+	require.NoError(t, err)
+	defer resumed.Close()
+	assert.Equal(t, token, readSession(t, resumed))
+	require.NoError(t, resumed.ReadJSON(&response))
+	assert.Equal(t, "two", response.Sentence)
+	assert.Equal(t, uint64(2), response.ID)
+
+	// A normal closure ends the session for good
+	endSession(t, resumed, closed)
+	assert.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return len(server.resumable) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketResumeUnacknowledged(t *testing.T) {
+	server, wsURL, next, closed := newResumeTestServer(t, 5*time.Second)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	token := readSession(t, conn)
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	require.Equal(t, uint64(1), response.ID)
+
+	close(next)
+	require.NoError(t, conn.ReadJSON(&response))
+	require.Equal(t, uint64(2), response.ID)
+	// Only the first result is acknowledged before the connection drops
+	require.NoError(t, conn.WriteJSON(WebSocketRequest{Ack: 1}))
+	require.Eventually(t, func() bool {
+		server.mu.Lock()
+		wc := server.resumable[token]
+		server.mu.Unlock()
+		wc.mu.Lock()
+		defer wc.mu.Unlock()
+		return len(wc.unacked) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	conn.UnderlyingConn().Close()
+	require.Eventually(t, func() bool { return dropped(server, token) }, 2*time.Second, 10*time.Millisecond)
+
+	// The client doesn't say what it has seen, the second result is sent again
+	resumed, _, err := websocket.DefaultDialer.Dial(wsURL+"?resume="+token, nil)
+	require.NoError(t, err)
+	defer resumed.Close()
+	readSession(t, resumed)
+	require.NoError(t, resumed.ReadJSON(&response))
+	assert.Equal(t, "two", response.Sentence)
+	assert.Equal(t, uint64(2), response.ID)
+	endSession(t, resumed, closed)
+}
+
+func TestWebSocketResumeInvalidLast(t *testing.T) {
+	_, wsURL, _, closed := newResumeTestServer(t, 5*time.Second)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	token := readSession(t, conn)
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?resume="+token+"&last=-1", nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	endSession(t, conn, closed)
+}
+
+func TestWebSocketResumeExpired(t *testing.T) {
+	server, wsURL, _, closed := newResumeTestServer(t, 100*time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	token := readSession(t, conn)
+
+	conn.UnderlyingConn().Close()
+	waitClosed(t, closed)
+
+	// The session is gone
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?resume="+token, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.False(t, dropped(server, token))
+}
+
+func TestWebSocketResumeUnknown(t *testing.T) {
+	server := New("8081")
+	server.log = log.New(io.Discard, "", 0)
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http")+"?resume=nope", nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
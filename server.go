@@ -13,19 +13,43 @@ import (
 )
 
 type Server struct {
-	srv       *http.Server
-	log       *log.Logger
+	srv *http.Server
+	log *log.Logger
+
+	// The configuration and providers of new connections, replaced by
+	// Reload, and the connections that started with them.
+	cfgMu     sync.Mutex
+	cfg       Config
 	providers []providers.Provider
+	sessions  *sync.WaitGroup
 
 	// Connection tracking
 	mu    sync.Mutex
 	conns map[*WebConn]struct{}
+	// draining is set by Drain, and drained closed once the last
+	// connection is gone.
+	draining bool
+	drained  chan struct{}
+	// resumable are the sessions clients can resume, by token.
+	resumable map[string]*WebConn
+	// endedQueues are the queue stats of the closed connections.
+	endedQueues QueueStats
 }
 
+// New creates a server listening on port with the default configuration.
 func New(port string, providers ...providers.Provider) *Server {
+	return NewWithConfig(port, DefaultConfig(), providers...)
+}
+
+// NewWithConfig creates a server listening on port with the given configuration.
+func NewWithConfig(port string, cfg Config, providers ...providers.Provider) *Server {
 	logger := log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile)
 	mux := http.NewServeMux()
 
+	if cfg.Vocabularies == nil {
+		cfg.Vocabularies = &VocabularyStore{vocabularies: make(map[string]Vocabulary)}
+	}
+
 	server := &Server{
 		srv: &http.Server{
 			Addr:         ":" + port,
@@ -35,11 +59,19 @@ func New(port string, providers ...providers.Provider) *Server {
 			Handler:      mux,
 		},
 		log:       logger,
+		cfg:       cfg,
 		providers: providers,
+		sessions:  new(sync.WaitGroup),
 		conns:     make(map[*WebConn]struct{}),
+		resumable: make(map[string]*WebConn),
 	}
 
 	mux.HandleFunc("/ws", server.handleWebSocket)
+	mux.HandleFunc("GET /readyz", server.handleReady)
+	mux.HandleFunc("GET /metrics", server.handleMetrics)
+	// The admin API answers 404 while there is no token, which a reload
+	// may set.
+	server.registerAdminRoutes(mux)
 
 	return server
 }
@@ -65,18 +97,30 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// addConn registers a WebSocket connection for tracking
-func (s *Server) addConn(wc *WebConn) {
+// addConn registers a WebSocket connection for tracking. It returns false,
+// leaving the connection out, once the server is draining.
+func (s *Server) addConn(wc *WebConn) bool {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.draining {
+		return false
+	}
 	s.conns[wc] = struct{}{}
-	s.mu.Unlock()
+	return true
 }
 
 // removeConn unregisters a WebSocket connection
 func (s *Server) removeConn(wc *WebConn) {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	delete(s.conns, wc)
-	s.mu.Unlock()
+	s.endQueues(wc)
+	if s.drained != nil && len(s.conns) == 0 {
+		close(s.drained)
+		s.drained = nil
+	}
 }
 
 // stopAllConns gracefully stops all active WebSocket connections
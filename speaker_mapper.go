@@ -0,0 +1,148 @@
+package stt_challenge
+
+import (
+	"time"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// speakerTimelineWindow is how much audio, counted back from the latest
+// word, the speakerMapper keeps words of for matching.
+const speakerTimelineWindow = 30 * time.Second
+
+// speakerKey identifies a speaker label as one provider assigned it.
+type speakerKey struct {
+	provider string
+	channel  int
+	speaker  int
+}
+
+// timelineWord is a word already relabeled with its session speaker.
+type timelineWord struct {
+	provider   string
+	channel    int
+	start, end time.Duration
+	label      int // as the provider labeled it
+	speaker    int // as the session labels it
+}
+
+// speakerMapper keeps speaker labels stable for the whole session, no matter
+// which provider is active. Every provider numbers speakers on its own, so
+// Google's speaker 1 may well be Deepgram's speaker 2.
+//
+// The first provider to label a speaker becomes the anchor, and its labels
+// are the session labels. The labels of every other provider are mapped onto
+// them by voting: all providers transcribe the same audio, so a word they
+// both heard overlaps in time, and the overlap is a vote for the two labels
+// being the same person. Votes are counted whichever of the two words comes
+// in first. Until a label has any votes, it is used as is.
+//
+// It is only used from the selector goroutine, so it needs no locking.
+type speakerMapper struct {
+	anchor   string
+	votes    map[speakerKey]map[int]time.Duration
+	timeline []timelineWord
+}
+
+func newSpeakerMapper() *speakerMapper {
+	return &speakerMapper{
+		votes: make(map[speakerKey]map[int]time.Duration),
+	}
+}
+
+// mapResult relabels the words and the speaker of a result in place with
+// session speakers. Results without speaker labels are left alone.
+func (m *speakerMapper) mapResult(result *providers.TranscriptionResult) {
+	if providers.DominantSpeaker(result.Words) == 0 {
+		return
+	}
+
+	if m.anchor == "" {
+		m.anchor = result.ProviderName
+	}
+
+	// Vote with all the words first, so that the whole result is
+	// relabeled with the same mapping.
+	for _, w := range result.Words {
+		if w.Speaker != 0 {
+			m.vote(result.ProviderName, result.Channel, w)
+		}
+	}
+
+	var latest time.Duration
+	for i, w := range result.Words {
+		if w.Speaker == 0 {
+			continue
+		}
+		word := timelineWord{
+			provider: result.ProviderName,
+			channel:  result.Channel,
+			start:    w.Start,
+			end:      w.End,
+			label:    w.Speaker,
+			speaker:  w.Speaker,
+		}
+		if result.ProviderName != m.anchor {
+			word.speaker = m.lookup(speakerKey{result.ProviderName, result.Channel, w.Speaker})
+			result.Words[i].Speaker = word.speaker
+		}
+		m.timeline = append(m.timeline, word)
+		latest = max(latest, w.End)
+	}
+	result.Speaker = providers.DominantSpeaker(result.Words)
+	m.trim(latest - speakerTimelineWindow)
+}
+
+// vote counts the overlap of a word with the words other providers said on
+// the same channel. Words of the anchor vote for the labels of the words they
+// overlap, and words of other providers vote for their own label.
+func (m *speakerMapper) vote(provider string, channel int, w providers.Word) {
+	for _, t := range m.timeline {
+		if t.provider == provider || t.channel != channel {
+			continue
+		}
+		overlap := min(w.End, t.end) - max(w.Start, t.start)
+		if overlap <= 0 {
+			continue
+		}
+
+		switch {
+		case provider == m.anchor:
+			m.addVote(speakerKey{t.provider, channel, t.label}, w.Speaker, overlap)
+		case t.provider == m.anchor:
+			m.addVote(speakerKey{provider, channel, w.Speaker}, t.speaker, overlap)
+		}
+	}
+}
+
+func (m *speakerMapper) addVote(key speakerKey, speaker int, overlap time.Duration) {
+	if m.votes[key] == nil {
+		m.votes[key] = make(map[int]time.Duration)
+	}
+	m.votes[key][speaker] += overlap
+}
+
+// lookup returns the session speaker with the most votes for the label,
+// or the label itself if it has none yet.
+func (m *speakerMapper) lookup(key speakerKey) int {
+	best := key.speaker
+	var bestVotes time.Duration
+	for speaker, votes := range m.votes[key] {
+		// Break ties on the lower label, since map order is random.
+		if votes > bestVotes || (votes == bestVotes && speaker < best) {
+			best, bestVotes = speaker, votes
+		}
+	}
+	return best
+}
+
+// trim drops the words that ended before cutoff.
+func (m *speakerMapper) trim(cutoff time.Duration) {
+	filtered := m.timeline[:0]
+	for _, t := range m.timeline {
+		if t.end >= cutoff {
+			filtered = append(filtered, t)
+		}
+	}
+	m.timeline = filtered
+}
@@ -0,0 +1,141 @@
+package stt_challenge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// diarizedResult builds a final result with one word per second of audio,
+// starting at the given second, spoken by the given speakers.
+func diarizedResult(provider string, start int, speakers ...int) providers.TranscriptionResult {
+	result := providers.TranscriptionResult{
+		Text:         "words",
+		IsFinal:      true,
+		ProviderName: provider,
+	}
+	for i, speaker := range speakers {
+		at := time.Duration(start+i) * time.Second
+		result.Words = append(result.Words, providers.Word{
+			Text:    "word",
+			Start:   at,
+			End:     at + 800*time.Millisecond,
+			Speaker: speaker,
+		})
+	}
+	result.Speaker = providers.DominantSpeaker(result.Words)
+	return result
+}
+
+// wordSpeakers returns the speaker of every word in a result.
+func wordSpeakers(result providers.TranscriptionResult) []int {
+	var speakers []int
+	for _, w := range result.Words {
+		speakers = append(speakers, w.Speaker)
+	}
+	return speakers
+}
+
+func TestSpeakerMapper_MapResult(t *testing.T) {
+	t.Run("anchor labels are kept", func(t *testing.T) {
+		m := newSpeakerMapper()
+
+		result := diarizedResult("google", 0, 2, 2, 1)
+		m.mapResult(&result)
+		assert.Equal(t, []int{2, 2, 1}, wordSpeakers(result))
+		assert.Equal(t, 2, result.Speaker)
+	})
+
+	t.Run("swapped labels are mapped onto the anchor", func(t *testing.T) {
+		m := newSpeakerMapper()
+
+		// Google calls the first person 1 and the second 2, Deepgram the other way around
+		google := diarizedResult("google", 0, 1, 1, 2, 2)
+		m.mapResult(&google)
+
+		deepgram := diarizedResult("deepgram", 0, 2, 2, 1, 1)
+		m.mapResult(&deepgram)
+		assert.Equal(t, []int{1, 1, 2, 2}, wordSpeakers(deepgram))
+
+		// Later results keep the mapping, even without anything to overlap with
+		deepgram = diarizedResult("deepgram", 10, 1, 2)
+		m.mapResult(&deepgram)
+		assert.Equal(t, []int{2, 1}, wordSpeakers(deepgram))
+		assert.Equal(t, 2, deepgram.Speaker)
+	})
+
+	t.Run("votes count whichever provider comes first", func(t *testing.T) {
+		m := newSpeakerMapper()
+
+		// Google is the anchor, but is slower than Deepgram
+		google := diarizedResult("google", 0, 1)
+		m.mapResult(&google)
+
+		deepgram := diarizedResult("deepgram", 5, 2, 2, 3)
+		m.mapResult(&deepgram)
+		// No overlap yet, so the labels are used as is
+		assert.Equal(t, []int{2, 2, 3}, wordSpeakers(deepgram))
+
+		google = diarizedResult("google", 5, 1, 1, 2)
+		m.mapResult(&google)
+
+		deepgram = diarizedResult("deepgram", 20, 2, 3)
+		m.mapResult(&deepgram)
+		assert.Equal(t, []int{1, 2}, wordSpeakers(deepgram))
+	})
+
+	t.Run("channels are mapped separately", func(t *testing.T) {
+		m := newSpeakerMapper()
+
+		google := diarizedResult("google", 0, 1, 1)
+		google.Channel = 1
+		m.mapResult(&google)
+
+		// Overlaps in time, but on another channel
+		deepgram := diarizedResult("deepgram", 0, 2, 2)
+		deepgram.Channel = 2
+		m.mapResult(&deepgram)
+		assert.Equal(t, []int{2, 2}, wordSpeakers(deepgram))
+	})
+
+	t.Run("results without speakers are left alone", func(t *testing.T) {
+		m := newSpeakerMapper()
+
+		result := diarizedResult("google", 0, 0, 0)
+		m.mapResult(&result)
+		assert.Equal(t, []int{0, 0}, wordSpeakers(result))
+		assert.Empty(t, m.anchor)
+		assert.Empty(t, m.timeline)
+	})
+
+	t.Run("old words are dropped from the timeline", func(t *testing.T) {
+		m := newSpeakerMapper()
+
+		result := diarizedResult("google", 0, 1, 1)
+		m.mapResult(&result)
+		result = diarizedResult("google", 60, 1)
+		m.mapResult(&result)
+
+		assert.Len(t, m.timeline, 1)
+		assert.Equal(t, 60*time.Second, m.timeline[0].start)
+	})
+}
+
+func TestSpeakerMapper_Lookup(t *testing.T) {
+	m := newSpeakerMapper()
+	key := speakerKey{provider: "deepgram", speaker: 3}
+
+	// Identity without votes
+	assert.Equal(t, 3, m.lookup(key))
+
+	m.addVote(key, 1, time.Second)
+	m.addVote(key, 2, 2*time.Second)
+	assert.Equal(t, 2, m.lookup(key))
+
+	// Ties go to the lower label
+	m.addVote(key, 1, time.Second)
+	assert.Equal(t, 1, m.lookup(key))
+}
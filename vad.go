@@ -0,0 +1,167 @@
+package stt_challenge
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// VADConfig configures the energy based voice activity detector.
+type VADConfig struct {
+	// Enabled turns on silence gating for new connections.
+	Enabled bool
+
+	// ThresholdDBFS is the RMS level, in dBFS, above which a chunk of
+	// audio counts as speech.
+	ThresholdDBFS float64
+
+	// Hangover is how long audio keeps flowing after the last chunk of
+	// speech, so that pauses between words are not gated.
+	Hangover time.Duration
+
+	// PreRoll is how much gated audio is sent along with a speech onset,
+	// so that the beginning of the first word is not clipped.
+	PreRoll time.Duration
+
+	// KeepAliveInterval is how often a short frame of silence is sent
+	// to the providers while audio is being gated, so that their
+	// streams do not time out.
+	KeepAliveInterval time.Duration
+}
+
+// DefaultVADConfig returns a VADConfig with sensible values for speech.
+// Gating is disabled by default.
+func DefaultVADConfig() VADConfig {
+	return VADConfig{
+		ThresholdDBFS:     -40,
+		Hangover:          time.Second,
+		PreRoll:           300 * time.Millisecond,
+		KeepAliveInterval: 5 * time.Second,
+	}
+}
+
+// keepAliveDuration is the length of the silence frame sent as a keep-alive.
+const keepAliveDuration = 100 * time.Millisecond
+
+// VAD is an energy based voice activity detector for 16-bit little-endian PCM.
+// Time is measured in audio played rather than wall clock, so it behaves
+// the same for live and replayed audio.
+type VAD struct {
+	threshold     float64
+	hangoverBytes int
+	hangoverLeft  int
+}
+
+// NewVAD creates a voice activity detector for audio with the given byte rate.
+func NewVAD(cfg VADConfig, bytesPerSecond int) *VAD {
+	return &VAD{
+		threshold:     math.Pow(10, cfg.ThresholdDBFS/20),
+		hangoverBytes: durationToBytes(cfg.Hangover, bytesPerSecond, 2),
+	}
+}
+
+// IsSpeech reports whether the chunk contains speech, or follows speech
+// closely enough to still be within the hangover period.
+func (v *VAD) IsSpeech(chunk []byte) bool {
+	if rmsLevel(chunk) >= v.threshold {
+		v.hangoverLeft = v.hangoverBytes
+		return true
+	}
+
+	if v.hangoverLeft > 0 {
+		v.hangoverLeft -= len(chunk)
+		return true
+	}
+	return false
+}
+
+// rmsLevel returns the RMS level of 16-bit little-endian samples, where 1 is full scale.
+func rmsLevel(chunk []byte) float64 {
+	n := len(chunk) / 2
+	if n == 0 {
+		return 0
+	}
+
+	var sum float64
+	for i := 0; i < n; i++ {
+		s := float64(int16(binary.LittleEndian.Uint16(chunk[2*i:]))) / 32768
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(n))
+}
+
+// durationToBytes converts an audio duration to a whole number of frames,
+// where a frame holds one sample for every channel.
+func durationToBytes(d time.Duration, bytesPerSecond, frameSize int) int {
+	n := int(d.Seconds() * float64(bytesPerSecond))
+	return n - n%frameSize
+}
+
+// vadGate wraps a session and stops long silences from reaching it.
+// Only SendAudio is intercepted, everything else is passed through.
+type vadGate struct {
+	providers.Session
+
+	vad            *VAD
+	bytesPerSecond int
+	frameSize      int
+	preRoll        []byte
+	preRollBytes   int
+	keepAliveBytes int
+	sinceForward   int
+	gatedBytes     int64
+}
+
+// newVADGate creates a vadGate in front of session for audio with the given
+// byte rate. frameSize is the size of one sample across all channels, so that
+// buffered audio is never split in the middle of a frame.
+func newVADGate(session providers.Session, cfg VADConfig, bytesPerSecond, frameSize int) *vadGate {
+	return &vadGate{
+		Session:        session,
+		vad:            NewVAD(cfg, bytesPerSecond),
+		bytesPerSecond: bytesPerSecond,
+		frameSize:      frameSize,
+		preRollBytes:   durationToBytes(cfg.PreRoll, bytesPerSecond, frameSize),
+		keepAliveBytes: durationToBytes(cfg.KeepAliveInterval, bytesPerSecond, frameSize),
+	}
+}
+
+// SendAudio forwards speech to the wrapped session, together with the
+// pre-roll buffered before it. Silence is held back in the pre-roll buffer.
+func (g *vadGate) SendAudio(audioData []byte) error {
+	if g.vad.IsSpeech(audioData) {
+		g.sinceForward = 0
+		if len(g.preRoll) > 0 {
+			preRoll := g.preRoll
+			g.preRoll = nil
+			g.gatedBytes -= int64(len(preRoll))
+			if err := g.Session.SendAudio(preRoll); err != nil {
+				return err
+			}
+		}
+		return g.Session.SendAudio(audioData)
+	}
+
+	g.gatedBytes += int64(len(audioData))
+	g.preRoll = append(g.preRoll, audioData...)
+	if extra := len(g.preRoll) - g.preRollBytes; extra > 0 {
+		if rem := extra % g.frameSize; rem != 0 {
+			extra = min(extra+g.frameSize-rem, len(g.preRoll))
+		}
+		g.preRoll = append(g.preRoll[:0], g.preRoll[extra:]...)
+	}
+
+	g.sinceForward += len(audioData)
+	if g.keepAliveBytes > 0 && g.sinceForward >= g.keepAliveBytes {
+		g.sinceForward = 0
+		return g.Session.SendAudio(make([]byte, durationToBytes(keepAliveDuration, g.bytesPerSecond, g.frameSize)))
+	}
+	return nil
+}
+
+// gatedSeconds returns how many seconds of audio were held back from the providers.
+func (g *vadGate) gatedSeconds() float64 {
+	return float64(g.gatedBytes) / float64(g.bytesPerSecond)
+}
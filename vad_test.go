@@ -0,0 +1,110 @@
+package stt_challenge
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers/mocks"
+)
+
+// 100ms of 16kHz s16le audio
+const testChunkBytes = 3200
+
+// testChunk returns 100ms of audio with a square wave of the given amplitude.
+func testChunk(amplitude int16) []byte {
+	chunk := make([]byte, testChunkBytes)
+	for i := 0; i < len(chunk)/2; i++ {
+		v := amplitude
+		if i%2 == 1 {
+			v = -amplitude
+		}
+		binary.LittleEndian.PutUint16(chunk[2*i:], uint16(v))
+	}
+	return chunk
+}
+
+func testVADConfig() VADConfig {
+	return VADConfig{
+		Enabled:           true,
+		ThresholdDBFS:     -40,
+		Hangover:          200 * time.Millisecond,
+		PreRoll:           200 * time.Millisecond,
+		KeepAliveInterval: time.Second,
+	}
+}
+
+func TestRMSLevel(t *testing.T) {
+	assert.Equal(t, 0.0, rmsLevel(nil))
+	assert.Equal(t, 0.0, rmsLevel(testChunk(0)))
+	assert.InDelta(t, 0.5, rmsLevel(testChunk(16384)), 0.001)
+	assert.InDelta(t, 1.0, rmsLevel(testChunk(-32768)), 0.001)
+}
+
+func TestVAD_IsSpeech(t *testing.T) {
+	vad := NewVAD(testVADConfig(), 32000)
+
+	// -40 dBFS is an amplitude of about 328
+	assert.False(t, vad.IsSpeech(testChunk(0)), "silence before speech")
+	assert.False(t, vad.IsSpeech(testChunk(200)), "noise below threshold")
+	assert.True(t, vad.IsSpeech(testChunk(5000)), "speech")
+
+	// The 200ms hangover keeps two chunks of silence flowing
+	assert.True(t, vad.IsSpeech(testChunk(0)), "first chunk of hangover")
+	assert.True(t, vad.IsSpeech(testChunk(0)), "second chunk of hangover")
+	assert.False(t, vad.IsSpeech(testChunk(0)), "silence after hangover")
+
+	// Speech resets the hangover
+	assert.True(t, vad.IsSpeech(testChunk(5000)), "speech again")
+	assert.True(t, vad.IsSpeech(testChunk(0)), "hangover after second speech")
+}
+
+func TestVADGate_SendAudio(t *testing.T) {
+	t.Run("gates silence and sends pre-roll with speech", func(t *testing.T) {
+		mockSession := mocks.NewMockSession(t)
+		gate := newVADGate(mockSession, testVADConfig(), 32000, 2)
+
+		silence := testChunk(1)
+		speech := testChunk(5000)
+
+		// Three chunks of silence, of which only the last two fit in the pre-roll
+		for i := 0; i < 3; i++ {
+			require.NoError(t, gate.SendAudio(silence))
+		}
+		assert.InDelta(t, 0.3, gate.gatedSeconds(), 0.001)
+
+		preRoll := append(append([]byte{}, silence...), silence...)
+		mockSession.EXPECT().SendAudio(preRoll).Return(nil).Once()
+		mockSession.EXPECT().SendAudio(speech).Return(nil).Once()
+		require.NoError(t, gate.SendAudio(speech))
+
+		// The pre-roll was sent after all
+		assert.InDelta(t, 0.1, gate.gatedSeconds(), 0.001)
+	})
+
+	t.Run("sends keep-alives while gating", func(t *testing.T) {
+		mockSession := mocks.NewMockSession(t)
+		gate := newVADGate(mockSession, testVADConfig(), 32000, 2)
+
+		keepAlive := make([]byte, 3200)
+		mockSession.EXPECT().SendAudio(keepAlive).Return(nil).Twice()
+
+		// 2.5 seconds of silence with a keep-alive every second
+		for i := 0; i < 25; i++ {
+			require.NoError(t, gate.SendAudio(testChunk(0)))
+		}
+		assert.InDelta(t, 2.5, gate.gatedSeconds(), 0.001)
+	})
+}
+
+func TestDurationToBytes(t *testing.T) {
+	// 16kHz mono
+	assert.Equal(t, 3200, durationToBytes(100*time.Millisecond, 32000, 2))
+	// 11.025kHz mono, rounded down to a whole sample
+	assert.Equal(t, 2204, durationToBytes(100*time.Millisecond, 22050, 2))
+	// 11.025kHz stereo, rounded down to a whole frame
+	assert.Equal(t, 4408, durationToBytes(100*time.Millisecond, 44100, 4))
+}
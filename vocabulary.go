@@ -0,0 +1,278 @@
+package stt_challenge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+// ErrVocabularyNotFound is returned for names the VocabularyStore doesn't know.
+var ErrVocabularyNotFound = errors.New("vocabulary not found")
+
+// vocabularyName is what the names of vocabularies and their classes must
+// look like. They end up in file names and in provider requests, so they
+// are kept to what every provider accepts.
+var vocabularyName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Vocabulary is a named, reusable set of phrase hints and custom classes,
+// like "medical" or "product-catalog". Clients refer to vocabularies by name
+// when connecting, instead of sending the phrases every time.
+type Vocabulary struct {
+	Name    string             `json:"name"`
+	Phrases []VocabularyPhrase `json:"phrases,omitempty"`
+	Classes []VocabularyClass  `json:"classes,omitempty"`
+}
+
+// VocabularyPhrase is a phrase hint of a Vocabulary. It can refer to the
+// classes of the same vocabulary as "${name}".
+type VocabularyPhrase struct {
+	Text  string  `json:"text"`
+	Boost float32 `json:"boost,omitempty"`
+}
+
+// VocabularyClass is a custom class of a Vocabulary.
+type VocabularyClass struct {
+	Name  string   `json:"name"`
+	Items []string `json:"items"`
+}
+
+// Validate checks that the vocabulary is well-formed.
+func (v Vocabulary) Validate() error {
+	if !vocabularyName.MatchString(v.Name) {
+		return fmt.Errorf("invalid vocabulary name %q", v.Name)
+	}
+	if len(v.Phrases) == 0 && len(v.Classes) == 0 {
+		return fmt.Errorf("vocabulary %q has no phrases or classes", v.Name)
+	}
+
+	classes := make(map[string]bool, len(v.Classes))
+	for _, c := range v.Classes {
+		if !vocabularyName.MatchString(c.Name) {
+			return fmt.Errorf("invalid class name %q", c.Name)
+		}
+		if classes[c.Name] {
+			return fmt.Errorf("duplicate class %q", c.Name)
+		}
+		classes[c.Name] = true
+		if len(c.Items) == 0 {
+			return fmt.Errorf("class %q has no items", c.Name)
+		}
+		for _, item := range c.Items {
+			if strings.TrimSpace(item) == "" {
+				return fmt.Errorf("class %q has an empty item", c.Name)
+			}
+		}
+	}
+
+	for _, p := range v.Phrases {
+		if strings.TrimSpace(p.Text) == "" {
+			return errors.New("phrase with no text")
+		}
+		if math.IsNaN(float64(p.Boost)) || math.IsInf(float64(p.Boost), 0) {
+			return fmt.Errorf("phrase %q: boost must be a finite number", p.Text)
+		}
+		for _, name := range providers.ClassReferences(p.Text) {
+			if !classes[name] {
+				return fmt.Errorf("phrase %q refers to unknown class %q", p.Text, name)
+			}
+		}
+	}
+	return nil
+}
+
+// VocabularyStore holds the vocabularies of a server. With a directory, every
+// vocabulary is kept in a JSON file of its own, named after it.
+type VocabularyStore struct {
+	dir string
+
+	mu           sync.RWMutex
+	vocabularies map[string]Vocabulary
+}
+
+// NewVocabularyStore creates a store backed by dir, loading every vocabulary
+// already in it. An empty dir keeps the vocabularies in memory only.
+func NewVocabularyStore(dir string) (*VocabularyStore, error) {
+	s := &VocabularyStore{
+		dir:          dir,
+		vocabularies: make(map[string]Vocabulary),
+	}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create vocabulary directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		v, err := readVocabulary(file)
+		if err != nil {
+			return nil, err
+		}
+		s.vocabularies[v.Name] = v
+	}
+	return s, nil
+}
+
+// readVocabulary reads and validates a vocabulary file. The name may be left
+// out of the file, but must otherwise match the file name.
+func readVocabulary(file string) (Vocabulary, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Vocabulary{}, err
+	}
+
+	var v Vocabulary
+	if err := json.Unmarshal(data, &v); err != nil {
+		return Vocabulary{}, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), ".json")
+	if v.Name == "" {
+		v.Name = name
+	}
+	if v.Name != name {
+		return Vocabulary{}, fmt.Errorf("%s: vocabulary is named %q", file, v.Name)
+	}
+	if err := v.Validate(); err != nil {
+		return Vocabulary{}, fmt.Errorf("%s: %w", file, err)
+	}
+	return v, nil
+}
+
+// Get returns the vocabulary with the given name.
+func (s *VocabularyStore) Get(name string) (Vocabulary, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.vocabularies[name]
+	return v, ok
+}
+
+// List returns the names of all vocabularies, sorted.
+func (s *VocabularyStore) List() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.vocabularies))
+	for name := range s.vocabularies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Put validates and stores a vocabulary, replacing any with the same name.
+// Sessions already running keep the vocabulary they started with.
+func (s *VocabularyStore) Put(v Vocabulary) error {
+	if err := v.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir != "" {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		// Write to a temporary file first, so that a failed write
+		// never leaves a truncated vocabulary behind.
+		tmp, err := os.CreateTemp(s.dir, v.Name+".*.tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), s.path(v.Name)); err != nil {
+			return err
+		}
+	}
+
+	s.vocabularies[v.Name] = v
+	return nil
+}
+
+// Delete removes a vocabulary.
+func (s *VocabularyStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.vocabularies[name]; !ok {
+		return ErrVocabularyNotFound
+	}
+	if s.dir != "" {
+		if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	delete(s.vocabularies, name)
+	return nil
+}
+
+func (s *VocabularyStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// resolve adds the phrases and classes of the named vocabularies to config.
+// Classes of the same name from different vocabularies are merged. It fails
+// for unknown vocabularies, and for phrases, including the ones the client
+// sent itself, that refer to classes none of the vocabularies define.
+func (s *VocabularyStore) resolve(names []string, config *providers.SessionConfig) error {
+	classIndex := make(map[string]int)
+	for i, c := range config.CustomClasses {
+		classIndex[c.Name] = i
+	}
+
+	for _, name := range names {
+		v, ok := s.Get(name)
+		if !ok {
+			return fmt.Errorf("unknown vocabulary %q", name)
+		}
+
+		for _, p := range v.Phrases {
+			config.Phrases = append(config.Phrases, providers.PhraseHint{Text: p.Text, Boost: p.Boost})
+		}
+		for _, c := range v.Classes {
+			i, ok := classIndex[c.Name]
+			if !ok {
+				classIndex[c.Name] = len(config.CustomClasses)
+				config.CustomClasses = append(config.CustomClasses, providers.CustomClass{
+					Name:  c.Name,
+					Items: slices.Clone(c.Items),
+				})
+				continue
+			}
+			for _, item := range c.Items {
+				if !slices.Contains(config.CustomClasses[i].Items, item) {
+					config.CustomClasses[i].Items = append(config.CustomClasses[i].Items, item)
+				}
+			}
+		}
+	}
+
+	for _, p := range config.Phrases {
+		for _, name := range providers.ClassReferences(p.Text) {
+			if _, ok := classIndex[name]; !ok {
+				return fmt.Errorf("phrase %q refers to unknown class %q", p.Text, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package stt_challenge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agnivade/stt_challenge/providers"
+)
+
+var medical = Vocabulary{
+	Name: "medical",
+	Phrases: []VocabularyPhrase{
+		{Text: "take ${drugs}", Boost: 10},
+		{Text: "blood pressure"},
+	},
+	Classes: []VocabularyClass{
+		{Name: "drugs", Items: []string{"ibuprofen", "paracetamol"}},
+	},
+}
+
+func TestVocabulary_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		vocab Vocabulary
+		err   string
+	}{
+		{
+			name:  "valid",
+			vocab: medical,
+		},
+		{
+			name:  "path in name",
+			vocab: Vocabulary{Name: "../medical", Phrases: medical.Phrases},
+			err:   `invalid vocabulary name "../medical"`,
+		},
+		{
+			name:  "empty",
+			vocab: Vocabulary{Name: "medical"},
+			err:   `vocabulary "medical" has no phrases or classes`,
+		},
+		{
+			name: "class without items",
+			vocab: Vocabulary{
+				Name:    "medical",
+				Classes: []VocabularyClass{{Name: "drugs"}},
+			},
+			err: `class "drugs" has no items`,
+		},
+		{
+			name: "duplicate class",
+			vocab: Vocabulary{
+				Name:    "medical",
+				Classes: []VocabularyClass{medical.Classes[0], medical.Classes[0]},
+			},
+			err: `duplicate class "drugs"`,
+		},
+		{
+			name: "unknown class",
+			vocab: Vocabulary{
+				Name:    "medical",
+				Phrases: []VocabularyPhrase{{Text: "take ${pills}"}},
+			},
+			err: `phrase "take ${pills}" refers to unknown class "pills"`,
+		},
+		{
+			name: "empty phrase",
+			vocab: Vocabulary{
+				Name:    "medical",
+				Phrases: []VocabularyPhrase{{Text: " "}},
+			},
+			err: "phrase with no text",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.vocab.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVocabularyStore_Persistence(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewVocabularyStore(dir)
+	require.NoError(t, err)
+	assert.Empty(t, store.List())
+
+	require.NoError(t, store.Put(medical))
+	assert.FileExists(t, filepath.Join(dir, "medical.json"))
+
+	// Invalid vocabularies are not stored
+	assert.Error(t, store.Put(Vocabulary{Name: "empty"}))
+	assert.NoFileExists(t, filepath.Join(dir, "empty.json"))
+
+	// Files are loaded by a new store, and may leave out the name
+	err = os.WriteFile(filepath.Join(dir, "catalog.json"), []byte(`{"phrases": [{"text": "Acme Cloud"}]}`), 0o644)
+	require.NoError(t, err)
+
+	store, err = NewVocabularyStore(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"catalog", "medical"}, store.List())
+	got, ok := store.Get("medical")
+	require.True(t, ok)
+	assert.Equal(t, medical, got)
+
+	require.NoError(t, store.Delete("medical"))
+	assert.NoFileExists(t, filepath.Join(dir, "medical.json"))
+	assert.ErrorIs(t, store.Delete("medical"), ErrVocabularyNotFound)
+	_, ok = store.Get("medical")
+	assert.False(t, ok)
+}
+
+func TestVocabularyStore_LoadErrors(t *testing.T) {
+	t.Run("name mismatch", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "medical.json"), []byte(`{"name": "legal", "phrases": [{"text": "tort"}]}`), 0o644)
+		require.NoError(t, err)
+
+		_, err = NewVocabularyStore(dir)
+		assert.ErrorContains(t, err, `vocabulary is named "legal"`)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "medical.json"), []byte(`{`), 0o644))
+
+		_, err := NewVocabularyStore(dir)
+		assert.ErrorContains(t, err, "failed to parse")
+	})
+}
+
+func TestVocabularyStore_Resolve(t *testing.T) {
+	store, err := NewVocabularyStore("")
+	require.NoError(t, err)
+	require.NoError(t, store.Put(medical))
+	require.NoError(t, store.Put(Vocabulary{
+		Name:    "pharmacy",
+		Classes: []VocabularyClass{{Name: "drugs", Items: []string{"ibuprofen", "aspirin"}}},
+	}))
+
+	t.Run("phrases and merged classes", func(t *testing.T) {
+		config := providers.SessionConfig{
+			Phrases: []providers.PhraseHint{{Text: "order ${drugs}"}},
+		}
+		require.NoError(t, store.resolve([]string{"medical", "pharmacy"}, &config))
+
+		assert.Equal(t, []providers.PhraseHint{
+			{Text: "order ${drugs}"},
+			{Text: "take ${drugs}", Boost: 10},
+			{Text: "blood pressure"},
+		}, config.Phrases)
+		assert.Equal(t, []providers.CustomClass{
+			{Name: "drugs", Items: []string{"ibuprofen", "paracetamol", "aspirin"}},
+		}, config.CustomClasses)
+
+		// The stored vocabularies are left alone
+		got, _ := store.Get("medical")
+		assert.Equal(t, medical, got)
+	})
+
+	t.Run("unknown vocabulary", func(t *testing.T) {
+		var config providers.SessionConfig
+		assert.EqualError(t, store.resolve([]string{"legal"}, &config), `unknown vocabulary "legal"`)
+	})
+
+	t.Run("session phrase with unknown class", func(t *testing.T) {
+		config := providers.SessionConfig{
+			Phrases: []providers.PhraseHint{{Text: "order ${drugs}"}},
+		}
+		assert.EqualError(t, store.resolve(nil, &config), `phrase "order ${drugs}" refers to unknown class "drugs"`)
+	})
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -18,15 +22,87 @@ import (
 // It contains raw audio bytes that will be forwarded to the Google Speech API.
 type WebSocketRequest struct {
 	Buf []byte `json:"buf"`
+	// Ack acknowledges the results up to this ID, which the server then
+	// no longer sends again if the session is resumed. Requests only
+	// acknowledging results carry no audio.
+	Ack uint64 `json:"ack,omitempty"`
+	// Type is empty for audio. Requests of other types, like
+	// RequestFinish, carry no audio.
+	Type string `json:"type,omitempty"`
 }
 
+// RequestFinish tells the server that no more audio is coming. The server
+// sends the results of the audio it already has, then a
+// ResponseEndOfTranscript response, and closes the connection.
+const RequestFinish = "finish"
+
 // WebSocketResponse represents a transcription result sent from the server to the client.
 // It contains the final transcribed text and confidence score from the transcription provider.
 type WebSocketResponse struct {
+	// ID numbers the transcripts of a session, from 1 up. A result sent
+	// again after resuming the session keeps its ID.
+	ID         uint64  `json:"id,omitempty"`
+	Sentence   string  `json:"sentence"`
+	Confidence float32 `json:"confidence"`
+	// Channel is the 1-based audio channel of the sentence, only
+	// set when the client asked for separate channels.
+	Channel int `json:"channel,omitempty"`
+	// Language is the language the sentence was detected in, when
+	// the provider reports it.
+	Language string `json:"language,omitempty"`
+	// Speaker and Words are only set when the client asked for diarization.
+	Speaker int             `json:"speaker,omitempty"`
+	Words   []WebSocketWord `json:"words,omitempty"`
+	// Alternatives are the next best transcripts of the sentence, most
+	// likely first, when the client asked for more than one.
+	Alternatives []WebSocketAlternative `json:"alternatives,omitempty"`
+	// Type is empty for transcripts. Responses of other types, like
+	// ResponseDraining, carry a Message instead of a sentence.
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Session is the token to resume the session with, in
+	// ResponseSession responses.
+	Session string `json:"session,omitempty"`
+}
+
+// Types of the responses that aren't transcripts.
+const (
+	// ResponseDraining tells the client that the server is shutting down.
+	// The session goes on for a while, but the client should reconnect,
+	// to be served by another server.
+	ResponseDraining = "draining"
+
+	// ResponseSession gives the client the token of its session, to
+	// resume it with if the connection drops. It is sent first, and again
+	// once the session is resumed, when the server allows resuming.
+	ResponseSession = "session"
+
+	// ResponseEndOfTranscript follows the last result of a session the
+	// client finished with a RequestFinish request.
+	ResponseEndOfTranscript = "end_of_transcript"
+
+	// ResponseIdle tells the client that its session is ending, since it
+	// sent no audio for the idle timeout. The results of the audio it
+	// sent follow, then a ResponseEndOfTranscript response.
+	ResponseIdle = "idle"
+)
+
+// WebSocketAlternative is another transcript of a WebSocketResponse.
+type WebSocketAlternative struct {
 	Sentence   string  `json:"sentence"`
 	Confidence float32 `json:"confidence"`
 }
 
+// WebSocketWord is a single word of a WebSocketResponse. Start and End are
+// in seconds from the beginning of the session's audio.
+type WebSocketWord struct {
+	Word       string  `json:"word"`
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Confidence float32 `json:"confidence"`
+	Speaker    int     `json:"speaker,omitempty"`
+}
+
 // WebConn represents a WebSocket connection that bridges client audio data
 // with speech transcription providers. It manages bidirectional
 // communication between the WebSocket client and the transcription service.
@@ -35,85 +111,314 @@ type WebConn struct {
 	log     *log.Logger
 	wg      sync.WaitGroup
 	session providers.Session
+	diarize bool
+
+	// selector is the ProviderSelector under session, for its queue
+	// stats. slow is set once the client is disconnected for not keeping
+	// up with its results.
+	selector *ProviderSelector
+	slow     atomic.Bool
+
+	// writeMu serializes the writes of the writer with the control
+	// responses of the server. Every write has a deadline, so that a
+	// client no longer reading can't hold it for long. mu guards conn,
+	// which is nil while a resumable session waits for its client to come
+	// back, lastID, unacked and ended. It is never held while writing, so
+	// that the connection can always be closed or handed over. Whoever
+	// needs both takes writeMu first.
+	writeMu    sync.Mutex
+	mu         sync.Mutex
+	writerDone chan struct{}
+	// closing is closed once the session is to end, even if its client
+	// could still resume it. It is nil for connections never started.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Resuming, for sessions with a token. unacked are the results the
+	// client hasn't acknowledged yet, to send again once resumed.
+	token         string
+	resumeTimeout time.Duration
+	attach        chan resumption
+	lastID        uint64
+	unacked       []WebSocketResponse
+	// draining is set once the server has stopped reading the audio of
+	// the connection, to close it.
+	draining     atomic.Bool
+	flushTimeout time.Duration
+
+	// finishRequested is set once the client has sent RequestFinish, and
+	// ended once the writer has sent every result after it. finished is
+	// only touched by the reader, and ended is guarded by mu.
+	finishRequested atomic.Bool
+	finished        bool
+	ended           bool
+
+	// Keepalive. The connection is pinged every pingInterval, and idle is
+	// called once no audio came in for idleTimeout, if set.
+	pingInterval time.Duration
+	idleTimeout  time.Duration
+	idleTimer    *time.Timer
+	idled        atomic.Bool
+
+	// Session statistics, only touched by the reader.
+	encoding       providers.AudioEncoding
+	bytesPerSecond int
+	// pricePerMinute is what a minute of audio costs, with every
+	// provider transcribing it.
+	pricePerMinute float64
+	audioBytes     int64
+	gate           *vadGate
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  8192,
+	WriteBufferSize: 8192,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  8192,
-		WriteBufferSize: 8192,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+	if s.isDraining() {
+		http.Error(w, "server is draining, connect to another one", http.StatusServiceUnavailable)
+		return
 	}
-
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		s.log.Printf("WebSocket upgrade failed: %v\n", err)
+	if token := r.URL.Query().Get("resume"); token != "" {
+		s.resumeSession(w, r, token)
 		return
 	}
 
-	s.log.Println("Creating provider selector...")
+	// The connection keeps the configuration and providers it started
+	// with, however many times the server is reloaded.
+	cfg, providerList, release := s.acquire()
+	defer release()
+
 	config := providers.SessionConfig{
 		SampleRate:     16000,
 		LanguageCode:   "en-US",
 		InterimResults: true,
 	}
+	params, err := ParseSessionParams(r.URL.Query())
+	if err == nil {
+		params.apply(&config)
+		config.Models, err = cfg.Models.resolve(params.Models)
+	}
+	if err == nil {
+		err = cfg.Vocabularies.resolve(params.Vocabularies, &config)
+	}
+	if err != nil {
+		s.log.Printf("Invalid session params: %v\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.Printf("WebSocket upgrade failed: %v\n", err)
+		return
+	}
+
+	redaction := cfg.Redaction.merge(params.Redaction)
+	if redaction.ProviderNative {
+		config.Redaction = providers.RedactionConfig{
+			Profanity: redaction.Profanity,
+			PII:       redaction.PII,
+		}
+	}
+
+	s.log.Println("Creating provider selector...")
 
-	selector, err := NewProviderSelector(s.providers, config, s.log)
+	selectorConfig := cfg.Selector
+	if params.Strategy != "" {
+		selectorConfig.Strategy = params.Strategy
+	}
+	selector, err := NewProviderSelector(providerList, config, selectorConfig, s.log)
 	if err != nil {
 		s.log.Printf("Failed to create provider selector: %v\n", err)
 		conn.Close()
 		return
 	}
 
+	// Results go through the pipeline before the writer, or anyone
+	// else, gets to see them. Redaction comes last, so that it sees the
+	// text as the client will.
+	var session providers.Session = selector
+	stages := slices.Concat(cfg.Formatting.stages(), cfg.Stages, redaction.stages())
+	if len(stages) > 0 {
+		session = &resultPipeline{Session: selector, stages: stages}
+	}
+
 	webConn := &WebConn{
-		conn:    conn,
-		log:     s.log,
-		session: selector,
+		conn:         conn,
+		log:          s.log,
+		session:      session,
+		selector:     selector,
+		encoding:     config.EffectiveEncoding(),
+		diarize:      config.Diarization.Enabled,
+		writerDone:   make(chan struct{}),
+		closing:      make(chan struct{}),
+		flushTimeout: cfg.effectiveDrainFlushTimeout(),
+		pingInterval: cfg.effectivePingInterval(),
+		idleTimeout:  cfg.IdleTimeout,
+	}
+
+	for _, provider := range providerList {
+		webConn.pricePerMinute += cfg.Pricing[provider.Name()].PerMinute
+	}
+
+	// Only uncompressed audio can be measured, and gated, without decoding it.
+	frameSize := 2 * config.EffectiveChannels()
+	if webConn.encoding == providers.EncodingLinear16 {
+		webConn.bytesPerSecond = config.SampleRate * frameSize
+	}
+
+	if cfg.VAD.Enabled && webConn.bytesPerSecond > 0 {
+		webConn.gate = newVADGate(session, cfg.VAD, webConn.bytesPerSecond, frameSize)
+		webConn.session = webConn.gate
 	}
 
-	// Register connection for tracking
-	s.addConn(webConn)
+	// Register connection for tracking. The server may have started
+	// draining since the upgrade.
+	if !s.addConn(webConn) {
+		webConn.session.Close()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server draining"),
+			time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
 	defer s.removeConn(webConn)
+
+	if cfg.ResumeTimeout > 0 {
+		webConn.resumeTimeout = cfg.ResumeTimeout
+		webConn.attach = make(chan resumption)
+		webConn.token = s.registerSession(webConn)
+		defer s.unregisterSession(webConn.token)
+	}
 	webConn.Start()
 }
 
 func (wc *WebConn) Start() {
-	defer wc.conn.Close()
+	defer func() {
+		if conn := wc.currentConn(); conn != nil {
+			conn.Close()
+		}
+	}()
+
+	if wc.token != "" {
+		wc.writeJSON(WebSocketResponse{Type: ResponseSession, Session: wc.token})
+	}
 
 	wc.wg.Add(1)
 	go func() {
 		defer wc.wg.Done()
+		defer close(wc.writerDone)
 		wc.writer()
 	}()
 
+	if wc.pingInterval > 0 && wc.closing != nil {
+		wc.keepAlive(wc.currentConn())
+		wc.wg.Add(1)
+		go func() {
+			defer wc.wg.Done()
+			wc.pinger()
+		}()
+	}
+	if wc.idleTimeout > 0 {
+		wc.idleTimer = time.AfterFunc(wc.idleTimeout, wc.idle)
+	}
+
 	wc.reader()
+	// Clients can't resume the session anymore.
+	wc.quit()
+	if wc.idleTimer != nil {
+		wc.idleTimer.Stop()
+	}
+	switch {
+	case wc.draining.Load():
+		// The session has until the flush timeout to send the results
+		// of the audio it has.
+		wc.finish()
+		wc.flush()
+	case wc.idled.Load():
+		// Like for a client finishing the session, but with no more
+		// than the flush timeout to do it.
+		wc.finishRequested.Store(true)
+		wc.finish()
+		wc.flush()
+	}
 	wc.log.Println("Closing transcription session...")
 	// Close session, which will cancel context and allow writer to exit
 	// Important to call this _after_ wc.reader() exits.
 	wc.session.Close()
 	wc.wg.Wait()
+	wc.logSummary()
+	if conn := wc.currentConn(); conn != nil {
+		switch {
+		case wc.draining.Load():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server draining"),
+				time.Now().Add(time.Second))
+		case wc.slow.Load():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"),
+				time.Now().Add(time.Second))
+		}
+	}
 }
 
 // Stop gracefully closes the WebSocket connection and waits for all
 // goroutines to finish. This method is safe to call multiple times.
 func (wc *WebConn) Stop() {
+	wc.quit()
 	// Close the connection, which will cause reader() to exit
-	wc.conn.Close()
+	if conn := wc.currentConn(); conn != nil {
+		conn.Close()
+	}
 	// Wait for all goroutines to finish
 	wc.wg.Wait()
 }
 
+// quit ends the session, without waiting for its client to resume it.
+func (wc *WebConn) quit() {
+	if wc.closing != nil {
+		wc.closeOnce.Do(func() { close(wc.closing) })
+	}
+}
+
+func (wc *WebConn) currentConn() *websocket.Conn {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.conn
+}
+
+// write writes a response to conn, giving up after the write timeout. It
+// must be called with writeMu held.
+func (wc *WebConn) write(conn *websocket.Conn, v any) error {
+	conn.SetWriteDeadline(time.Now().Add(wc.writeTimeout()))
+	return conn.WriteJSON(v)
+}
+
 func (wc *WebConn) reader() {
 	var buf bytes.Buffer
+	conn := wc.currentConn()
 
 	for {
 		// Reuse the buffer
 		buf.Reset()
 
-		_, r, err := wc.conn.NextReader()
+		_, r, err := conn.NextReader()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if wc.canResume(err) {
+				if conn = wc.waitForResume(); conn != nil {
+					continue
+				}
+				break
+			}
+			// A draining, idle or slow connection is read until its
+			// deadline.
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) &&
+				!wc.draining.Load() && !wc.idled.Load() && !wc.slow.Load() {
 				wc.log.Printf("WebSocket read error: %v\n", err)
 			}
 			break
@@ -130,6 +435,36 @@ func (wc *WebConn) reader() {
 			continue
 		}
 
+		if req.Ack > 0 {
+			wc.ack(req.Ack)
+			if len(req.Buf) == 0 && req.Type == "" {
+				continue
+			}
+		}
+
+		switch req.Type {
+		case "":
+		case RequestFinish:
+			wc.finishRequested.Store(true)
+			wc.finish()
+			// No more audio is coming.
+			if wc.idleTimer != nil {
+				wc.idleTimer.Stop()
+			}
+			continue
+		default:
+			wc.log.Printf("Unknown request type %q\n", req.Type)
+			continue
+		}
+		// Reading goes on after finishing, for acknowledgements and the
+		// closing of the connection.
+		if wc.finished {
+			continue
+		}
+
+		wc.touch()
+		wc.audioBytes += int64(len(req.Buf))
+
 		// Send audio bytes to transcription session
 		if err := wc.session.SendAudio(req.Buf); err != nil {
 			if errors.Is(err, io.EOF) {
@@ -140,10 +475,41 @@ func (wc *WebConn) reader() {
 	}
 }
 
+// logSummary logs how much audio the connection carried.
+// It must only be called after the reader has exited.
+func (wc *WebConn) logSummary() {
+	var summary string
+	if wc.bytesPerSecond > 0 {
+		summary = fmt.Sprintf("Session summary: received %.1fs of audio",
+			float64(wc.audioBytes)/float64(wc.bytesPerSecond))
+	} else {
+		summary = fmt.Sprintf("Session summary: received %d bytes of %s audio",
+			wc.audioBytes, wc.encoding)
+	}
+	var gated float64
+	if wc.gate != nil {
+		gated = wc.gate.gatedSeconds()
+		summary += fmt.Sprintf(", gated %.1fs of silence", gated)
+	}
+	// Gated audio never reaches the providers, so it costs nothing.
+	if wc.bytesPerSecond > 0 && wc.pricePerMinute > 0 {
+		sent := float64(wc.audioBytes)/float64(wc.bytesPerSecond) - gated
+		summary += fmt.Sprintf(", cost about $%.4f", max(sent, 0)/60*wc.pricePerMinute)
+	}
+	wc.log.Println(summary)
+}
+
 func (wc *WebConn) writer() {
 	for {
 		result, err := wc.session.ReceiveTranscription()
 		if errors.Is(err, io.EOF) {
+			if wc.finishRequested.Load() {
+				wc.endTranscript()
+			}
+			return
+		}
+		if errors.Is(err, ErrSlowClient) {
+			wc.disconnectSlow()
 			return
 		}
 		if err != nil {
@@ -152,13 +518,54 @@ func (wc *WebConn) writer() {
 		}
 
 		response := WebSocketResponse{
+			ID:         result.SeqNum,
 			Sentence:   result.Text,
 			Confidence: result.Confidence,
+			Channel:    result.Channel,
+			Language:   result.LanguageCode,
+		}
+		for _, alt := range result.Alternatives {
+			response.Alternatives = append(response.Alternatives, WebSocketAlternative{
+				Sentence:   alt.Text,
+				Confidence: alt.Confidence,
+			})
+		}
+		if wc.diarize {
+			response.Speaker = result.Speaker
+			response.Words = make([]WebSocketWord, 0, len(result.Words))
+			for _, w := range result.Words {
+				response.Words = append(response.Words, WebSocketWord{
+					Word:       w.Text,
+					Start:      w.Start.Seconds(),
+					End:        w.End.Seconds(),
+					Confidence: w.Confidence,
+					Speaker:    w.Speaker,
+				})
+			}
 		}
 
-		if err := wc.conn.WriteJSON(response); err != nil {
+		if err := wc.send(response); err != nil {
+			if errors.Is(err, ErrSlowClient) {
+				// Unless flush gave up on it, the client is too
+				// slow.
+				if !wc.draining.Load() && !wc.idled.Load() {
+					wc.disconnectSlow()
+				}
+				return
+			}
 			wc.log.Printf("WebSocket write error: %v\n", err)
 			return
 		}
 	}
 }
+
+// disconnectSlow disconnects a client that doesn't keep up with its results,
+// ending its session.
+func (wc *WebConn) disconnectSlow() {
+	wc.log.Println("Client can't keep up with its results, disconnecting it")
+	wc.slow.Store(true)
+	if wc.selector != nil {
+		wc.selector.disconnectClient()
+	}
+	wc.interrupt()
+}
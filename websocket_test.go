@@ -448,3 +448,607 @@ func TestWebSocketProviderSessionCreationError(t *testing.T) {
 	logOutput := logBuffer.String()
 	assert.Contains(t, logOutput, "Failed to create provider selector")
 }
+
+func TestWebSocketVADGatesSilence(t *testing.T) {
+	// Create mock provider and session
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	// Setup expectations, SendAudio must never be called for silence
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(
+		mock.AnythingOfType("*context.cancelCtx"),
+		mock.AnythingOfType("providers.SessionConfig"),
+	).Return(mockSession, nil)
+
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF)
+	mockSession.EXPECT().Close().Return(nil)
+
+	// Create server with VAD enabled and thread-safe log buffer
+	cfg := DefaultConfig()
+	cfg.VAD.Enabled = true
+	logBuffer := &ThreadSafeBuffer{}
+	server := NewWithConfig("8081", cfg, mockProvider)
+	server.log = log.New(logBuffer, "", 0)
+
+	// Create test HTTP server
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	// Convert HTTP URL to WebSocket URL
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+
+	// Connect to WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+
+	// Send half a second of silence
+	for i := 0; i < 5; i++ {
+		err = conn.WriteJSON(WebSocketRequest{Buf: testChunk(0)})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, conn.Close())
+	// Give time for server-side cleanup
+	time.Sleep(100 * time.Millisecond)
+
+	// Verify the gated audio was reported
+	logOutput := logBuffer.String()
+	assert.Contains(t, logOutput, "Session summary: received 0.5s of audio, gated 0.5s of silence")
+}
+
+func TestWebSocketInvalidSessionParams(t *testing.T) {
+	// No sessions must be created for a rejected handshake
+	mockProvider := mocks.NewMockProvider(t)
+
+	logBuffer := &ThreadSafeBuffer{}
+	server := New("8081", mockProvider)
+	server.log = log.New(logBuffer, "", 0)
+
+	// Create test HTTP server
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	// Convert HTTP URL to WebSocket URL
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?encoding=mp3"
+
+	// The upgrade should be refused
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	assert.Contains(t, logBuffer.String(), "Invalid session params")
+}
+
+func TestWebSocketSeparateChannels(t *testing.T) {
+	// Create mock provider and session
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	// The negotiated channels must reach the provider
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(
+		mock.AnythingOfType("*context.cancelCtx"),
+		mock.MatchedBy(func(config providers.SessionConfig) bool {
+			return config.Channels == 2 && config.SeparateChannels
+		}),
+	).Return(mockSession, nil)
+
+	mockSession.EXPECT().ReceiveTranscription().Return(
+		providers.TranscriptionResult{
+			Text:         "How can I help",
+			IsFinal:      true,
+			ProviderName: "mock-provider",
+			Channel:      2,
+			ReceivedAt:   time.Now(),
+		}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().Return(nil)
+
+	// Create server with mock provider
+	server := New("8081", mockProvider)
+	server.log = log.New(io.Discard, "", 0)
+
+	// Create test HTTP server
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	// Convert HTTP URL to WebSocket URL
+	params := SessionParams{Channels: 2, SeparateChannels: true}
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + params.Query().Encode()
+
+	// Connect to WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The channel is passed on to the client
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "How can I help", response.Sentence)
+	assert.Equal(t, 2, response.Channel)
+
+	// Close connection
+	conn.Close()
+
+	// Give time for server-side cleanup
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestWebSocketDiarization(t *testing.T) {
+	result := providers.TranscriptionResult{
+		Text:         "Hello hi",
+		IsFinal:      true,
+		Confidence:   0.9,
+		ProviderName: "mock-provider",
+		Speaker:      1,
+		Words: []providers.Word{
+			{Text: "Hello", Start: 0, End: 500 * time.Millisecond, Confidence: 0.9, Speaker: 1},
+			{Text: "hi", Start: time.Second, End: 1500 * time.Millisecond, Confidence: 0.8, Speaker: 2},
+		},
+		ReceivedAt: time.Now(),
+	}
+
+	tests := []struct {
+		name     string
+		params   SessionParams
+		expected WebSocketResponse
+	}{
+		{
+			name:   "words and speakers with diarization",
+			params: SessionParams{Diarize: true},
+			expected: WebSocketResponse{
+				ID:         1,
+				Sentence:   "Hello hi",
+				Confidence: 0.9,
+				Speaker:    1,
+				Words: []WebSocketWord{
+					{Word: "Hello", Start: 0, End: 0.5, Confidence: 0.9, Speaker: 1},
+					{Word: "hi", Start: 1, End: 1.5, Confidence: 0.8, Speaker: 2},
+				},
+			},
+		},
+		{
+			name:   "plain sentence without diarization",
+			params: SessionParams{},
+			expected: WebSocketResponse{
+				ID:         1,
+				Sentence:   "Hello hi",
+				Confidence: 0.9,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create mock provider and session
+			mockProvider := mocks.NewMockProvider(t)
+			mockSession := mocks.NewMockSession(t)
+
+			mockProvider.EXPECT().Name().Return("mock-provider")
+			mockProvider.EXPECT().NewSession(
+				mock.AnythingOfType("*context.cancelCtx"),
+				mock.MatchedBy(func(config providers.SessionConfig) bool {
+					return config.Diarization.Enabled == tt.params.Diarize
+				}),
+			).Return(mockSession, nil)
+
+			mockSession.EXPECT().ReceiveTranscription().Return(result, nil).Once()
+			mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+			mockSession.EXPECT().Close().Return(nil)
+
+			// Create server with mock provider
+			server := New("8081", mockProvider)
+			server.log = log.New(io.Discard, "", 0)
+
+			// Create test HTTP server
+			testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+			defer testServer.Close()
+
+			// Convert HTTP URL to WebSocket URL
+			wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + tt.params.Query().Encode()
+
+			// Connect to WebSocket
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			var response WebSocketResponse
+			require.NoError(t, conn.ReadJSON(&response))
+			assert.Equal(t, tt.expected, response)
+
+			// Close connection
+			conn.Close()
+
+			// Give time for server-side cleanup
+			time.Sleep(100 * time.Millisecond)
+		})
+	}
+}
+
+func TestWebSocketVocabularies(t *testing.T) {
+	vocabularies, err := NewVocabularyStore("")
+	require.NoError(t, err)
+	require.NoError(t, vocabularies.Put(medical))
+
+	t.Run("vocabulary reaches the provider", func(t *testing.T) {
+		// Create mock provider and session
+		mockProvider := mocks.NewMockProvider(t)
+		mockSession := mocks.NewMockSession(t)
+
+		mockProvider.EXPECT().Name().Return("mock-provider")
+		mockProvider.EXPECT().NewSession(
+			mock.AnythingOfType("*context.cancelCtx"),
+			mock.MatchedBy(func(config providers.SessionConfig) bool {
+				return len(config.Phrases) == 3 && len(config.CustomClasses) == 1 &&
+					config.CustomClasses[0].Name == "drugs"
+			}),
+		).Return(mockSession, nil)
+
+		mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF)
+		mockSession.EXPECT().Close().Return(nil)
+
+		cfg := DefaultConfig()
+		cfg.Vocabularies = vocabularies
+		server := NewWithConfig("8081", cfg, mockProvider)
+		server.log = log.New(io.Discard, "", 0)
+
+		testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+		defer testServer.Close()
+
+		// Session phrases can refer to the classes of a vocabulary
+		params := SessionParams{
+			Phrases:      []providers.PhraseHint{{Text: "prescribe ${drugs}"}},
+			Vocabularies: []string{"medical"},
+		}
+		wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + params.Query().Encode()
+
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.NoError(t, err)
+		conn.Close()
+
+		// Give time for server-side cleanup
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	t.Run("unknown vocabulary is refused", func(t *testing.T) {
+		// No sessions must be created for a rejected handshake
+		mockProvider := mocks.NewMockProvider(t)
+
+		logBuffer := &ThreadSafeBuffer{}
+		cfg := DefaultConfig()
+		cfg.Vocabularies = vocabularies
+		server := NewWithConfig("8081", cfg, mockProvider)
+		server.log = log.New(logBuffer, "", 0)
+
+		testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+		defer testServer.Close()
+
+		wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?vocabulary=legal"
+
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		require.NotNil(t, resp)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		assert.Contains(t, logBuffer.String(), `Invalid session params: unknown vocabulary "legal"`)
+	})
+}
+
+func TestWebSocketModels(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Models = ModelConfig{
+		Defaults: map[string]string{"google": "latest_long"},
+		Allowed:  map[string][]string{"deepgram": {"nova-3-medical"}},
+	}
+
+	t.Run("models reach the provider", func(t *testing.T) {
+		// Create mock provider and session
+		mockProvider := mocks.NewMockProvider(t)
+		mockSession := mocks.NewMockSession(t)
+
+		mockProvider.EXPECT().Name().Return("mock-provider")
+		mockProvider.EXPECT().NewSession(
+			mock.AnythingOfType("*context.cancelCtx"),
+			mock.MatchedBy(func(config providers.SessionConfig) bool {
+				return config.Models["google"] == "latest_long" && config.Models["deepgram"] == "nova-3-medical"
+			}),
+		).Return(mockSession, nil)
+
+		mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF)
+		mockSession.EXPECT().Close().Return(nil)
+
+		server := NewWithConfig("8081", cfg, mockProvider)
+		server.log = log.New(io.Discard, "", 0)
+
+		testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+		defer testServer.Close()
+
+		params := SessionParams{Models: map[string]string{"deepgram": "nova-3-medical"}}
+		wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + params.Query().Encode()
+
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.NoError(t, err)
+		conn.Close()
+
+		// Give time for server-side cleanup
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	t.Run("models outside the allowlist are refused", func(t *testing.T) {
+		// No sessions must be created for a rejected handshake
+		mockProvider := mocks.NewMockProvider(t)
+
+		logBuffer := &ThreadSafeBuffer{}
+		server := NewWithConfig("8081", cfg, mockProvider)
+		server.log = log.New(logBuffer, "", 0)
+
+		testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+		defer testServer.Close()
+
+		wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?model=google:phone_call"
+
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		require.NotNil(t, resp)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		assert.Contains(t, logBuffer.String(), `model "phone_call" is not allowed for google`)
+	})
+}
+
+func TestWebSocketLanguage(t *testing.T) {
+	// Create mock provider and session
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	// The negotiated languages must reach the provider
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(
+		mock.AnythingOfType("*context.cancelCtx"),
+		mock.MatchedBy(func(config providers.SessionConfig) bool {
+			return config.LanguageCode == "en-IN" &&
+				len(config.AlternativeLanguageCodes) == 1 && config.AlternativeLanguageCodes[0] == "hi-IN"
+		}),
+	).Return(mockSession, nil)
+
+	mockSession.EXPECT().ReceiveTranscription().Return(
+		providers.TranscriptionResult{
+			Text:         "namaste",
+			IsFinal:      true,
+			ProviderName: "mock-provider",
+			LanguageCode: "hi-in",
+			ReceivedAt:   time.Now(),
+		}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().Return(nil)
+
+	// Create server with mock provider
+	server := New("8081", mockProvider)
+	server.log = log.New(io.Discard, "", 0)
+
+	// Create test HTTP server
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	// Convert HTTP URL to WebSocket URL
+	params := SessionParams{LanguageCode: "en-IN", AlternativeLanguageCodes: []string{"hi-IN"}}
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + params.Query().Encode()
+
+	// Connect to WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The detected language is passed on to the client
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "namaste", response.Sentence)
+	assert.Equal(t, "hi-in", response.Language)
+
+	// Close connection
+	conn.Close()
+
+	// Give time for server-side cleanup
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestWebSocketRedaction(t *testing.T) {
+	const text = "Card 4111 1111 1111 1111, damn"
+
+	tests := []struct {
+		name           string
+		server         RedactionConfig
+		params         SessionParams
+		providerConfig providers.RedactionConfig
+		expected       string
+	}{
+		{
+			name:     "server redaction",
+			server:   RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}},
+			expected: "Card [CREDIT_CARD], damn",
+		},
+		{
+			name:     "session adds to the server redaction",
+			server:   RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}},
+			params:   SessionParams{Redaction: RedactionConfig{Profanity: true}},
+			expected: "Card [CREDIT_CARD], d***",
+		},
+		{
+			name:           "provider-native redaction",
+			params:         SessionParams{Redaction: RedactionConfig{Profanity: true, ProviderNative: true}},
+			providerConfig: providers.RedactionConfig{Profanity: true},
+			expected:       "Card 4111 1111 1111 1111, d***",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create mock provider and session
+			mockProvider := mocks.NewMockProvider(t)
+			mockSession := mocks.NewMockSession(t)
+
+			mockProvider.EXPECT().Name().Return("mock-provider")
+			mockProvider.EXPECT().NewSession(
+				mock.AnythingOfType("*context.cancelCtx"),
+				mock.MatchedBy(func(config providers.SessionConfig) bool {
+					return assert.ObjectsAreEqual(tt.providerConfig, config.Redaction)
+				}),
+			).Return(mockSession, nil)
+
+			mockSession.EXPECT().ReceiveTranscription().Return(
+				providers.TranscriptionResult{
+					Text:         text,
+					IsFinal:      true,
+					ProviderName: "mock-provider",
+					ReceivedAt:   time.Now(),
+				}, nil).Once()
+			mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+			mockSession.EXPECT().Close().Return(nil)
+
+			// Create server with mock provider
+			cfg := DefaultConfig()
+			cfg.Redaction = tt.server
+			server := NewWithConfig("8081", cfg, mockProvider)
+			server.log = log.New(io.Discard, "", 0)
+
+			// Create test HTTP server
+			testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+			defer testServer.Close()
+
+			// Convert HTTP URL to WebSocket URL
+			wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + tt.params.Query().Encode()
+
+			// Connect to WebSocket
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			var response WebSocketResponse
+			require.NoError(t, conn.ReadJSON(&response))
+			assert.Equal(t, tt.expected, response.Sentence)
+
+			// Close connection
+			conn.Close()
+
+			// Give time for server-side cleanup
+			time.Sleep(100 * time.Millisecond)
+		})
+	}
+}
+
+func TestWebSocketFormatting(t *testing.T) {
+	// Create mock provider and session
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(mock.AnythingOfType("*context.cancelCtx"), mock.Anything).Return(mockSession, nil)
+
+	mockSession.EXPECT().ReceiveTranscription().Return(
+		providers.TranscriptionResult{
+			Text:         "damn that's twenty five dollars",
+			IsFinal:      true,
+			ProviderName: "mock-provider",
+			ReceivedAt:   time.Now(),
+		}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().Return(nil)
+
+	// Create server with formatting, a custom stage and redaction
+	cfg := DefaultConfig()
+	cfg.Formatting = FormattingConfig{Normalize: true, InverseTextNormalization: true}
+	cfg.Stages = []ResultStage{ResultStageFunc(func(result *providers.TranscriptionResult) {
+		result.Text = strings.ReplaceAll(result.Text, "that's", "that is")
+	})}
+	cfg.Redaction = RedactionConfig{Profanity: true}
+	server := NewWithConfig("8081", cfg, mockProvider)
+	server.log = log.New(io.Discard, "", 0)
+
+	// Create test HTTP server
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	// Convert HTTP URL to WebSocket URL
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+
+	// Connect to WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Formatting first, then the custom stage, then redaction
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "D*** that is $25.", response.Sentence)
+
+	// Close connection
+	conn.Close()
+
+	// Give time for server-side cleanup
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestWebSocketAlternatives(t *testing.T) {
+	// Create mock provider and session
+	mockProvider := mocks.NewMockProvider(t)
+	mockSession := mocks.NewMockSession(t)
+
+	mockProvider.EXPECT().Name().Return("mock-provider")
+	mockProvider.EXPECT().NewSession(
+		mock.AnythingOfType("*context.cancelCtx"),
+		mock.MatchedBy(func(config providers.SessionConfig) bool {
+			return config.MaxAlternatives == 2
+		}),
+	).Return(mockSession, nil)
+
+	mockSession.EXPECT().ReceiveTranscription().Return(
+		providers.TranscriptionResult{
+			Text:         "my number is 4111 1111 1111 1111",
+			Confidence:   0.9,
+			IsFinal:      true,
+			ProviderName: "mock-provider",
+			Alternatives: []providers.Alternative{
+				{Text: "my number is 4111111111111111", Confidence: 0.7},
+			},
+			ReceivedAt: time.Now(),
+		}, nil).Once()
+	mockSession.EXPECT().ReceiveTranscription().Return(providers.TranscriptionResult{}, io.EOF).Once()
+	mockSession.EXPECT().Close().Return(nil)
+
+	// Create server with mock provider, redacting card numbers
+	cfg := DefaultConfig()
+	cfg.Redaction = RedactionConfig{PII: []providers.PIIType{providers.PIICreditCard}}
+	server := NewWithConfig("8081", cfg, mockProvider)
+	server.log = log.New(io.Discard, "", 0)
+
+	// Create test HTTP server
+	testServer := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer testServer.Close()
+
+	// Convert HTTP URL to WebSocket URL
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?alternatives=2"
+
+	// Connect to WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Alternatives are redacted like the sentence
+	var response WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, "my number is [CREDIT_CARD]", response.Sentence)
+	assert.Equal(t, []WebSocketAlternative{
+		{Sentence: "my number is [CREDIT_CARD]", Confidence: 0.7},
+	}, response.Alternatives)
+
+	// Close connection
+	conn.Close()
+
+	// Give time for server-side cleanup
+	time.Sleep(100 * time.Millisecond)
+}